@@ -2,34 +2,147 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo for PRINTER_TIMEZONE
 
 	"github.com/codyseavey/3d-printer/backend/internal/api"
 	"github.com/codyseavey/3d-printer/backend/internal/handlers"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
 )
 
 func main() {
-	timelapseDir := os.Getenv("TIMELAPSE_DIR")
-	if timelapseDir == "" {
-		timelapseDir = "./videos"
+	printerConfigs, err := loadPrinterConfigs()
+	if err != nil {
+		log.Fatalf("Invalid printer configuration: %v", err)
 	}
-	if _, err := os.Stat(timelapseDir); err != nil {
-		log.Printf("WARNING: timelapse directory %s does not exist: %v", timelapseDir, err)
+	for _, p := range printerConfigs {
+		if _, err := os.Stat(p.TimelapseDir); err != nil {
+			log.Printf("WARNING: timelapse directory %s does not exist: %v", p.TimelapseDir, err)
+		}
 	}
 
-	streamPath := os.Getenv("STREAM_M3U8_PATH")
-	if streamPath == "" {
-		streamPath = "./live/stream.m3u8"
+	rescanInterval := 5 * time.Minute
+	if v := os.Getenv("TIMELAPSE_RESCAN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid TIMELAPSE_RESCAN_INTERVAL %q", v)
+		}
+		rescanInterval = d
+	}
+
+	scanDepth := handlers.DefaultScanDepth
+	if v := os.Getenv("TIMELAPSE_SCAN_DEPTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid TIMELAPSE_SCAN_DEPTH %q", v)
+		}
+		scanDepth = n
+	}
+
+	dates, err := loadDateParser()
+	if err != nil {
+		log.Fatalf("Invalid timelapse date configuration: %v", err)
+	}
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	trashRetention := handlers.DefaultTrashRetention
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			log.Fatalf("Invalid TRASH_RETENTION_DAYS %q", v)
+		}
+		trashRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	previewWorkers := handlers.DefaultPreviewWorkers
+	if v := os.Getenv("PREVIEW_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid PREVIEW_WORKERS %q", v)
+		}
+		previewWorkers = n
+	}
+
+	var quarantine bool
+	if v := os.Getenv("QUARANTINE_UNHEALTHY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid QUARANTINE_UNHEALTHY %q", v)
+		}
+		quarantine = b
 	}
 
-	timelapse := handlers.NewTimelapseHandler(timelapseDir)
-	stream := handlers.NewStreamHandler(streamPath)
-	router := api.SetupRouter(timelapse, stream)
+	syncInterval := handlers.DefaultSyncInterval
+	if v := os.Getenv("SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid SYNC_INTERVAL %q", v)
+		}
+		syncInterval = d
+	}
+
+	syncHistory := handlers.DefaultSyncHistory
+	if v := os.Getenv("SYNC_HISTORY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid SYNC_HISTORY %q", v)
+		}
+		syncHistory = n
+	}
+
+	retention, err := loadRetentionPolicy()
+	if err != nil {
+		log.Fatalf("Invalid retention configuration: %v", err)
+	}
+
+	remote, err := loadRemoteStorage()
+	if err != nil {
+		log.Fatalf("Invalid remote storage configuration: %v", err)
+	}
+
+	printers, err := handlers.NewPrinterRegistry(printerConfigs,
+		handlers.WithScanDepth(scanDepth),
+		handlers.WithDateParser(dates),
+		handlers.WithTrashRetention(trashRetention),
+		handlers.WithRetentionPolicy(retention),
+		handlers.WithFFmpeg(os.Getenv("FFMPEG_PATH")),
+		handlers.WithPreviewWorkers(previewWorkers),
+		handlers.WithQuarantine(quarantine),
+		handlers.WithSyncHistory(syncHistory),
+		remote,
+	)
+	if err != nil {
+		log.Fatalf("Invalid printer configuration: %v", err)
+	}
+
+	// "server sync" mirrors every printer once and exits, for running
+	// from a timer instead of in the background
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(syncOnce(bgCtx, printers))
+	}
+
+	for _, p := range printers.Printers() {
+		go p.Timelapses.Catalog().Run(bgCtx, rescanInterval)
+		go p.Timelapses.Trash().Run(bgCtx, time.Hour)
+		go p.Timelapses.Retention().Run(bgCtx, time.Hour)
+		go p.Timelapses.Previews().Run(bgCtx, rescanInterval)
+		go p.Timelapses.Quarantine().Run(bgCtx, time.Hour)
+		go p.Timelapses.Remote().Run(bgCtx, rescanInterval)
+		go p.Timelapses.Syncer().Run(bgCtx, syncInterval)
+	}
+
+	router := api.SetupRouter(printers)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -56,6 +169,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -63,6 +177,224 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
+	for _, p := range printers.Printers() {
+		if err := p.Timelapses.Close(); err != nil {
+			log.Printf("Failed to close %s: %v", p.Config.ID, err)
+		}
+	}
 
 	log.Println("Server exited")
 }
+
+// syncOnce mirrors every printer with sync configured and returns the
+// exit code.
+func syncOnce(ctx context.Context, printers *handlers.PrinterRegistry) int {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	code := 0
+	for _, p := range printers.Printers() {
+		syncer := p.Timelapses.Syncer()
+		if !syncer.Enabled() {
+			continue
+		}
+		run, err := syncer.Sync(ctx)
+		if err != nil {
+			log.Printf("Sync of %s failed: %v", p.Config.ID, err)
+			code = 1
+			continue
+		}
+		log.Printf("Synced %s: %d files, %d bytes", p.Config.ID, len(run.Files), run.Bytes)
+	}
+	for _, p := range printers.Printers() {
+		if err := p.Timelapses.Close(); err != nil {
+			log.Printf("Failed to close %s: %v", p.Config.ID, err)
+		}
+	}
+	return code
+}
+
+// loadDateParser reads TIMELAPSE_DATE_LAYOUTS (comma-separated Go time
+// layouts) and PRINTER_TIMEZONE (IANA zone the printer writes filenames in).
+func loadDateParser() (*handlers.DateParser, error) {
+	layouts := handlers.DefaultDateLayouts
+	if v := os.Getenv("TIMELAPSE_DATE_LAYOUTS"); v != "" {
+		layouts = nil
+		for _, layout := range strings.Split(v, ",") {
+			if layout = strings.TrimSpace(layout); layout != "" {
+				layouts = append(layouts, layout)
+			}
+		}
+	}
+
+	loc := time.UTC
+	if v := os.Getenv("PRINTER_TIMEZONE"); v != "" {
+		var err error
+		if loc, err = time.LoadLocation(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return handlers.NewDateParser(layouts, loc)
+}
+
+// loadPrinterConfigs reads the printer registry from PRINTERS_CONFIG. Without
+// it, TIMELAPSE_DIR and STREAM_M3U8_PATH describe a single printer served
+// from the original /videos and /live paths.
+func loadPrinterConfigs() ([]handlers.PrinterConfig, error) {
+	if path := os.Getenv("PRINTERS_CONFIG"); path != "" {
+		return handlers.LoadPrinterConfigs(path)
+	}
+
+	timelapseDir := os.Getenv("TIMELAPSE_DIR")
+	if timelapseDir == "" {
+		timelapseDir = "./videos"
+	}
+	streamPath := os.Getenv("STREAM_M3U8_PATH")
+	if streamPath == "" {
+		streamPath = "./live/stream.m3u8"
+	}
+
+	sync, err := loadSyncConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return []handlers.PrinterConfig{{
+		ID:           "default",
+		Name:         "Printer",
+		TimelapseDir: timelapseDir,
+		StreamPath:   streamPath,
+		VideosURL:    handlers.DefaultVideosURL,
+		StreamURL:    "/live/stream.m3u8",
+		Sync:         sync,
+	}}, nil
+}
+
+// loadSyncConfig reads PRINTER_FTP_HOST, PRINTER_FTP_USER and
+// PRINTER_FTP_PASSWORD, with the optional PRINTER_FTP_PORT and
+// PRINTER_FTP_DIR. Without a host the printer is not synced.
+func loadSyncConfig() (*handlers.SyncConfig, error) {
+	host := os.Getenv("PRINTER_FTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+	cfg := &handlers.SyncConfig{
+		Host:      host,
+		User:      os.Getenv("PRINTER_FTP_USER"),
+		Password:  os.Getenv("PRINTER_FTP_PASSWORD"),
+		RemoteDir: os.Getenv("PRINTER_FTP_DIR"),
+	}
+	if v := os.Getenv("PRINTER_FTP_PORT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid PRINTER_FTP_PORT %q", v)
+		}
+		cfg.Port = n
+	}
+	return cfg, nil
+}
+
+// loadRetentionPolicy reads the RETENTION_* variables. With none set the
+// policy removes nothing.
+func loadRetentionPolicy() (models.RetentionPolicy, error) {
+	policy := models.RetentionPolicy{ProtectStarred: true}
+
+	if v := os.Getenv("RETENTION_MAX_AGE_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid RETENTION_MAX_AGE_DAYS %q", v)
+		}
+		policy.MaxAgeDays = n
+	}
+	if v := os.Getenv("RETENTION_MAX_TOTAL_SIZE"); v != "" {
+		n, err := parseByteSize(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid RETENTION_MAX_TOTAL_SIZE %q: %w", v, err)
+		}
+		policy.MaxTotalBytes = n
+	}
+	if v := os.Getenv("RETENTION_KEEP_NEWEST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid RETENTION_KEEP_NEWEST %q", v)
+		}
+		policy.KeepNewest = n
+	}
+	if v := os.Getenv("RETENTION_PROTECT_STARRED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid RETENTION_PROTECT_STARRED %q", v)
+		}
+		policy.ProtectStarred = b
+	}
+	return policy, nil
+}
+
+// loadRemoteStorage reads REMOTE_STORAGE, a file:// or s3://bucket/prefix
+// URL, and the S3_* and REMOTE_* variables that go with it. Each printer
+// keeps its videos under its ID. Without REMOTE_STORAGE nothing is
+// offloaded.
+func loadRemoteStorage() (handlers.TimelapseOption, error) {
+	raw := os.Getenv("REMOTE_STORAGE")
+	if raw == "" {
+		return handlers.WithRemoteStorage(nil, 0, models.RemoteURLsPresign), nil
+	}
+
+	cfg := storage.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_PATH_STYLE %q", v)
+		}
+		cfg.PathStyle = b
+	}
+	store, err := storage.FromURL(raw, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var after time.Duration
+	if v := os.Getenv("REMOTE_OFFLOAD_AFTER_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid REMOTE_OFFLOAD_AFTER_DAYS %q", v)
+		}
+		after = time.Duration(days) * 24 * time.Hour
+	}
+
+	urls := models.RemoteURLsPresign
+	if v := os.Getenv("REMOTE_URLS"); v != "" {
+		if v != models.RemoteURLsPresign && v != models.RemoteURLsProxy {
+			return nil, fmt.Errorf("invalid REMOTE_URLS %q (use presign or proxy)", v)
+		}
+		urls = v
+	}
+
+	log.Printf("Remote storage: %v", store)
+	return handlers.WithRemoteStorage(store, after, urls), nil
+}
+
+// parseByteSize parses sizes like "500G", "1.5T" or "1048576" (binary units).
+func parseByteSize(v string) (int64, error) {
+	units := map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	unit := ""
+	if s != "" && strings.ContainsAny(s[len(s)-1:], "KMGT") {
+		unit = s[len(s)-1:]
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("not a size")
+	}
+	return int64(n * units[unit]), nil
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/handlers"
+)
+
+const (
+	// gzipMinSize is the smallest body worth compressing; below it the
+	// gzip framing outweighs the savings.
+	gzipMinSize = 1024
+
+	// API responses are revalidated on every use, which the ETags make
+	// cheap. Admin actions and health checks are never stored, and feed
+	// readers may reuse a feed for a few minutes.
+	apiCacheControl     = "no-cache"
+	noStoreCacheControl = "no-store"
+	feedCacheControl    = "public, max-age=300"
+)
+
+// cacheControl sets a default Cache-Control for a route or group. Handlers
+// that know better, such as the media server, override it.
+func cacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Next()
+	}
+}
+
+// conditional buffers JSON and XML responses so it can answer conditional
+// GETs and compress them. A successful GET gets a weak ETag from a hash of
+// the payload unless the handler set one, as the catalog-backed listings
+// and feeds do from the catalog generation, and If-None-Match or
+// If-Modified-Since against the validators turns it into a 304. Those
+// handlers answer 304 themselves before building anything. Bodies over
+// gzipMinSize are gzipped for clients that accept it. Everything else,
+// from video files to the ZIP archive stream, passes straight through.
+func conditional() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// gin presets the status of its own 404 and 405 responses
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: c.Writer.Status()}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+
+		c.Next()
+		w.finish(c.Request)
+	}
+}
+
+// bufferedWriter decides at the first write, from the Content-Type,
+// whether to hold the body back or pass it through.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status    int
+	decided   bool
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func (w *bufferedWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.buffering = compressible(w.Header().Get("Content-Type")) && w.Header().Get("Content-Encoding") == "" && w.status != http.StatusPartialContent
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.decide()
+	if !w.buffering {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.buffering {
+		return w.buf.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.decide()
+	if w.buffering {
+		return w.buf.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.decided && !w.buffering {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if w.buffering {
+		return w.buf.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.decided
+}
+
+// Flush is a no-op while buffering; the body goes out in finish.
+func (w *bufferedWriter) Flush() {
+	if w.decided && !w.buffering {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes a buffered response, or the status of one that never
+// wrote a body.
+func (w *bufferedWriter) finish(r *http.Request) {
+	if !w.decided {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+	if !w.buffering {
+		return
+	}
+
+	out := w.ResponseWriter
+	header := out.Header()
+	body := w.buf.Bytes()
+	header.Add("Vary", "Accept-Encoding")
+
+	if w.status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		etag := header.Get("ETag")
+		if etag == "" {
+			sum := sha256.Sum256(body)
+			etag = fmt.Sprintf(`W/"%x"`, sum[:16])
+			header.Set("ETag", etag)
+		}
+		lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+		if handlers.Fresh(r.Header, etag, lastModified) {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			out.WriteHeader(http.StatusNotModified)
+			out.WriteHeaderNow()
+			return
+		}
+	}
+
+	if len(body) < gzipMinSize || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		out.WriteHeader(w.status)
+		if _, err := out.Write(body); err != nil {
+			log.Printf("api: failed to write response: %v", err)
+		}
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	out.WriteHeader(w.status)
+	gz := gzip.NewWriter(out)
+	if _, err := gz.Write(body); err != nil {
+		log.Printf("api: failed to write response: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Printf("api: failed to write response: %v", err)
+	}
+}
+
+// compressible reports whether a Content-Type is one the conditional
+// middleware buffers.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/atom+xml", "application/rss+xml", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip with
+// a non-zero quality, by name or else through "*".
+func acceptsGzip(acceptEncoding string) bool {
+	starOK := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			v, err := strconv.ParseFloat(q, 64)
+			ok = err == nil && v > 0
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			return ok
+		case "*":
+			starOK = ok
+		}
+	}
+	return starOK
+}
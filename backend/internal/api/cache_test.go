@@ -0,0 +1,231 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func cacheTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(conditional())
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	router.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": strings.Repeat("timelapse ", 500)})
+	})
+	router.GET("/versioned", func(c *gin.Context) {
+		c.Header("ETag", `"v1"`)
+		c.Header("Last-Modified", time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	router.GET("/video", func(c *gin.Context) {
+		c.Data(http.StatusOK, "video/mp4", []byte(strings.Repeat("x", 2*gzipMinSize)))
+	})
+	router.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	})
+	router.DELETE("/empty", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func serve(router *gin.Engine, method, target string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestConditional_ETag(t *testing.T) {
+	router := cacheTestRouter()
+
+	w := serve(router, http.MethodGet, "/small", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("expected a JSON body with a weak ETag, got %d %q %s", w.Code, etag, w.Body.String())
+	}
+	if again := serve(router, http.MethodGet, "/small", nil).Header().Get("ETag"); again != etag {
+		t.Errorf("expected the same ETag for the same payload, got %s and %s", etag, again)
+	}
+
+	w = serve(router, http.MethodGet, "/small", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("expected an empty 304 with the ETag, got %d %q", w.Code, w.Body.String())
+	}
+	if w = serve(router, http.MethodGet, "/small", http.Header{"If-None-Match": {`W/"stale"`}}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", w.Code)
+	}
+
+	// Errors are never validated
+	w = serve(router, http.MethodGet, "/missing", http.Header{"If-None-Match": {"*"}})
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+		t.Errorf("expected a plain 404, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestConditional_HandlerValidators(t *testing.T) {
+	router := cacheTestRouter()
+
+	w := serve(router, http.MethodGet, "/versioned", nil)
+	if w.Header().Get("ETag") != `"v1"` {
+		t.Fatalf("expected the handler's ETag to be kept, got %s", w.Header().Get("ETag"))
+	}
+	if w = serve(router, http.MethodGet, "/versioned", http.Header{"If-None-Match": {`W/"v1"`}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 comparing ETags weakly, got %d", w.Code)
+	}
+
+	since := time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	if w = serve(router, http.MethodGet, "/versioned", http.Header{"If-Modified-Since": {since}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unmodified response, got %d", w.Code)
+	}
+	before := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	if w = serve(router, http.MethodGet, "/versioned", http.Header{"If-Modified-Since": {before}}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a modified response, got %d", w.Code)
+	}
+}
+
+func TestConditional_Gzip(t *testing.T) {
+	router := cacheTestRouter()
+	accept := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+
+	w := serve(router, http.MethodGet, "/large", accept)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped response, got %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := serve(router, http.MethodGet, "/large", nil)
+	if string(body) != plain.Body.String() || plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected the same body uncompressed without Accept-Encoding")
+	}
+	if w.Header().Get("ETag") != plain.Header().Get("ETag") {
+		t.Errorf("expected one ETag for both encodings")
+	}
+
+	if w = serve(router, http.MethodGet, "/small", accept); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a small response to stay uncompressed")
+	}
+	w = serve(router, http.MethodGet, "/video", accept)
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("ETag") != "" || w.Body.Len() != 2*gzipMinSize {
+		t.Errorf("expected a video to pass through untouched, got %v", w.Header())
+	}
+	if w = serve(router, http.MethodDelete, "/empty", nil); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 for an empty response, got %d", w.Code)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br, *", true},
+		{"gzip;q=0, *", false},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCacheControlRoutes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, _, _ := setupTestRouter(t)
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodGet, "/health", noStoreCacheControl},
+		{http.MethodGet, "/api/timelapses", apiCacheControl},
+		{http.MethodGet, "/api/stream/status", apiCacheControl},
+		{http.MethodGet, "/api/printers/default/timelapses", apiCacheControl},
+		{http.MethodGet, "/feeds/timelapses.atom", feedCacheControl},
+		{http.MethodPost, "/api/retention/run", noStoreCacheControl},
+		{http.MethodPost, "/api/printers/default/retention/run", noStoreCacheControl},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.path, http.Header{"Authorization": {"Bearer secret"}})
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s %s: expected Cache-Control %q, got %q", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestTimelapsesNotModified(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, timelapseDir, _ := setupTestRouter(t)
+	name := "video_2024-07-01_10-00-00.mp4"
+	if err := os.WriteFile(filepath.Join(timelapseDir, name), []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/api/timelapses", "/api/timelapses/" + name, "/api/timelapses/search?q=video"} {
+		w := serve(router, http.MethodGet, target, nil)
+		etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+		if w.Code != http.StatusOK || etag == "" || lastModified == "" {
+			t.Fatalf("expected 200 with validators from %s, got %d %v", target, w.Code, w.Header())
+		}
+		if w = serve(router, http.MethodGet, target, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+			t.Errorf("expected 304 for an unchanged %s, got %d", target, w.Code)
+		}
+		if w = serve(router, http.MethodGet, target, http.Header{"If-Modified-Since": {lastModified}}); w.Code != http.StatusNotModified {
+			t.Errorf("expected 304 for %s unmodified since %s, got %d", target, lastModified, w.Code)
+		}
+	}
+
+	// Metadata is not part of the catalog but still changes the listing
+	w := serve(router, http.MethodGet, "/api/timelapses", nil)
+	etag := w.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodPatch, "/api/timelapses/"+name+"/meta", strings.NewReader(`{"starred":true}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if w = serve(router, http.MethodGet, "/api/timelapses", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after a metadata change, got %d", w.Code)
+	}
+}
+
+func TestStreamStatusLastModified(t *testing.T) {
+	router, _, m3u8Path := setupTestRouter(t)
+	written := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(m3u8Path, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offline since the playlist went stale
+	w := serve(router, http.MethodGet, "/api/stream/status", nil)
+	want := written.Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := w.Header().Get("Last-Modified"); got != want {
+		t.Errorf("expected Last-Modified %s, got %q", want, got)
+	}
+	if w = serve(router, http.MethodGet, "/api/stream/status", http.Header{"If-Modified-Since": {want}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+}
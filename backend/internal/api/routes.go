@@ -1,9 +1,12 @@
 package api
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/cors"
@@ -12,8 +15,18 @@ import (
 	"github.com/codyseavey/3d-printer/backend/internal/handlers"
 )
 
-func SetupRouter(timelapse *handlers.TimelapseHandler, stream *handlers.StreamHandler) *gin.Engine {
+// SetupRouter serves every printer under /api/printers/:id. The unscoped
+// /api routes serve the default printer, as they did before multiple
+// printers were supported.
+func SetupRouter(printers *handlers.PrinterRegistry) *gin.Engine {
+	timelapse := printers.Default().Timelapses
+	stream := printers.Default().Stream
+
 	router := gin.Default()
+	// Nested timelapses are addressed as one path segment with escaped
+	// slashes (2024%2F07%2Fvideo.mp4), so route on the raw path
+	router.UseRawPath = true
+	router.UnescapePathValues = true
 
 	frontendPath := os.Getenv("FRONTEND_DIST_PATH")
 	serveFrontend := frontendPath != "" && dirExists(frontendPath)
@@ -24,16 +37,111 @@ func SetupRouter(timelapse *handlers.TimelapseHandler, stream *handlers.StreamHa
 	} else {
 		config.AllowOrigins = []string{"http://localhost:5173", "http://localhost:3000"}
 	}
-	config.AllowMethods = []string{"GET", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
 	router.Use(cors.New(config))
+	router.Use(conditional())
 
-	router.GET("/health", handlers.Health)
+	router.GET("/health", cacheControl(noStoreCacheControl), handlers.Health)
 
-	apiGroup := router.Group("/api")
+	feeds := router.Group("/feeds", cacheControl(feedCacheControl))
+	{
+		feeds.GET("/timelapses.atom", timelapse.AtomFeed)
+		feeds.GET("/timelapses.rss", timelapse.RSSFeed)
+		feeds.GET("/printers/:id/timelapses.atom", printers.Timelapses((*handlers.TimelapseHandler).AtomFeed))
+		feeds.GET("/printers/:id/timelapses.rss", printers.Timelapses((*handlers.TimelapseHandler).RSSFeed))
+	}
+
+	apiGroup := router.Group("/api", cacheControl(apiCacheControl))
 	{
 		apiGroup.GET("/timelapses", timelapse.List)
+		apiGroup.GET("/timelapses/search", timelapse.Search)
+		apiGroup.GET("/timelapses/health", timelapse.HealthReport)
+		apiGroup.GET("/timelapses/duplicates", timelapse.Duplicates)
+		apiGroup.POST("/timelapses/archive", timelapse.Archive)
+		apiGroup.GET("/timelapses/:filename", timelapse.Get)
+		apiGroup.GET("/timelapses/:filename/playable", timelapse.Playable)
+		apiGroup.GET("/timelapses/:filename/content", timelapse.Content)
+		apiGroup.GET("/timelapses/:filename/sprite.jpg", timelapse.Sprite)
+		apiGroup.GET("/timelapses/:filename/thumbnails.vtt", timelapse.ThumbnailTrack)
+		apiGroup.GET("/timelapses/:filename/transcode", timelapse.TranscodeStatus)
 		apiGroup.GET("/stream/status", stream.Status)
+		apiGroup.GET("/trash", timelapse.ListTrash)
+		apiGroup.GET("/retention", timelapse.RetentionStatus)
+		apiGroup.GET("/retention/dry-run", timelapse.RetentionDryRun)
+		apiGroup.GET("/retention/log", timelapse.RetentionLog)
+		apiGroup.GET("/stats", timelapse.Stats)
+		apiGroup.GET("/remote", timelapse.RemoteStatus)
+		apiGroup.GET("/sync/status", timelapse.SyncStatus)
+		apiGroup.GET("/sync/history", timelapse.SyncHistory)
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	admin := apiGroup.Group("", cacheControl(noStoreCacheControl), requireAdmin(adminToken))
+	{
+		admin.DELETE("/timelapses/:filename", timelapse.Delete)
+		admin.PUT("/timelapses/:filename/meta", timelapse.PutMetadata)
+		admin.PATCH("/timelapses/:filename/meta", timelapse.PatchMetadata)
+		admin.POST("/timelapses/:filename/clip", timelapse.CreateClip)
+		admin.POST("/timelapses/:filename/transcode", timelapse.StartTranscode)
+		admin.POST("/trash/:filename/restore", timelapse.RestoreTrash)
+		admin.DELETE("/trash/:filename", timelapse.PurgeTrash)
+		admin.POST("/retention/run", timelapse.RunRetention)
+		admin.POST("/timelapses/health/quarantine", timelapse.RunQuarantine)
+		admin.POST("/timelapses/duplicates/dedupe", timelapse.Dedupe)
+		admin.POST("/remote/offload", timelapse.RunOffload)
+		admin.POST("/sync/run", timelapse.RunSync)
+		admin.GET("/sync/pins", timelapse.SyncPins)
+		admin.POST("/sync/pins/approve", timelapse.ApprovePin)
+		admin.DELETE("/sync/pins", timelapse.ResetPin)
+	}
+
+	apiGroup.GET("/printers", printers.List)
+	printer := apiGroup.Group("/printers/:id")
+	{
+		printer.GET("/timelapses", printers.Timelapses((*handlers.TimelapseHandler).List))
+		printer.GET("/timelapses/search", printers.Timelapses((*handlers.TimelapseHandler).Search))
+		printer.GET("/timelapses/health", printers.Timelapses((*handlers.TimelapseHandler).HealthReport))
+		printer.GET("/timelapses/duplicates", printers.Timelapses((*handlers.TimelapseHandler).Duplicates))
+		printer.POST("/timelapses/archive", printers.Timelapses((*handlers.TimelapseHandler).Archive))
+		printer.GET("/timelapses/:filename", printers.Timelapses((*handlers.TimelapseHandler).Get))
+		printer.GET("/timelapses/:filename/playable", printers.Timelapses((*handlers.TimelapseHandler).Playable))
+		printer.GET("/timelapses/:filename/content", printers.Timelapses((*handlers.TimelapseHandler).Content))
+		printer.GET("/timelapses/:filename/sprite.jpg", printers.Timelapses((*handlers.TimelapseHandler).Sprite))
+		printer.GET("/timelapses/:filename/thumbnails.vtt", printers.Timelapses((*handlers.TimelapseHandler).ThumbnailTrack))
+		printer.GET("/timelapses/:filename/transcode", printers.Timelapses((*handlers.TimelapseHandler).TranscodeStatus))
+		printer.GET("/stream/status", printers.Stream((*handlers.StreamHandler).Status))
+		printer.GET("/trash", printers.Timelapses((*handlers.TimelapseHandler).ListTrash))
+		printer.GET("/retention", printers.Timelapses((*handlers.TimelapseHandler).RetentionStatus))
+		printer.GET("/retention/dry-run", printers.Timelapses((*handlers.TimelapseHandler).RetentionDryRun))
+		printer.GET("/retention/log", printers.Timelapses((*handlers.TimelapseHandler).RetentionLog))
+		printer.GET("/stats", printers.Timelapses((*handlers.TimelapseHandler).Stats))
+		printer.GET("/remote", printers.Timelapses((*handlers.TimelapseHandler).RemoteStatus))
+		printer.GET("/sync/status", printers.Timelapses((*handlers.TimelapseHandler).SyncStatus))
+		printer.GET("/sync/history", printers.Timelapses((*handlers.TimelapseHandler).SyncHistory))
+	}
+
+	printerAdmin := printer.Group("", cacheControl(noStoreCacheControl), requireAdmin(adminToken))
+	{
+		printerAdmin.DELETE("/timelapses/:filename", printers.Timelapses((*handlers.TimelapseHandler).Delete))
+		printerAdmin.PUT("/timelapses/:filename/meta", printers.Timelapses((*handlers.TimelapseHandler).PutMetadata))
+		printerAdmin.PATCH("/timelapses/:filename/meta", printers.Timelapses((*handlers.TimelapseHandler).PatchMetadata))
+		printerAdmin.POST("/timelapses/:filename/clip", printers.Timelapses((*handlers.TimelapseHandler).CreateClip))
+		printerAdmin.POST("/timelapses/:filename/transcode", printers.Timelapses((*handlers.TimelapseHandler).StartTranscode))
+		printerAdmin.POST("/trash/:filename/restore", printers.Timelapses((*handlers.TimelapseHandler).RestoreTrash))
+		printerAdmin.DELETE("/trash/:filename", printers.Timelapses((*handlers.TimelapseHandler).PurgeTrash))
+		printerAdmin.POST("/retention/run", printers.Timelapses((*handlers.TimelapseHandler).RunRetention))
+		printerAdmin.POST("/timelapses/health/quarantine", printers.Timelapses((*handlers.TimelapseHandler).RunQuarantine))
+		printerAdmin.POST("/timelapses/duplicates/dedupe", printers.Timelapses((*handlers.TimelapseHandler).Dedupe))
+		printerAdmin.POST("/remote/offload", printers.Timelapses((*handlers.TimelapseHandler).RunOffload))
+		printerAdmin.POST("/sync/run", printers.Timelapses((*handlers.TimelapseHandler).RunSync))
+		printerAdmin.GET("/sync/pins", printers.Timelapses((*handlers.TimelapseHandler).SyncPins))
+		printerAdmin.POST("/sync/pins/approve", printers.Timelapses((*handlers.TimelapseHandler).ApprovePin))
+		printerAdmin.DELETE("/sync/pins", printers.Timelapses((*handlers.TimelapseHandler).ResetPin))
+	}
+
+	if serve, _ := strconv.ParseBool(os.Getenv("SERVE_MEDIA")); serve {
+		registerMedia(router, printers)
 	}
 
 	if serveFrontend {
@@ -45,11 +153,11 @@ func SetupRouter(timelapse *handlers.TimelapseHandler, stream *handlers.StreamHa
 			c.File(indexPath)
 		})
 
-		// SPA fallback (exclude API and nginx-served paths)
+		// SPA fallback (exclude API, feeds and nginx-served paths)
 		router.NoRoute(func(c *gin.Context) {
 			path := c.Request.URL.Path
 
-			if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/live") || strings.HasPrefix(path, "/videos") {
+			if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/feeds") || strings.HasPrefix(path, "/live") || strings.HasPrefix(path, "/videos") {
 				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 				return
 			}
@@ -61,6 +169,24 @@ func SetupRouter(timelapse *handlers.TimelapseHandler, stream *handlers.StreamHa
 	return router
 }
 
+// registerMedia serves /videos and /live from the backend itself, for
+// deployments without the nginx config in front.
+func registerMedia(router *gin.Engine, printers *handlers.PrinterRegistry) {
+	media, err := handlers.NewMediaServer(printers.MediaMounts())
+	if err != nil {
+		log.Printf("WARNING: not serving media: %v", err)
+		return
+	}
+	for _, root := range media.Roots() {
+		if slices.Contains([]string{"/api", "/assets", "/feeds", "/health"}, root) {
+			log.Printf("WARNING: not serving media under %s, it is used by the app", root)
+			continue
+		}
+		router.GET(root+"/*filepath", media.Serve)
+		router.HEAD(root+"/*filepath", media.Serve)
+	}
+}
+
 func dirExists(path string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
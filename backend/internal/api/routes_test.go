@@ -2,16 +2,23 @@ package api
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/codyseavey/3d-printer/backend/internal/ftps/ftpstest"
 	"github.com/codyseavey/3d-printer/backend/internal/handlers"
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
 	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+	"github.com/codyseavey/3d-printer/backend/internal/tlspin"
 )
 
 func setupTestRouter(t *testing.T) (*gin.Engine, string, string) {
@@ -26,13 +33,26 @@ func setupTestRouter(t *testing.T) (*gin.Engine, string, string) {
 		t.Fatal(err)
 	}
 
-	timelapse := handlers.NewTimelapseHandler(timelapseDir)
-	stream := handlers.NewStreamHandler(m3u8Path)
-	router := SetupRouter(timelapse, stream)
+	router := SetupRouter(singlePrinter(t, timelapseDir, m3u8Path))
 
 	return router, timelapseDir, m3u8Path
 }
 
+func singlePrinter(t *testing.T, timelapseDir, m3u8Path string) *handlers.PrinterRegistry {
+	t.Helper()
+
+	printers, err := handlers.NewPrinterRegistry([]handlers.PrinterConfig{{
+		ID:           "default",
+		TimelapseDir: timelapseDir,
+		StreamPath:   m3u8Path,
+		VideosURL:    handlers.DefaultVideosURL,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return printers
+}
+
 func TestHealthRoute(t *testing.T) {
 	router, _, _ := setupTestRouter(t)
 
@@ -69,10 +89,11 @@ func TestTimelapsesRoute(t *testing.T) {
 		t.Fatalf("expected 200, got %d", w.Code)
 	}
 
-	var result []models.Timelapse
-	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
+	result := page.Items
 	if len(result) != 1 {
 		t.Errorf("expected 1 timelapse, got %d", len(result))
 	}
@@ -136,9 +157,7 @@ func TestSPAFallback(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	timelapse := handlers.NewTimelapseHandler(t.TempDir())
-	stream := handlers.NewStreamHandler(m3u8Path)
-	router := SetupRouter(timelapse, stream)
+	router := SetupRouter(singlePrinter(t, t.TempDir(), m3u8Path))
 
 	// Root should serve index.html
 	w := httptest.NewRecorder()
@@ -219,3 +238,517 @@ func TestCORSRejectsUnknownOrigin(t *testing.T) {
 		t.Error("CORS should not allow unknown origins")
 	}
 }
+
+func TestTimelapseDetailRoute(t *testing.T) {
+	router, timelapseDir, _ := setupTestRouter(t)
+
+	if err := os.WriteFile(filepath.Join(timelapseDir, "video_2024-07-24_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(timelapseDir, "2024", "07")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(nested, "video_2024-07-25_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/timelapses/video_2024-07-24_09-14-01.mp4", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var detail models.TimelapseDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if detail.Filename != "video_2024-07-24_09-14-01.mp4" {
+		t.Errorf("unexpected filename %q", detail.Filename)
+	}
+
+	// Nested timelapses are addressed with escaped slashes
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/timelapses/2024%2F07%2Fvideo_2024-07-25_09-14-01.mp4", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for nested timelapse, got %d", w.Code)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if detail.Folder != "2024/07" || detail.Previous == nil {
+		t.Errorf("unexpected nested detail: %+v", detail)
+	}
+
+	// Encoded traversal attempts never reach the filesystem
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/timelapses/..%2F..%2Fetc%2Fpasswd", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest && w.Code != http.StatusNotFound {
+		t.Errorf("expected 400 or 404 for traversal attempt, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, timelapseDir, _ := setupTestRouter(t)
+
+	name := "video_2024-07-24_09-14-01.mp4"
+	if err := os.WriteFile(filepath.Join(timelapseDir, name), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/api/timelapses/"+name, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 with Authorization %q, got %d", auth, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/timelapses/"+name, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 with valid token, got %d", w.Code)
+	}
+
+	// Trash listing is public
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/trash", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 listing trash, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutesDisabledWithoutToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	router, _, _ := setupTestRouter(t)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/timelapses/video.mp4", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 when ADMIN_TOKEN is unset, got %d", w.Code)
+	}
+}
+
+func TestRetentionRoutes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, _, _ := setupTestRouter(t)
+
+	for _, path := range []string{"/api/retention", "/api/retention/dry-run", "/api/retention/log"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected 200 for %s, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/retention/run", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 running retention without token, got %d", w.Code)
+	}
+}
+
+func TestStatsRoutes(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+
+	for _, path := range []string{"/api/stats", "/api/printers/default/stats"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected 200 for %s, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestFeedRoutes(t *testing.T) {
+	router, _, _ := setupTestRouter(t)
+
+	for _, path := range []string{"/feeds/timelapses.atom", "/feeds/timelapses.rss", "/feeds/printers/default/timelapses.atom", "/feeds/printers/default/timelapses.rss"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "xml") {
+			t.Errorf("expected an XML feed for %s, got %d %s", path, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/feeds/printers/missing/timelapses.atom", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown printer, got %d", w.Code)
+	}
+}
+
+func TestTimelapseHealthRoutes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, _, _ := setupTestRouter(t)
+
+	for _, path := range []string{"/api/timelapses/health", "/api/printers/default/timelapses/health"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected 200 for %s, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/timelapses/health/quarantine", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 quarantining without token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/timelapses/health/quarantine", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 quarantining with token, got %d", w.Code)
+	}
+}
+
+func TestTranscodeRoutes(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router, timelapseDir, _ := setupTestRouter(t)
+	name := "video_2024-07-01_10-00-00.mkv"
+	if err := os.WriteFile(filepath.Join(timelapseDir, name), []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"/api", "/api/printers/default"} {
+		// Status is public, starting ffmpeg jobs is not
+		w := serve(router, http.MethodGet, prefix+"/timelapses/"+name+"/transcode", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected 200 for %s status, got %d", prefix, w.Code)
+		}
+		w = serve(router, http.MethodPost, prefix+"/timelapses/"+name+"/transcode", nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 transcoding via %s without token, got %d", prefix, w.Code)
+		}
+		w = serve(router, http.MethodPost, prefix+"/timelapses/"+name+"/transcode", http.Header{"Authorization": {"Bearer secret"}})
+		if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("expected transcoding via %s with token to be allowed, got %d", prefix, w.Code)
+		}
+	}
+}
+
+func TestPrinterScopedRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var configs []handlers.PrinterConfig
+	for _, id := range []string{"x1c", "p1s"} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "video_"+id+"_2024-07-24_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m3u8Path := filepath.Join(t.TempDir(), "stream.m3u8")
+		if err := os.WriteFile(m3u8Path, []byte("#EXTM3U\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, handlers.PrinterConfig{ID: id, TimelapseDir: dir, StreamPath: m3u8Path})
+	}
+	printers, err := handlers.NewPrinterRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(printers)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/printers/p1s/timelapses", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Filename != "video_p1s_2024-07-24_09-14-01.mp4" {
+		t.Fatalf("unexpected items: %+v", page.Items)
+	}
+	if page.Items[0].URL != "/videos/p1s/video_p1s_2024-07-24_09-14-01.mp4" {
+		t.Errorf("unexpected url %q", page.Items[0].URL)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/printers/x1c/stream/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for stream status, got %d", w.Code)
+	}
+
+	// The unscoped routes serve the first printer
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/timelapses/video_x1c_2024-07-24_09-14-01.mp4", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for default printer, got %d", w.Code)
+	}
+
+	for _, path := range []string{"/api/printers/nope/timelapses", "/api/printers/nope/stream/status"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s, got %d", path, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/printers/x1c/timelapses/video_x1c_2024-07-24_09-14-01.mp4", nil))
+	if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+		t.Errorf("expected scoped delete to require admin, got %d", w.Code)
+	}
+}
+
+func TestMediaRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	timelapseDir := t.TempDir()
+	streamDir := t.TempDir()
+	m3u8Path := filepath.Join(streamDir, "stream.m3u8")
+	if err := os.WriteFile(m3u8Path, []byte("#EXTM3U\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(timelapseDir, "video.mp4"), []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without SERVE_MEDIA the reverse proxy is expected to serve these
+	router := SetupRouter(singlePrinter(t, timelapseDir, m3u8Path))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/videos/video.mp4", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without SERVE_MEDIA, got %d", w.Code)
+	}
+
+	t.Setenv("SERVE_MEDIA", "true")
+	router = SetupRouter(singlePrinter(t, timelapseDir, m3u8Path))
+
+	tests := []struct {
+		target      string
+		contentType string
+	}{
+		{"/videos/video.mp4", "video/mp4"},
+		{"/live/default/stream.m3u8", "application/vnd.apple.mpegurl"},
+	}
+	for _, tt := range tests {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.target, got, tt.contentType)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodHead, "/videos/video.mp4", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected 206 for HEAD with Range, got %d", w.Code)
+	}
+
+	// The API routes are unaffected
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/timelapses", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for /api/timelapses, got %d", w.Code)
+	}
+}
+
+func TestRemoteRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	timelapseDir := t.TempDir()
+	video := mediatest.MP4(mediatest.Video{})
+	if err := os.WriteFile(filepath.Join(timelapseDir, "video_2024-07-01_10-00-00.mp4"), video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remoteDir := t.TempDir()
+	store, err := storage.NewLocal(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printers, err := handlers.NewPrinterRegistry([]handlers.PrinterConfig{{
+		ID:           "default",
+		TimelapseDir: timelapseDir,
+		StreamPath:   filepath.Join(t.TempDir(), "stream.m3u8"),
+		VideosURL:    handlers.DefaultVideosURL,
+	}}, handlers.WithRemoteStorage(store, 0, models.RemoteURLsProxy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(printers)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/printers/default/remote/offload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without an offload age, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/printers/default/remote/offload?older_than_days=0", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	// Each printer keeps its videos under its ID
+	if _, err := os.Stat(filepath.Join(remoteDir, "default", "video_2024-07-01_10-00-00.mp4")); err != nil {
+		t.Fatalf("expected the video under the printer's prefix: %v", err)
+	}
+
+	for _, path := range []string{"/api/timelapses/video_2024-07-01_10-00-00.mp4/content", "/api/printers/default/timelapses/video_2024-07-01_10-00-00.mp4/content"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != string(video) {
+			t.Errorf("expected the video from %s, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/api/remote", "/api/printers/default/remote"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var status models.RemoteStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.Enabled || status.Count != 1 {
+			t.Errorf("unexpected status from %s: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSyncRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	printerRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(printerRoot, "timelapse"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(printerRoot, "timelapse", "video_2024-07-01_10-00-00.mp4"), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := ftpstest.NewServer(t, printerRoot)
+	host, port, _ := net.SplitHostPort(server.Addr)
+	n, _ := strconv.Atoi(port)
+	printers, err := handlers.NewPrinterRegistry([]handlers.PrinterConfig{{
+		ID:           "default",
+		TimelapseDir: t.TempDir(),
+		StreamPath:   filepath.Join(t.TempDir(), "stream.m3u8"),
+		VideosURL:    handlers.DefaultVideosURL,
+		Sync:         &handlers.SyncConfig{Host: host, Port: n, User: ftpstest.User, Password: ftpstest.Password},
+	}, {
+		ID:           "other",
+		TimelapseDir: t.TempDir(),
+		StreamPath:   filepath.Join(t.TempDir(), "stream.m3u8"),
+		VideosURL:    handlers.DefaultVideosURL,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRouter(printers)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/sync/run", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/printers/other/sync/run", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a printer without sync, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/printers/default/sync/run?wait=true", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	var status models.SyncStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if status.Running || status.LastRun == nil || len(status.LastRun.Files) != 1 {
+		t.Errorf("expected the finished run, got %s", w.Body.String())
+	}
+
+	for _, path := range []string{"/api/sync/status", "/api/printers/default/sync/status"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var status models.SyncStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.Enabled || status.LastRun == nil {
+			t.Errorf("unexpected status from %s: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	for _, path := range []string{"/api/sync/history", "/api/printers/default/sync/history?limit=1"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var runs []models.SyncRun
+		if err := json.Unmarshal(w.Body.Bytes(), &runs); err != nil || len(runs) != 1 {
+			t.Errorf("unexpected history from %s: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/sync/history?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid limit, got %d", w.Code)
+	}
+
+	// The printer's certificate was pinned by the run
+	for _, path := range []string{"/api/sync/pins", "/api/printers/default/sync/pins"} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		router.ServeHTTP(w, req)
+		var pins []tlspin.Pin
+		if err := json.Unmarshal(w.Body.Bytes(), &pins); err != nil || len(pins) != 1 || pins[0].Addr != server.Addr {
+			t.Errorf("unexpected pins from %s: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/sync/pins/approve", strings.NewReader(`{"addr":"`+server.Addr+`","fingerprint":"abc"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 without a pending change, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/printers/default/sync/pins?addr="+server.Addr, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/printers/default/sync/pins?addr="+server.Addr, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 once reset, got %d", w.Code)
+	}
+}
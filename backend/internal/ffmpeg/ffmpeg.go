@@ -0,0 +1,150 @@
+// Package ffmpeg runs an external ffmpeg binary to produce derived media
+// such as browser-playable renditions.
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotConfigured is returned when no ffmpeg binary has been set.
+var ErrNotConfigured = errors.New("ffmpeg: no binary configured")
+
+// stderrTail is how much of ffmpeg's stderr is kept for error messages.
+const stderrTail = 2048
+
+// Runner runs the ffmpeg binary at Path. The zero value has no binary and
+// every call fails with ErrNotConfigured.
+type Runner struct {
+	Path string
+}
+
+// Enabled reports whether a binary is configured.
+func (r Runner) Enabled() bool {
+	return r.Path != ""
+}
+
+// Run executes ffmpeg with args. On failure the error includes the end of
+// ffmpeg's stderr, which is where it explains what went wrong.
+func (r Runner) Run(ctx context.Context, args ...string) error {
+	if !r.Enabled() {
+		return ErrNotConfigured
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.Path, append([]string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > stderrTail {
+			msg = "..." + msg[len(msg)-stderrTail:]
+		}
+		if msg == "" {
+			return fmt.Errorf("ffmpeg: %w", err)
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+	}
+	return nil
+}
+
+// TranscodeMP4 converts src to an H.264/AAC MP4 with the index at the
+// front, so browsers can start playing before the download finishes. The
+// output is written next to dst and renamed into place, so dst never holds
+// a partial file.
+func (r Runner) TranscodeMP4(ctx context.Context, src, dst string) error {
+	return writeAtomic(dst, func(tmp string) error {
+		return r.Run(ctx,
+			"-i", src,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k",
+			"-movflags", "+faststart",
+			"-f", "mp4", tmp,
+		)
+	})
+}
+
+// Clip writes the part of src between start and end to dst as an MP4. By
+// default streams are copied, which is fast but starts the clip on the
+// keyframe at or before start. With reencode the cut is frame-accurate at
+// the cost of encoding the clip again.
+func (r Runner) Clip(ctx context.Context, src, dst string, start, end time.Duration, reencode bool) error {
+	return writeAtomic(dst, func(tmp string) error {
+		var args []string
+		if reencode {
+			// Seeking after -i decodes from the start so cuts land on exact frames
+			args = []string{
+				"-i", src, "-ss", seconds(start), "-to", seconds(end),
+				"-map", "0:v:0", "-map", "0:a:0?",
+				"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+				"-c:a", "aac", "-b:a", "128k",
+			}
+		} else {
+			args = []string{
+				"-ss", seconds(start), "-i", src, "-t", seconds(end - start),
+				"-map", "0:v:0", "-map", "0:a:0?",
+				"-c", "copy", "-avoid_negative_ts", "make_zero",
+			}
+		}
+		return r.Run(ctx, append(args, "-movflags", "+faststart", "-f", "mp4", tmp)...)
+	})
+}
+
+// SpriteLayout describes a sprite sheet: Frames evenly spaced frames over
+// Duration, each scaled to TileWidth x TileHeight and laid out Columns to a
+// row.
+type SpriteLayout struct {
+	Duration   time.Duration
+	Frames     int
+	Columns    int
+	TileWidth  int
+	TileHeight int
+}
+
+// Rows returns how many rows the sprite sheet has.
+func (l SpriteLayout) Rows() int {
+	return (l.Frames + l.Columns - 1) / l.Columns
+}
+
+// Sprite writes a JPEG sprite sheet of src to dst.
+func (r Runner) Sprite(ctx context.Context, src, dst string, layout SpriteLayout) error {
+	filter := fmt.Sprintf("fps=%d/%s,scale=%d:%d,tile=%dx%d",
+		layout.Frames, seconds(layout.Duration), layout.TileWidth, layout.TileHeight, layout.Columns, layout.Rows())
+	return writeAtomic(dst, func(tmp string) error {
+		return r.Run(ctx, "-i", src, "-an", "-vf", filter, "-frames:v", "1", "-q:v", "5", "-f", "image2", tmp)
+	})
+}
+
+// seconds formats d the way ffmpeg expects time offsets.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// writeAtomic runs write with a temporary path in dst's directory and
+// renames the result to dst once it succeeds.
+func writeAtomic(dst string, write func(tmp string) error) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp := dst + ".part"
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
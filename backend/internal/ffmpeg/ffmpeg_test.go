@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg/ffmpegtest"
+)
+
+func TestTranscodeMP4(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mkv")
+	dst := filepath.Join(dir, "out", "in.mp4")
+	argLog := filepath.Join(dir, "args")
+	if err := os.WriteFile(src, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{Path: ffmpegtest.Copy(t, argLog)}
+	if err := r.TranscodeMP4(context.Background(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil || string(data) != "video" {
+		t.Fatalf("expected output at %s, got %q, %v", dst, data, err)
+	}
+	if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be renamed away")
+	}
+
+	logged, _ := os.ReadFile(argLog)
+	args := strings.Split(strings.TrimSpace(string(logged)), "\n")
+	for _, want := range []string{"libx264", "aac", "+faststart", "yuv420p"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("expected %q in ffmpeg args %v", want, args)
+		}
+	}
+}
+
+func TestTranscodeMP4_Failure(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "out.mp4")
+
+	r := Runner{Path: ffmpegtest.Fail(t, "Invalid data found when processing input")}
+	err := r.TranscodeMP4(context.Background(), filepath.Join(dir, "in.avi"), dst)
+	if err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+		t.Fatalf("expected stderr in the error, got %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("expected no output after a failure")
+	}
+}
+
+func TestRun_NotConfigured(t *testing.T) {
+	var r Runner
+	if err := r.Run(context.Background(), "-version"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := Runner{Path: ffmpegtest.Script(t, "sleep 5")}
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestClip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(src, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		reencode bool
+		want     []string
+	}{
+		// Input seeking with copied streams cuts on keyframes
+		{"copy", false, []string{"-ss", "1.5", "-i", src, "-t", "8.5"}},
+		// Output seeking after -i is frame-accurate
+		{"reencode", true, []string{"-i", src, "-ss", "1.5", "-to", "10"}},
+	}
+	for _, tt := range tests {
+		argLog := filepath.Join(dir, tt.name+".args")
+		r := Runner{Path: ffmpegtest.Copy(t, argLog)}
+		dst := filepath.Join(dir, tt.name+".mp4")
+		if err := r.Clip(context.Background(), src, dst, 1500*time.Millisecond, 10*time.Second, tt.reencode); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := os.Stat(dst); err != nil {
+			t.Errorf("%s: expected output: %v", tt.name, err)
+		}
+
+		logged, _ := os.ReadFile(argLog)
+		args := strings.Join(strings.Split(strings.TrimSpace(string(logged)), "\n"), " ")
+		if !strings.Contains(args, strings.Join(tt.want, " ")) {
+			t.Errorf("%s: expected %v in ffmpeg args %q", tt.name, tt.want, args)
+		}
+	}
+}
+
+func TestSprite(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mp4")
+	argLog := filepath.Join(dir, "args")
+	if err := os.WriteFile(src, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := SpriteLayout{Duration: 30 * time.Second, Frames: 30, Columns: 10, TileWidth: 160, TileHeight: 90}
+	if layout.Rows() != 3 {
+		t.Errorf("expected 3 rows, got %d", layout.Rows())
+	}
+
+	r := Runner{Path: ffmpegtest.Copy(t, argLog)}
+	dst := filepath.Join(dir, "sprite.jpg")
+	if err := r.Sprite(context.Background(), src, dst, layout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected output: %v", err)
+	}
+
+	logged, _ := os.ReadFile(argLog)
+	if args := string(logged); !strings.Contains(args, "fps=30/30,scale=160:90,tile=10x3\n") {
+		t.Errorf("unexpected filter in ffmpeg args %q", args)
+	}
+}
@@ -0,0 +1,48 @@
+// Package ffmpegtest writes fake ffmpeg executables for tests.
+package ffmpegtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Script writes a shell script that runs body and returns its path. The
+// arguments ffmpeg was called with are available as "$@".
+func Script(t testing.TB, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Copy returns a fake ffmpeg that copies its -i input to the output path
+// (the last argument) and appends its arguments, one per line, to log if
+// log is not empty.
+func Copy(t testing.TB, log string) string {
+	t.Helper()
+
+	return Script(t, `
+src=""
+prev=""
+for arg in "$@"; do
+	if [ "$prev" = "-i" ]; then src="$arg"; fi
+	prev="$arg"
+	out="$arg"
+done
+if [ -n "`+log+`" ]; then
+	for arg in "$@"; do echo "$arg" >> "`+log+`"; done
+fi
+cat "$src" > "$out"`)
+}
+
+// Fail returns a fake ffmpeg that prints msg to stderr and exits 1.
+func Fail(t testing.TB, msg string) string {
+	t.Helper()
+
+	return Script(t, `echo "`+msg+`" >&2
+exit 1`)
+}
@@ -0,0 +1,436 @@
+// Package ftps is a small FTP client for implicit TLS, the FTPS flavour
+// Bambu printers serve their SD card over on port 990. It covers what
+// mirroring needs: listing directories and resumable binary downloads.
+package ftps
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPort is the implicit FTPS port.
+	DefaultPort = 990
+
+	// DefaultTimeout bounds each command, and each read of a transfer.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Config describes how to reach and log in to a server.
+type Config struct {
+	// Addr is host:port.
+	Addr     string
+	User     string
+	Password string
+	// TLS is used for the control and data connections. ServerName
+	// defaults to the host in Addr.
+	TLS     *tls.Config
+	Timeout time.Duration
+}
+
+// Entry is one file or directory in a listing.
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
+
+// Conn is a logged-in control connection. It runs one command at a time
+// and is not safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	host    string
+	tls     *tls.Config
+	timeout time.Duration
+	mlsd    bool
+	noEPSV  bool
+}
+
+// Dial connects to the server and logs in.
+func Dial(ctx context.Context, cfg Config) (*Conn, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.TLS != nil {
+		tlsConfig = cfg.TLS.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	// Servers commonly insist that data connections resume the control
+	// connection's TLS session
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+
+	d := tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: tlsConfig}
+	conn, err := d.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, text: textproto.NewConn(conn), tls: tlsConfig, timeout: timeout}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.host = addr.IP.String()
+	} else {
+		c.host = host
+	}
+
+	stop := c.watch(ctx)
+	err = c.login(cfg.User, cfg.Password)
+	if !stop() || err != nil {
+		conn.Close()
+		return nil, errors.Join(err, ctx.Err())
+	}
+	return c, nil
+}
+
+func (c *Conn) login(user, password string) error {
+	if _, _, err := c.response(2); err != nil {
+		return fmt.Errorf("ftps: greeting: %w", err)
+	}
+	code, _, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	switch code / 100 {
+	case 2:
+	case 3:
+		if _, _, err := c.cmd(2, "PASS %s", password); err != nil {
+			return fmt.Errorf("ftps: login: %w", err)
+		}
+	default:
+		return fmt.Errorf("ftps: login: %d", code)
+	}
+
+	for _, cmd := range []string{"PBSZ 0", "PROT P", "TYPE I"} {
+		if _, _, err := c.cmd(2, "%s", cmd); err != nil {
+			return fmt.Errorf("ftps: %s: %w", cmd, err)
+		}
+	}
+	// Older servers don't know FEAT; they just list with LIST
+	if _, features, err := c.cmd(2, "FEAT"); err == nil {
+		for _, line := range strings.Split(features, "\n") {
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "MLST") {
+				c.mlsd = true
+			}
+		}
+	}
+	return nil
+}
+
+// aLongTimeAgo is a deadline in the past, to unblock pending I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watch interrupts the control connection and conns when ctx is done. The
+// returned stop function reports false if it already has.
+func (c *Conn) watch(ctx context.Context, conns ...net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(aLongTimeAgo)
+		for _, conn := range conns {
+			conn.SetDeadline(aLongTimeAgo)
+		}
+	})
+}
+
+// cmd sends a command and reads the reply, which must be in the class
+// or code expect (see textproto.Reader.ReadResponse).
+func (c *Conn) cmd(expect int, format string, args ...any) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+func (c *Conn) response(expect int) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.text.ReadResponse(expect)
+}
+
+var (
+	epsvPattern = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+	pasvPattern = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+)
+
+// dialData opens a passive data connection. The address in the reply is
+// ignored in favour of the control connection's, as it is often wrong
+// behind NAT.
+func (c *Conn) dialData(ctx context.Context) (net.Conn, error) {
+	port := 0
+	if !c.noEPSV {
+		_, msg, err := c.cmd(229, "EPSV")
+		var protoErr *textproto.Error
+		switch {
+		case err == nil:
+			if m := epsvPattern.FindStringSubmatch(msg); m != nil {
+				port, _ = strconv.Atoi(m[1])
+			}
+		case errors.As(err, &protoErr):
+			c.noEPSV = true
+		default:
+			return nil, err
+		}
+	}
+	if port == 0 {
+		_, msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, fmt.Errorf("ftps: PASV: %w", err)
+		}
+		m := pasvPattern.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, fmt.Errorf("ftps: invalid PASV reply %q", msg)
+		}
+		hi, _ := strconv.Atoi(m[5])
+		lo, _ := strconv.Atoi(m[6])
+		port = hi<<8 | lo
+	}
+
+	d := net.Dialer{Timeout: c.timeout}
+	return d.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+}
+
+// transfer opens a data connection and starts cmd on it. The TLS
+// handshake waits for the preliminary reply, since servers only start TLS
+// on the data connection once they are processing the command.
+func (c *Conn) transfer(ctx context.Context, offset int64, format string, args ...any) (*tls.Conn, error) {
+	raw, err := c.dialData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, _, err := c.cmd(350, "REST %d", offset); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("ftps: REST: %w", err)
+		}
+	}
+	if _, _, err := c.cmd(1, format, args...); err != nil {
+		raw.Close()
+		return nil, notExist(err)
+	}
+
+	data := tls.Client(raw, c.tls)
+	hctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if err := data.HandshakeContext(hctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("ftps: data connection: %w", err)
+	}
+	return data, nil
+}
+
+// notExist marks a 550 reply as fs.ErrNotExist.
+func notExist(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == 550 {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, protoErr.Msg)
+	}
+	return err
+}
+
+// List returns the files and directories in dir, without "." and "..".
+// Directories that don't exist are reported with fs.ErrNotExist.
+func (c *Conn) List(ctx context.Context, dir string) ([]Entry, error) {
+	cmd := "LIST"
+	if c.mlsd {
+		cmd = "MLSD"
+	}
+
+	data, err := c.transfer(ctx, 0, "%s %s", cmd, dir)
+	if err != nil {
+		return nil, err
+	}
+	stop := c.watch(ctx, data)
+	defer stop()
+
+	var lines []string
+	data.SetReadDeadline(time.Now().Add(c.timeout))
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	data.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Join(err, ctx.Err())
+	}
+	if _, _, err := c.response(2); err != nil {
+		return nil, fmt.Errorf("ftps: %s: %w", cmd, err)
+	}
+
+	now := time.Now()
+	entries := make([]Entry, 0, len(lines))
+	for _, line := range lines {
+		parse := parseListLine
+		if c.mlsd {
+			parse = parseMLSDLine
+		}
+		if e, ok := parse(line, now); ok && e.Name != "." && e.Name != ".." {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Retrieve downloads file from offset. The download is finished, and the
+// connection free for the next command, once the reader is closed.
+// Missing files are reported with fs.ErrNotExist.
+func (c *Conn) Retrieve(ctx context.Context, file string, offset int64) (io.ReadCloser, error) {
+	data, err := c.transfer(ctx, offset, "RETR %s", file)
+	if err != nil {
+		return nil, err
+	}
+	return &download{c: c, data: data, ctx: ctx, stop: c.watch(ctx, data)}, nil
+}
+
+type download struct {
+	c    *Conn
+	data *tls.Conn
+	ctx  context.Context
+	stop func() bool
+	eof  bool
+}
+
+func (d *download) Read(p []byte) (int, error) {
+	d.data.SetReadDeadline(time.Now().Add(d.c.timeout))
+	n, err := d.data.Read(p)
+	if err == io.EOF {
+		d.eof = true
+	} else if err != nil && d.ctx.Err() != nil {
+		err = d.ctx.Err()
+	}
+	return n, err
+}
+
+func (d *download) Close() error {
+	d.data.Close()
+	if !d.stop() {
+		return d.ctx.Err()
+	}
+	_, _, err := d.c.response(2)
+	if err != nil && !d.eof {
+		// Closing early aborts the transfer, which the server reports
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && protoErr.Code/100 == 4 {
+			return nil
+		}
+	}
+	return err
+}
+
+// Quit logs out and closes the connection.
+func (c *Conn) Quit() error {
+	c.cmd(0, "QUIT")
+	return c.conn.Close()
+}
+
+// Close closes the connection without logging out.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// parseListLine parses a Unix ls -l style LIST line. Times without a year
+// are in the last year, and all times are taken as UTC.
+func parseListLine(line string, now time.Time) (Entry, bool) {
+	fields, name := splitFields(line, 8)
+	if fields == nil || name == "" {
+		return Entry{}, false
+	}
+
+	var e Entry
+	switch fields[0][0] {
+	case 'd':
+		e.Dir = true
+	case '-':
+	default:
+		// Links and devices aren't mirrored
+		return Entry{}, false
+	}
+	size, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return Entry{}, false
+	}
+	e.Name, e.Size = name, size
+
+	stamp := fields[5] + " " + fields[6] + " " + fields[7]
+	if strings.Contains(fields[7], ":") {
+		t, err := time.Parse("Jan 2 15:04", stamp)
+		if err != nil {
+			return Entry{}, false
+		}
+		e.ModTime = t.AddDate(now.Year(), 0, 0)
+		if e.ModTime.After(now.Add(24 * time.Hour)) {
+			e.ModTime = e.ModTime.AddDate(-1, 0, 0)
+		}
+	} else {
+		t, err := time.Parse("Jan 2 2006", stamp)
+		if err != nil {
+			return Entry{}, false
+		}
+		e.ModTime = t
+	}
+	return e, true
+}
+
+// splitFields returns the first n space-separated fields of line and the
+// rest, which keeps its inner spacing. fields is nil if there are fewer.
+func splitFields(line string, n int) (fields []string, rest string) {
+	rest = line
+	for range n {
+		rest = strings.TrimLeft(rest, " ")
+		i := strings.IndexByte(rest, ' ')
+		if i <= 0 {
+			return nil, ""
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i:]
+	}
+	return fields, strings.TrimLeft(rest, " ")
+}
+
+// parseMLSDLine parses an RFC 3659 listing line: facts, a space and the
+// name.
+func parseMLSDLine(line string, _ time.Time) (Entry, bool) {
+	facts, name, ok := strings.Cut(line, " ")
+	if !ok || name == "" {
+		return Entry{}, false
+	}
+
+	e := Entry{Name: name}
+	for _, fact := range strings.Split(facts, ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			switch strings.ToLower(value) {
+			case "file":
+			case "dir":
+				e.Dir = true
+			default:
+				return Entry{}, false
+			}
+		case "size":
+			e.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			e.ModTime, _ = time.Parse("20060102150405", value)
+		}
+	}
+	return e, true
+}
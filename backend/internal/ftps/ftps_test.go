@@ -0,0 +1,173 @@
+package ftps
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ftps/ftpstest"
+)
+
+func setupServer(t *testing.T) (*ftpstest.Server, []byte) {
+	t.Helper()
+	root := t.TempDir()
+	video := make([]byte, 100_000)
+	for i := range video {
+		video[i] = byte(i % 251)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "timelapse", "thumbnail"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "timelapse", "video 2024-07-01_10-00-00.mp4"), video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "timelapse", "video 2024-07-01_10-00-00.mp4"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return ftpstest.NewServer(t, root), video
+}
+
+func dial(t *testing.T, s *ftpstest.Server) *Conn {
+	t.Helper()
+	c, err := Dial(t.Context(), Config{
+		Addr:     s.Addr,
+		User:     ftpstest.User,
+		Password: ftpstest.Password,
+		TLS:      &tls.Config{InsecureSkipVerify: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Quit() })
+	return c
+}
+
+func TestList(t *testing.T) {
+	for _, mlsd := range []bool{true, false} {
+		s, video := setupServer(t)
+		s.DisableMLSD = !mlsd
+		c := dial(t, s)
+
+		entries, err := c.List(t.Context(), "/timelapse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.SortFunc(entries, func(a, b Entry) int { return len(a.Name) - len(b.Name) })
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries with mlsd=%v, got %+v", mlsd, entries)
+		}
+		if e := entries[0]; e.Name != "thumbnail" || !e.Dir {
+			t.Errorf("unexpected directory %+v", e)
+		}
+		e := entries[1]
+		if e.Name != "video 2024-07-01_10-00-00.mp4" || e.Dir || e.Size != int64(len(video)) {
+			t.Errorf("unexpected file %+v", e)
+		}
+		// LIST only has the date for older files
+		want := time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)
+		if !mlsd {
+			want = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if !e.ModTime.Equal(want) {
+			t.Errorf("expected modification time %v with mlsd=%v, got %v", want, mlsd, e.ModTime)
+		}
+	}
+}
+
+func TestList_Missing(t *testing.T) {
+	s, _ := setupServer(t)
+	c := dial(t, s)
+
+	if _, err := c.List(t.Context(), "/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+	// The connection is still usable
+	if _, err := c.List(t.Context(), "/timelapse"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	s, video := setupServer(t)
+	s.DisableEPSV = true
+	c := dial(t, s)
+
+	for _, offset := range []int64{0, 40_000} {
+		r, err := c.Retrieve(t.Context(), "/timelapse/video 2024-07-01_10-00-00.mp4", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(data, video[offset:]) {
+			t.Errorf("unexpected %d bytes from offset %d", len(data), offset)
+		}
+	}
+	if !slices.Contains(s.Commands(), "REST 40000") || !slices.Contains(s.Commands(), "PASV") {
+		t.Errorf("unexpected commands %v", s.Commands())
+	}
+
+	if _, err := c.Retrieve(t.Context(), "/timelapse/missing.mp4", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestRetrieve_Cut(t *testing.T) {
+	s, _ := setupServer(t)
+	c := dial(t, s)
+	s.CutAfter.Store(1000)
+
+	r, err := c.Retrieve(t.Context(), "/timelapse/video 2024-07-01_10-00-00.mp4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if err := r.Close(); err == nil {
+		t.Error("expected an error for an aborted transfer")
+	}
+	if len(data) != 1000 {
+		t.Errorf("expected 1000 bytes before the cut, got %d", len(data))
+	}
+}
+
+func TestDial_BadLogin(t *testing.T) {
+	s, _ := setupServer(t)
+	_, err := Dial(t.Context(), Config{Addr: s.Addr, User: ftpstest.User, Password: "wrong", TLS: &tls.Config{InsecureSkipVerify: true}})
+	if err == nil {
+		t.Fatal("expected a login error")
+	}
+}
+
+func TestParseListLine(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line string
+		want Entry
+		ok   bool
+	}{
+		{"-rw-r--r-- 1 root root 1234 Feb 28 09:15 video.mp4", Entry{Name: "video.mp4", Size: 1234, ModTime: time.Date(2024, 2, 28, 9, 15, 0, 0, time.UTC)}, true},
+		{"-rw-r--r-- 1 root root 1234 Dec 31 23:00 old.mp4", Entry{Name: "old.mp4", Size: 1234, ModTime: time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC)}, true},
+		{"drwxr-xr-x 2 root root 4096 Jan  5  2023 thumbnail", Entry{Name: "thumbnail", Size: 4096, ModTime: time.Date(2023, 1, 5, 0, 0, 0, 0, time.UTC), Dir: true}, true},
+		{"-rw-r--r-- 1 root root 10 Jan 5 2023 two  spaces.mp4", Entry{Name: "two  spaces.mp4", Size: 10, ModTime: time.Date(2023, 1, 5, 0, 0, 0, 0, time.UTC)}, true},
+		{"lrwxrwxrwx 1 root root 4 Jan 5 2023 link -> video.mp4", Entry{}, false},
+		{"total 8", Entry{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseListLine(tt.line, now)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseListLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
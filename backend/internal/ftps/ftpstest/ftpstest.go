@@ -0,0 +1,412 @@
+// Package ftpstest runs an in-process implicit FTPS server for tests,
+// standing in for a printer's SD card.
+package ftpstest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	User     = "bblp"
+	Password = "12345678"
+)
+
+// Server serves the files under Root, read-only, to User and Password.
+type Server struct {
+	Root string
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	// DisableMLSD leaves MLST out of FEAT, so clients fall back to LIST.
+	DisableMLSD bool
+	// DisableEPSV rejects EPSV, so clients fall back to PASV.
+	DisableEPSV bool
+	// CutAfter, when positive, drops the next download after sending that
+	// many bytes.
+	CutAfter atomic.Int64
+
+	cert     tls.Certificate
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	commands []string
+	conns    map[net.Conn]bool
+}
+
+// NewServer starts a server for root with a fresh self-signed
+// certificate. It stops when the test ends.
+func NewServer(t testing.TB, root string) *Server {
+	t.Helper()
+	s := &Server{Root: root, cert: Certificate(t), conns: make(map[net.Conn]bool)}
+	s.start(t)
+	return s
+}
+
+// NewServerWithCertificate starts a server presenting cert on the same
+// address as a previous server, to stand in for a printer whose
+// certificate changed.
+func NewServerWithCertificate(t testing.TB, root, addr string, cert tls.Certificate) *Server {
+	t.Helper()
+	s := &Server{Root: root, Addr: addr, cert: cert, conns: make(map[net.Conn]bool)}
+	s.start(t)
+	return s
+}
+
+func (s *Server) start(t testing.TB) {
+	addr := s.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = tls.NewListener(l, s.tlsConfig())
+	s.Addr = l.Addr().String()
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+}
+
+// Certificate generates a self-signed certificate like the ones printers
+// present.
+func Certificate(t testing.TB) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "printer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// Leaf returns the certificate the server presents.
+func (s *Server) Leaf() *x509.Certificate {
+	return s.cert.Leaf
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{s.cert}}
+}
+
+// Commands returns the commands received so far, without arguments for
+// PASS.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the server and drops open connections.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.session(conn)
+		}()
+	}
+}
+
+// session runs one control connection.
+type session struct {
+	s        *Server
+	w        *bufio.Writer
+	loggedIn bool
+	user     string
+	offset   int64
+	passive  net.Listener
+}
+
+func (s *Server) session(conn net.Conn) {
+	ss := &session{s: s, w: bufio.NewWriter(conn)}
+	defer func() {
+		if ss.passive != nil {
+			ss.passive.Close()
+		}
+	}()
+	ss.reply(220, "ready")
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+
+		s.mu.Lock()
+		if cmd == "PASS" {
+			s.commands = append(s.commands, cmd)
+		} else {
+			s.commands = append(s.commands, strings.TrimSpace(cmd+" "+arg))
+		}
+		s.mu.Unlock()
+
+		if !ss.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+func (ss *session) reply(code int, msg string) {
+	fmt.Fprintf(ss.w, "%d %s\r\n", code, msg)
+	ss.w.Flush()
+}
+
+// handle runs one command, reporting false once the session is over.
+func (ss *session) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		ss.user = arg
+		ss.reply(331, "password required")
+		return true
+	case "PASS":
+		if ss.user != User || arg != Password {
+			ss.reply(530, "login incorrect")
+			return true
+		}
+		ss.loggedIn = true
+		ss.reply(230, "logged in")
+		return true
+	case "QUIT":
+		ss.reply(221, "bye")
+		return false
+	}
+	if !ss.loggedIn {
+		ss.reply(530, "not logged in")
+		return true
+	}
+
+	switch cmd {
+	case "PBSZ", "PROT", "TYPE":
+		ss.reply(200, "ok")
+	case "FEAT":
+		features := "211-Features:\r\n SIZE\r\n REST STREAM\r\n"
+		if !ss.s.DisableMLSD {
+			features += " MLST type*;size*;modify*;\r\n"
+		}
+		fmt.Fprint(ss.w, features+"211 End\r\n")
+		ss.w.Flush()
+	case "EPSV":
+		if ss.s.DisableEPSV {
+			ss.reply(502, "not implemented")
+			return true
+		}
+		if !ss.listen() {
+			return true
+		}
+		ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", ss.passive.Addr().(*net.TCPAddr).Port))
+	case "PASV":
+		if !ss.listen() {
+			return true
+		}
+		port := ss.passive.Addr().(*net.TCPAddr).Port
+		// A private address the client should ignore, as printers behind
+		// NAT report
+		ss.reply(227, fmt.Sprintf("Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff))
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			ss.reply(501, "invalid offset")
+			return true
+		}
+		ss.offset = n
+		ss.reply(350, "restarting")
+	case "LIST", "MLSD":
+		ss.list(cmd, arg)
+	case "RETR":
+		ss.retrieve(arg)
+	default:
+		ss.reply(502, "not implemented")
+	}
+	return true
+}
+
+func (ss *session) listen() bool {
+	if ss.passive != nil {
+		ss.passive.Close()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		ss.reply(425, "cannot open data connection")
+		return false
+	}
+	ss.passive = l
+	return true
+}
+
+// accept waits for the data connection and starts TLS on it.
+func (ss *session) accept() (net.Conn, bool) {
+	if ss.passive == nil {
+		ss.reply(425, "use PASV first")
+		return nil, false
+	}
+	l := ss.passive
+	ss.passive = nil
+	defer l.Close()
+
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := l.Accept()
+	if err != nil {
+		ss.reply(425, "no data connection")
+		return nil, false
+	}
+	ss.reply(150, "opening data connection")
+	data := tls.Server(conn, ss.s.tlsConfig())
+	if err := data.Handshake(); err != nil {
+		conn.Close()
+		ss.reply(425, "TLS failed")
+		return nil, false
+	}
+	return data, true
+}
+
+// file maps an absolute path on the server to a file under Root.
+func (ss *session) file(name string) string {
+	return filepath.Join(ss.s.Root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (ss *session) list(cmd, dir string) {
+	entries, err := os.ReadDir(ss.file(dir))
+	if err != nil {
+		if ss.passive != nil {
+			ss.passive.Close()
+			ss.passive = nil
+		}
+		ss.reply(550, "no such directory")
+		return
+	}
+	data, ok := ss.accept()
+	if !ok {
+		return
+	}
+
+	w := bufio.NewWriter(data)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		mtime := info.ModTime().UTC()
+		if cmd == "MLSD" {
+			typ := "file"
+			if e.IsDir() {
+				typ = "dir"
+			}
+			fmt.Fprintf(w, "type=%s;size=%d;modify=%s; %s\r\n", typ, info.Size(), mtime.Format("20060102150405"), e.Name())
+			continue
+		}
+		perms := "-rw-r--r--"
+		if e.IsDir() {
+			perms = "drwxr-xr-x"
+		}
+		fmt.Fprintf(w, "%s 1 root root %d %s %s\r\n", perms, info.Size(), mtime.Format("Jan _2 2006"), e.Name())
+	}
+	w.Flush()
+	data.Close()
+	ss.reply(226, "transfer complete")
+}
+
+func (ss *session) retrieve(name string) {
+	offset := ss.offset
+	ss.offset = 0
+	f, err := os.Open(ss.file(name))
+	if err != nil {
+		if ss.passive != nil {
+			ss.passive.Close()
+			ss.passive = nil
+		}
+		ss.reply(550, "no such file")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		ss.reply(550, "cannot seek")
+		return
+	}
+	data, ok := ss.accept()
+	if !ok {
+		return
+	}
+
+	var r io.Reader = f
+	cut := ss.s.CutAfter.Swap(0)
+	if cut > 0 {
+		r = io.LimitReader(f, cut)
+	}
+	_, err = io.Copy(data, r)
+	if cut > 0 {
+		// Drop the connection without a TLS close, like a lost link
+		data.(*tls.Conn).NetConn().Close()
+		ss.reply(426, "connection closed; transfer aborted")
+		return
+	}
+	data.Close()
+	if err != nil {
+		ss.reply(426, "transfer aborted")
+		return
+	}
+	ss.reply(226, "transfer complete")
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+)
+
+const maxArchiveFilenames = 1000
+
+// archiveRequest is the body of POST /timelapses/archive: either a list of
+// filenames or a date range, in the same formats as the list filters.
+type archiveRequest struct {
+	Filenames []string `json:"filenames"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+}
+
+// selectArchive resolves the request against the catalog items, in the
+// order the filenames were given or oldest first for a date range.
+func (r archiveRequest) selectArchive(items []models.Timelapse) ([]models.Timelapse, error) {
+	byName := make(map[string]models.Timelapse, len(items))
+	for _, t := range items {
+		byName[t.Filename] = t
+	}
+
+	if len(r.Filenames) > 0 {
+		if r.From != "" || r.To != "" {
+			return nil, errors.New("use either filenames or a date range, not both")
+		}
+		if len(r.Filenames) > maxArchiveFilenames {
+			return nil, fmt.Errorf("at most %d filenames are allowed", maxArchiveFilenames)
+		}
+		selected := make([]models.Timelapse, 0, len(r.Filenames))
+		seen := make(map[string]bool)
+		for _, name := range r.Filenames {
+			if !validFilename(name) {
+				return nil, fmt.Errorf("invalid filename %q", name)
+			}
+			t, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", errNotFound, name)
+			}
+			if !seen[name] {
+				seen[name] = true
+				selected = append(selected, t)
+			}
+		}
+		return selected, nil
+	}
+
+	if r.From == "" && r.To == "" {
+		return nil, errors.New("filenames or a date range is required")
+	}
+	var q listQuery
+	if r.From != "" {
+		from, _, err := parseQueryDate(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q", r.From)
+		}
+		q.from = from
+	}
+	if r.To != "" {
+		to, dateOnly, err := parseQueryDate(r.To)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to %q", r.To)
+		}
+		if dateOnly {
+			to = to.Add(24*time.Hour - time.Nanosecond)
+		}
+		q.to = to
+	}
+
+	var selected []models.Timelapse
+	for _, t := range items {
+		if q.matches(t) {
+			selected = append(selected, t)
+		}
+	}
+	sortTimelapses(selected, sortOldest)
+	return selected, nil
+}
+
+// Archive streams a ZIP of the selected timelapses, their thumbnails and a
+// manifest.json. Entries are stored uncompressed, since the videos are
+// already compressed, and written straight to the response without a
+// temporary file. The download stops when the client disconnects.
+func (h *TimelapseHandler) Archive(c *gin.Context) {
+	var req archiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+	if err := h.decorate(snapshot.Items); err != nil {
+		log.Printf("metadata: failed to read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse metadata"})
+		return
+	}
+
+	selected, err := req.selectArchive(snapshot.Items)
+	switch {
+	case errors.Is(err, errNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case len(selected) == 0:
+		c.JSON(http.StatusNotFound, gin.H{"error": "no timelapses in that range"})
+		return
+	}
+
+	clearWriteDeadline(c, "archive")
+
+	now := time.Now()
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="timelapses-%s.zip"`, now.Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	if err := h.writeArchive(c.Request.Context(), c.Writer, selected, now); err != nil {
+		// The status is already sent, so all that is left is to stop
+		log.Printf("archive: stopped after error: %v", err)
+	}
+}
+
+// writeArchive writes the ZIP to w. On error the archive is left without
+// its central directory, so clients see a truncated download rather than
+// a valid but incomplete one.
+func (h *TimelapseHandler) writeArchive(ctx context.Context, w io.Writer, items []models.Timelapse, now time.Time) error {
+	zw := zip.NewWriter(w)
+	manifest := models.ArchiveManifest{CreatedAt: now.UTC(), Items: make([]models.ArchiveItem, 0, len(items))}
+
+	for _, t := range items {
+		add := h.addArchiveFile
+		if t.Remote {
+			add = h.addRemoteArchiveFile
+		}
+		if err := add(ctx, zw, t.Filename); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Deleted since the request started
+				continue
+			}
+			return err
+		}
+
+		item := models.ArchiveItem{Timelapse: t, Thumbnails: []string{}}
+		for _, thumb := range h.catalog.Thumbnails(t.Filename) {
+			if err := h.addArchiveFile(ctx, zw, thumb); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+			item.Thumbnails = append(item.Thumbnails, thumb)
+		}
+		manifest.Items = append(manifest.Items, item)
+	}
+
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Store, Modified: now})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addArchiveFile stores one file from the timelapse directory under its
+// relative path.
+func (h *TimelapseHandler) addArchiveFile(ctx context.Context, zw *zip.Writer, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, storage.ContextReader(ctx, f))
+	return err
+}
+
+// addRemoteArchiveFile stores a video from remote storage under its
+// relative path.
+func (h *TimelapseHandler) addRemoteArchiveFile(ctx context.Context, zw *zip.Writer, name string) error {
+	if !h.remote.Enabled() {
+		return os.ErrNotExist
+	}
+	obj, err := h.remote.store.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	body, err := h.remote.store.Open(ctx, name, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: obj.ModTime}
+	header.SetMode(0o644)
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, storage.ContextReader(ctx, body))
+	return err
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func setupArchiveHandler(t *testing.T) *TimelapseHandler {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	writeFiles(t, tmpDir, map[string][]byte{
+		"video_2024-07-01_10-00-00.mp4":                    []byte("first video"),
+		"thumbnail/video_2024-07-01_10-00-00.jpg":          []byte("first thumb"),
+		"2024/video_2024-07-02_10-00-00.mkv":               []byte("second video"),
+		"2024/thumbnail/video_2024-07-02_10-00-00.jpg":     []byte("second thumb"),
+		"2024/thumbnail/video_2024-07-02_10-00-00_sm.webp": []byte("second small"),
+		"video_2024-07-09_10-00-00.mp4":                    []byte("later video"),
+	})
+
+	h := NewTimelapseHandler(tmpDir)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func callArchive(h *TimelapseHandler, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/timelapses/archive", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.Archive(c)
+	return w
+}
+
+// readArchive returns the contents of every entry and the parsed manifest.
+func readArchive(t *testing.T, data []byte) (map[string]string, models.ArchiveManifest) {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := make(map[string]string)
+	var manifest models.ArchiveManifest
+	for _, f := range zr.File {
+		if f.Method != zip.Store {
+			t.Errorf("%s: expected store mode, got method %d", f.Name, f.Method)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+		if f.Name == "manifest.json" {
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				t.Fatalf("invalid manifest: %v", err)
+			}
+		}
+	}
+	return files, manifest
+}
+
+func TestArchive_Filenames(t *testing.T) {
+	h := setupArchiveHandler(t)
+	if w := callMetadata(h.PatchMetadata, http.MethodPatch, "2024/video_2024-07-02_10-00-00.mkv", `{"tags": ["petg"]}`); w.Code != http.StatusOK {
+		t.Fatalf("failed to save metadata: %d", w.Code)
+	}
+
+	w := callArchive(h, `{"filenames": ["2024/video_2024-07-02_10-00-00.mkv", "video_2024-07-01_10-00-00.mp4"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="timelapses-`) {
+		t.Errorf("unexpected Content-Disposition %q", got)
+	}
+
+	files, manifest := readArchive(t, w.Body.Bytes())
+	want := map[string]string{
+		"video_2024-07-01_10-00-00.mp4":                    "first video",
+		"thumbnail/video_2024-07-01_10-00-00.jpg":          "first thumb",
+		"2024/video_2024-07-02_10-00-00.mkv":               "second video",
+		"2024/thumbnail/video_2024-07-02_10-00-00.jpg":     "second thumb",
+		"2024/thumbnail/video_2024-07-02_10-00-00_sm.webp": "second small",
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("%s: got %q, want %q", name, files[name], content)
+		}
+	}
+	if len(files) != len(want)+1 {
+		t.Errorf("unexpected entries: %v", len(files))
+	}
+
+	// The manifest follows the requested order and carries metadata
+	if len(manifest.Items) != 2 || manifest.Items[0].Filename != "2024/video_2024-07-02_10-00-00.mkv" {
+		t.Fatalf("unexpected manifest items: %+v", manifest.Items)
+	}
+	first := manifest.Items[0]
+	if first.TimelapseMeta == nil || len(first.Tags) != 1 || first.Tags[0] != "petg" {
+		t.Errorf("expected metadata in manifest, got %+v", first.TimelapseMeta)
+	}
+	if len(first.Thumbnails) != 2 || manifest.CreatedAt.IsZero() {
+		t.Errorf("unexpected manifest entry: %+v", first)
+	}
+}
+
+func TestArchive_DateRange(t *testing.T) {
+	h := setupArchiveHandler(t)
+
+	w := callArchive(h, `{"from": "2024-07-01", "to": "2024-07-02"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	_, manifest := readArchive(t, w.Body.Bytes())
+	if len(manifest.Items) != 2 || manifest.Items[0].Filename != "video_2024-07-01_10-00-00.mp4" || manifest.Items[1].Filename != "2024/video_2024-07-02_10-00-00.mkv" {
+		t.Errorf("expected the two July 1-2 videos oldest first, got %+v", manifest.Items)
+	}
+}
+
+func TestArchive_InvalidRequests(t *testing.T) {
+	h := setupArchiveHandler(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty", `{}`, http.StatusBadRequest},
+		{"malformed", `{"filenames": "video.mp4"}`, http.StatusBadRequest},
+		{"both", `{"filenames": ["video_2024-07-01_10-00-00.mp4"], "from": "2024-07-01"}`, http.StatusBadRequest},
+		{"bad date", `{"from": "July"}`, http.StatusBadRequest},
+		{"traversal", `{"filenames": ["../secret.mp4"]}`, http.StatusBadRequest},
+		{"unknown", `{"filenames": ["missing.mp4"]}`, http.StatusNotFound},
+		{"empty range", `{"from": "2030-01-01"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := callArchive(h, tt.body); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestArchive_Cancelled(t *testing.T) {
+	h := setupArchiveHandler(t)
+	snap, err := h.catalog.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	err = h.writeArchive(ctx, &buf, snap.Items, time.Now())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// No central directory is written, so the partial archive is unreadable
+	if _, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Error("expected a truncated archive")
+	}
+}
@@ -0,0 +1,646 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+)
+
+const (
+	thumbnailDirName = "thumbnail"
+
+	// DefaultScanDepth is how many levels of subfolders below the timelapse
+	// directory are scanned, enough for printer/year/month layouts.
+	DefaultScanDepth = 3
+
+	// DefaultVideosURL is where the timelapse directory is served from.
+	DefaultVideosURL = "/videos"
+)
+
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// CatalogSnapshot is a point-in-time copy of the catalog contents.
+type CatalogSnapshot struct {
+	Items      []models.Timelapse
+	Generation uint64
+	ScannedAt  time.Time
+	// ChangedAt is when the generation last increased
+	ChangedAt time.Time
+}
+
+// Catalog is an in-memory index of a timelapse directory. It is built by a
+// full scan and kept current by filesystem notifications, with a periodic
+// rescan as a fallback for changes the watcher misses (network mounts,
+// inotify overflow). The generation increases every time the contents change.
+//
+// Videos are keyed by their slash-separated path relative to the directory,
+// and each folder may have its own thumbnail/ folder. Hidden folders (such
+// as the trash) and folders deeper than the scan depth are ignored.
+type Catalog struct {
+	dir     string
+	depth   int
+	baseURL string
+
+	mu         sync.RWMutex
+	items      map[string]models.Timelapse
+	remote     map[string]models.Timelapse
+	thumbnails map[string]bool
+	folders    map[string]bool
+	generation uint64
+	changedAt  time.Time
+	scannedAt  time.Time
+	loaded     bool
+
+	scans  singleflight.Group
+	probes *media.Cache
+	hashes *hashCache
+	dates  *DateParser
+}
+
+func NewCatalog(dir string) *Catalog {
+	return &Catalog{
+		dir:     dir,
+		depth:   DefaultScanDepth,
+		baseURL: DefaultVideosURL,
+		probes:  media.NewCache(),
+		hashes:  newHashCache(),
+		dates:   DefaultDateParser(),
+	}
+}
+
+// abs converts a slash-separated path relative to the catalog root into a
+// filesystem path.
+func (c *Catalog) abs(rel string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(rel))
+}
+
+// Snapshot returns the current contents, scanning the directory first if
+// the catalog has never been loaded.
+func (c *Catalog) Snapshot() (CatalogSnapshot, error) {
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+
+	if !loaded {
+		if err := c.Rescan(); err != nil {
+			return CatalogSnapshot{}, err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]models.Timelapse, 0, len(c.items)+len(c.remote))
+	for _, t := range c.items {
+		items = append(items, t)
+	}
+	for name, t := range c.remote {
+		if _, local := c.items[name]; !local {
+			t.ThumbnailURL = c.thumbnailURL(name, c.thumbnails)
+			items = append(items, t)
+		}
+	}
+	return CatalogSnapshot{Items: items, Generation: c.generation, ScannedAt: c.scannedAt, ChangedAt: c.changedAt}, nil
+}
+
+// Generation returns the current generation without copying the contents,
+// and whether the catalog has been loaded yet.
+func (c *Catalog) Generation() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation, c.loaded
+}
+
+// Changed returns the current generation and when it last increased.
+func (c *Catalog) Changed() (uint64, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation, c.changedAt
+}
+
+// changed records a change to the contents. The caller must hold c.mu.
+func (c *Catalog) changed() {
+	c.generation++
+	c.changedAt = time.Now()
+}
+
+// Lookup returns one timelapse by its relative path, scanning first if
+// the catalog has not been loaded.
+func (c *Catalog) Lookup(name string) (models.Timelapse, bool, error) {
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+
+	if !loaded {
+		if err := c.Rescan(); err != nil {
+			return models.Timelapse{}, false, err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t, ok := c.items[name]; ok {
+		return t, true, nil
+	}
+	t, ok := c.remote[name]
+	if ok {
+		t.ThumbnailURL = c.thumbnailURL(name, c.thumbnails)
+	}
+	return t, ok, nil
+}
+
+// SetRemote replaces the videos known to be in remote storage. A video
+// present both locally and remotely is listed once, as the local copy.
+func (c *Catalog) SetRemote(objects []storage.Object) {
+	remote := make(map[string]models.Timelapse, len(objects))
+	for _, obj := range objects {
+		if storage.ValidKey(obj.Key) && videoExtensions[strings.ToLower(path.Ext(obj.Key))] {
+			remote[obj.Key] = c.newRemoteTimelapse(obj)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !maps.Equal(remote, c.remote) {
+		c.remote = remote
+		c.changed()
+	}
+}
+
+// AddRemote records one video uploaded to remote storage.
+func (c *Catalog) AddRemote(obj storage.Object) {
+	t := c.newRemoteTimelapse(obj)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remote == nil {
+		c.remote = make(map[string]models.Timelapse)
+	}
+	c.remote[obj.Key] = t
+	c.changed()
+}
+
+// RemoveRemote forgets a video deleted from remote storage.
+func (c *Catalog) RemoveRemote(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.remote[name]; ok {
+		delete(c.remote, name)
+		c.changed()
+	}
+}
+
+// ThumbnailVariants returns the URLs of every image in the thumbnail folder
+// next to the named video that belongs to it.
+func (c *Catalog) ThumbnailVariants(name string) []string {
+	thumbs := c.Thumbnails(name)
+	urls := make([]string, len(thumbs))
+	for i, thumb := range thumbs {
+		urls[i] = c.fileURL(thumb)
+	}
+	return urls
+}
+
+// Thumbnails returns the relative paths of the named video's thumbnail
+// images, sorted.
+func (c *Catalog) Thumbnails(name string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	folder := folderOf(name)
+	var others []string
+	for _, items := range []map[string]models.Timelapse{c.items, c.remote} {
+		for other, t := range items {
+			if t.Folder == folder {
+				others = append(others, path.Base(other))
+			}
+		}
+	}
+
+	thumbDir := thumbnailDir(name)
+	thumbs := make([]string, 0)
+	for thumb := range c.thumbnails {
+		if path.Dir(thumb) == thumbDir && isThumbnailVariant(path.Base(name), path.Base(thumb), others) {
+			thumbs = append(thumbs, thumb)
+		}
+	}
+	sort.Strings(thumbs)
+	return thumbs
+}
+
+// Refresh re-reads one video and the given thumbnails immediately, for
+// changes made by the server itself that should not wait for the watcher.
+// All names are relative to the catalog root.
+func (c *Catalog) Refresh(name string, thumbnails ...string) {
+	for _, thumb := range thumbnails {
+		c.updateThumbnail(thumb)
+	}
+	c.updateVideo(name)
+}
+
+// Rescan performs a full scan of the directory. Concurrent callers share a
+// single scan.
+func (c *Catalog) Rescan() error {
+	_, err, _ := c.scans.Do("scan", func() (any, error) {
+		scan, err := c.scanDir()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.loaded || !maps.Equal(scan.items, c.items) {
+			c.changed()
+		}
+		c.items = scan.items
+		c.thumbnails = scan.thumbnails
+		c.folders = scan.folders
+		c.scannedAt = time.Now()
+		c.loaded = true
+		return nil, nil
+	})
+	return err
+}
+
+// Run loads the catalog, then applies filesystem notifications and rescans
+// every interval until ctx is cancelled.
+func (c *Catalog) Run(ctx context.Context, interval time.Duration) {
+	if err := c.Rescan(); err != nil {
+		log.Printf("catalog: initial scan of %s failed: %v", c.dir, err)
+	}
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("catalog: filesystem notifications unavailable, relying on rescans: %v", err)
+	} else {
+		defer watcher.Close()
+		c.watch(watcher)
+		events = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Rescan(); err != nil {
+				log.Printf("catalog: rescan of %s failed: %v", c.dir, err)
+			}
+			if watcher != nil {
+				c.watch(watcher)
+			}
+		case ev := <-events:
+			c.applyEvent(watcher, ev)
+		case err := <-watchErrors:
+			// Usually an event queue overflow, so resync from scratch
+			log.Printf("catalog: watcher error: %v", err)
+			if err := c.Rescan(); err != nil {
+				log.Printf("catalog: rescan of %s failed: %v", c.dir, err)
+			}
+		}
+	}
+}
+
+// watch registers every scanned folder and its thumbnail folder with the
+// watcher. Adding an already watched path is a no-op, so this is safe to
+// repeat after each scan.
+func (c *Catalog) watch(watcher *fsnotify.Watcher) {
+	c.mu.RLock()
+	dirs := []string{c.dir, c.abs(thumbnailDirName)}
+	for folder := range c.folders {
+		if folder != "" {
+			dirs = append(dirs, c.abs(folder), c.abs(path.Join(folder, thumbnailDirName)))
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("catalog: cannot watch %s: %v", dir, err)
+		}
+	}
+}
+
+func (c *Catalog) applyEvent(watcher *fsnotify.Watcher, ev fsnotify.Event) {
+	rel, err := filepath.Rel(c.dir, ev.Name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	name := path.Base(rel)
+
+	if path.Base(path.Dir(rel)) == thumbnailDirName {
+		if !ev.Has(fsnotify.Chmod) {
+			c.updateThumbnail(rel)
+		}
+		return
+	}
+	if videoExtensions[strings.ToLower(path.Ext(name))] {
+		c.updateVideo(rel)
+		return
+	}
+
+	// Anything else only matters if a folder appeared or vanished
+	switch {
+	case ev.Has(fsnotify.Create):
+		if info, err := os.Stat(ev.Name); err != nil || !info.IsDir() {
+			return
+		}
+		// Watch the new folder before scanning so files written into it
+		// right away are not missed
+		if err := watcher.Add(ev.Name); err != nil {
+			log.Printf("catalog: cannot watch %s: %v", ev.Name, err)
+		}
+	case ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename):
+		c.mu.RLock()
+		known := c.folders[rel] || name == thumbnailDirName
+		c.mu.RUnlock()
+		if !known {
+			return
+		}
+	default:
+		return
+	}
+
+	if err := c.Rescan(); err != nil {
+		log.Printf("catalog: rescan of %s failed: %v", c.dir, err)
+	}
+	c.watch(watcher)
+}
+
+func (c *Catalog) updateVideo(name string) {
+	file := c.abs(name)
+	info, err := os.Stat(file)
+	exists := err == nil && info.Mode().IsRegular()
+
+	var meta media.Info
+	var health media.Health
+	if exists {
+		meta, _ = c.probes.Probe(file, info)
+		health = c.probes.Check(file, info)
+	} else {
+		c.probes.Forget(file)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		return
+	}
+
+	if !exists {
+		if _, ok := c.items[name]; ok {
+			delete(c.items, name)
+			c.changed()
+		}
+		return
+	}
+
+	t := c.newTimelapse(name, info, meta, health, c.thumbnails)
+	if old, ok := c.items[name]; !ok || old != t {
+		c.items[name] = t
+		c.changed()
+	}
+}
+
+func (c *Catalog) updateThumbnail(name string) {
+	info, err := os.Stat(c.abs(name))
+	exists := err == nil && !info.IsDir()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded || c.thumbnails[name] == exists {
+		return
+	}
+
+	if exists {
+		c.thumbnails[name] = true
+	} else {
+		delete(c.thumbnails, name)
+	}
+
+	thumbDir, base := path.Dir(name), baseName(name)
+	for videoName, t := range c.items {
+		if thumbnailDir(videoName) == thumbDir && baseName(videoName) == base {
+			t.ThumbnailURL = c.thumbnailURL(videoName, c.thumbnails)
+			c.items[videoName] = t
+		}
+	}
+	c.changed()
+}
+
+// catalogScan is the result of a full directory scan.
+type catalogScan struct {
+	items      map[string]models.Timelapse
+	thumbnails map[string]bool
+	folders    map[string]bool
+}
+
+// scanDir reads the timelapse directory and its subfolders.
+func (c *Catalog) scanDir() (catalogScan, error) {
+	scan := catalogScan{
+		items:      make(map[string]models.Timelapse),
+		thumbnails: make(map[string]bool),
+		folders:    make(map[string]bool),
+	}
+	probed := make(map[string]bool)
+	if err := c.scanFolder("", 0, &scan, probed); err != nil {
+		return scan, err
+	}
+	c.probes.Retain(probed)
+	return scan, nil
+}
+
+// scanFolder adds the videos and thumbnails of one folder and recurses into
+// its subfolders up to the scan depth. Only a failure to read the root is
+// an error; unreadable subfolders are logged and skipped.
+func (c *Catalog) scanFolder(folder string, depth int, scan *catalogScan, probed map[string]bool) error {
+	entries, err := os.ReadDir(c.abs(folder))
+	if err != nil {
+		return err
+	}
+	scan.folders[folder] = true
+
+	// Build a set of thumbnail paths for fast lookup
+	thumbDir := path.Join(folder, thumbnailDirName)
+	if thumbEntries, err := os.ReadDir(c.abs(thumbDir)); err == nil {
+		for _, e := range thumbEntries {
+			if !e.IsDir() {
+				scan.thumbnails[path.Join(thumbDir, e.Name())] = true
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		name := path.Join(folder, entry.Name())
+		if entry.IsDir() {
+			if depth < c.depth && !skipFolder(entry.Name()) {
+				if err := c.scanFolder(name, depth+1, scan, probed); err != nil {
+					log.Printf("timelapses: failed to read directory %s: %v", name, err)
+				}
+			}
+			continue
+		}
+
+		if !videoExtensions[strings.ToLower(path.Ext(name))] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Printf("timelapses: failed to stat %s: %v", name, err)
+			continue
+		}
+
+		// Unreadable or half-written files are still listed, just without
+		// media metadata
+		file := c.abs(name)
+		meta, _ := c.probes.Probe(file, info)
+		health := c.probes.Check(file, info)
+		probed[file] = true
+		scan.items[name] = c.newTimelapse(name, info, meta, health, scan.thumbnails)
+	}
+	return nil
+}
+
+// skipFolder reports whether a subfolder is never scanned for videos.
+func skipFolder(name string) bool {
+	return strings.HasPrefix(name, ".") || name == thumbnailDirName
+}
+
+func (c *Catalog) newTimelapse(name string, info os.FileInfo, meta media.Info, health media.Health, thumbnails map[string]bool) models.Timelapse {
+	date, source := c.dates.Resolve(path.Base(name), meta, info.ModTime())
+
+	return models.Timelapse{
+		Filename:     name,
+		Folder:       folderOf(name),
+		URL:          c.fileURL(name),
+		ThumbnailURL: c.thumbnailURL(name, thumbnails),
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Date:         date,
+		DateSource:   source,
+		Duration:     meta.Duration.Seconds(),
+		Width:        meta.Width,
+		Height:       meta.Height,
+		Codec:        meta.Codec,
+		FPS:          meta.FPS,
+		CreationTime: meta.CreationTime,
+		Health:       health.Status,
+		HealthReason: health.Reason,
+	}
+}
+
+// newRemoteTimelapse describes a video in remote storage. Nothing is
+// probed, since that would mean downloading it; only healthy videos are
+// offloaded in the first place. The URL is filled in by Remote.Attach.
+func (c *Catalog) newRemoteTimelapse(obj storage.Object) models.Timelapse {
+	date, source := c.dates.Resolve(path.Base(obj.Key), media.Info{}, obj.ModTime)
+	return models.Timelapse{
+		Filename:   obj.Key,
+		Folder:     folderOf(obj.Key),
+		Size:       obj.Size,
+		Date:       date,
+		DateSource: source,
+		Health:     models.HealthHealthy,
+		Remote:     true,
+	}
+}
+
+// isThumbnailVariant reports whether thumb is an image belonging to video:
+// the video's base name itself, or the base name followed by "_", "-" or
+// "." and a suffix (video.jpg, video_small.webp). Images of a clip of
+// video, or matching the longer base name of one of others (the videos
+// next to it), belong to that video instead. All are bare file names.
+func isThumbnailVariant(video, thumb string, others []string) bool {
+	base := baseName(video)
+	if !imageExtensions[strings.ToLower(filepath.Ext(thumb))] || !hasBasePrefix(thumb, base) {
+		return false
+	}
+
+	// Clip names extend the source's, and a clip's thumbnails may outlive it
+	thumbBase := strings.TrimSuffix(thumb, path.Ext(thumb))
+	for i := len(base) + 1; i <= len(thumbBase); i++ {
+		if i < len(thumbBase) && !strings.ContainsRune("_-.", rune(thumbBase[i])) {
+			continue
+		}
+		if m := clipPattern.FindStringSubmatch(thumbBase[:i]); m != nil && m[1] == base {
+			return false
+		}
+	}
+	for _, other := range others {
+		if b := baseName(other); len(b) > len(base) && hasBasePrefix(thumb, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasBasePrefix reports whether a file name is base followed by "_", "-"
+// or ".".
+func hasBasePrefix(name, base string) bool {
+	rest, ok := strings.CutPrefix(name, base)
+	return ok && rest != "" && strings.ContainsRune("_-.", rune(rest[0]))
+}
+
+// thumbnailURL matches a video to a thumbnail with the same base name and a
+// .jpg extension in the thumbnail folder next to it, returning "" if there
+// is none.
+func (c *Catalog) thumbnailURL(name string, thumbnails map[string]bool) string {
+	thumb := path.Join(thumbnailDir(name), baseName(name)+".jpg")
+	if !thumbnails[thumb] {
+		return ""
+	}
+	return c.fileURL(thumb)
+}
+
+// thumbnailDir returns the thumbnail folder next to a video, relative to
+// the catalog root.
+func thumbnailDir(name string) string {
+	return path.Join(path.Dir(name), thumbnailDirName)
+}
+
+// folderOf returns the folder holding a video, or "" for the root.
+func folderOf(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// baseName strips the folder and extension from a path.
+func baseName(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// fileURL returns the public URL of a file under the timelapse directory.
+func (c *Catalog) fileURL(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return c.baseURL + "/" + strings.Join(parts, "/")
+}
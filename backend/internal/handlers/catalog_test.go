@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func findTimelapse(items []models.Timelapse, name string) (models.Timelapse, bool) {
+	for _, t := range items {
+		if t.Filename == name {
+			return t, true
+		}
+	}
+	return models.Timelapse{}, false
+}
+
+// waitForSnapshot polls the catalog until cond holds or the deadline passes.
+func waitForSnapshot(t *testing.T, c *Catalog, cond func(CatalogSnapshot) bool) CatalogSnapshot {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snap, err := c.Snapshot()
+		if err != nil {
+			t.Fatalf("snapshot failed: %v", err)
+		}
+		if cond(snap) {
+			return snap
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before deadline; last snapshot has %d items (generation %d)", len(snap.Items), snap.Generation)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCatalog_SnapshotScansOnce(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-07-24_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCatalog(tmpDir)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snap, err := c.Snapshot()
+			if err != nil {
+				t.Errorf("snapshot failed: %v", err)
+				return
+			}
+			if len(snap.Items) != 1 {
+				t.Errorf("expected 1 item, got %d", len(snap.Items))
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Generation != 1 {
+		t.Errorf("expected generation 1 after initial scan, got %d", snap.Generation)
+	}
+	if snap.ScannedAt.IsZero() {
+		t.Error("expected non-zero scan time")
+	}
+
+	// An unchanged directory does not bump the generation
+	if err := c.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	snap, _ = c.Snapshot()
+	if snap.Generation != 1 {
+		t.Errorf("expected generation 1 after no-op rescan, got %d", snap.Generation)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-07-25_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	snap, _ = c.Snapshot()
+	if snap.Generation != 2 || len(snap.Items) != 2 {
+		t.Errorf("expected generation 2 with 2 items, got generation %d with %d items", snap.Generation, len(snap.Items))
+	}
+}
+
+func TestCatalog_MissingDir(t *testing.T) {
+	c := NewCatalog("/nonexistent/path")
+	if _, err := c.Snapshot(); err == nil {
+		t.Fatal("expected error for missing directory")
+	}
+}
+
+func TestCatalog_WatchAppliesChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := NewCatalog(tmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, time.Hour)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the initial scan so the watcher is registered
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool { return s.Generation == 1 })
+	time.Sleep(50 * time.Millisecond)
+
+	name := "video_2024-07-24_09-14-01.mp4"
+	if err := os.WriteFile(filepath.Join(tmpDir, name), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool {
+		item, ok := findTimelapse(s.Items, name)
+		return ok && item.Size == 100
+	})
+
+	thumbDir := filepath.Join(tmpDir, thumbnailDirName)
+	if err := os.Mkdir(thumbDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool { return len(s.Items) == 1 })
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.WriteFile(filepath.Join(thumbDir, "video_2024-07-24_09-14-01.jpg"), []byte("thumb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool {
+		item, ok := findTimelapse(s.Items, name)
+		return ok && item.ThumbnailURL == "/videos/thumbnail/video_2024-07-24_09-14-01.jpg"
+	})
+
+	if err := os.Remove(filepath.Join(tmpDir, name)); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool { return len(s.Items) == 0 })
+}
+
+func TestCatalog_NestedFolders(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := []string{
+		"video_2024-06-30_10-00-00.mp4",
+		"2024/07/video_2024-07-01_10-00-00.mp4",
+		"2024/07/thumbnail/video_2024-07-01_10-00-00.jpg",
+		"2024/07/thumbnail/video_2024-07-01_10-00-00_small.webp",
+		"printer one/video_2024-07-02_10-00-00.mkv",
+		"a/b/c/d/video_2024-07-03_10-00-00.mp4",
+		".trash/video_2024-07-04_10-00-00.mp4",
+		"thumbnail/nested/video_2024-07-05_10-00-00.mp4",
+		// A root-level thumbnail does not belong to a nested video
+		"thumbnail/video_2024-07-02_10-00-00.jpg",
+	}
+	for _, f := range files {
+		p := filepath.Join(tmpDir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewCatalog(tmpDir)
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Items) != 3 {
+		t.Fatalf("expected 3 items within the default depth, got %v", snap.Items)
+	}
+
+	nested, ok := findTimelapse(snap.Items, "2024/07/video_2024-07-01_10-00-00.mp4")
+	if !ok {
+		t.Fatal("expected nested video in catalog")
+	}
+	if nested.Folder != "2024/07" {
+		t.Errorf("expected folder 2024/07, got %q", nested.Folder)
+	}
+	if nested.URL != "/videos/2024/07/video_2024-07-01_10-00-00.mp4" {
+		t.Errorf("unexpected url %q", nested.URL)
+	}
+	if nested.ThumbnailURL != "/videos/2024/07/thumbnail/video_2024-07-01_10-00-00.jpg" {
+		t.Errorf("unexpected thumbnail url %q", nested.ThumbnailURL)
+	}
+	if got := c.ThumbnailVariants(nested.Filename); len(got) != 2 {
+		t.Errorf("expected 2 thumbnail variants, got %v", got)
+	}
+
+	spaced, ok := findTimelapse(snap.Items, "printer one/video_2024-07-02_10-00-00.mkv")
+	if !ok {
+		t.Fatal("expected video in folder with a space")
+	}
+	if spaced.URL != "/videos/printer%20one/video_2024-07-02_10-00-00.mkv" || spaced.ThumbnailURL != "" {
+		t.Errorf("unexpected urls %q, %q", spaced.URL, spaced.ThumbnailURL)
+	}
+
+	root, _ := findTimelapse(snap.Items, "video_2024-06-30_10-00-00.mp4")
+	if root.Folder != "" {
+		t.Errorf("expected empty folder for top-level video, got %q", root.Folder)
+	}
+
+	// Deeper folders appear once the depth allows
+	c = NewCatalog(tmpDir)
+	c.depth = 4
+	snap, _ = c.Snapshot()
+	if _, ok := findTimelapse(snap.Items, "a/b/c/d/video_2024-07-03_10-00-00.mp4"); !ok {
+		t.Error("expected depth 4 to include a/b/c/d")
+	}
+
+	c = NewCatalog(tmpDir)
+	c.depth = 0
+	snap, _ = c.Snapshot()
+	if len(snap.Items) != 1 {
+		t.Errorf("expected only the top-level video at depth 0, got %d", len(snap.Items))
+	}
+}
+
+func TestCatalog_WatchSubfolders(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := NewCatalog(tmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, time.Hour)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool { return s.Generation == 1 })
+	time.Sleep(50 * time.Millisecond)
+
+	folder := filepath.Join(tmpDir, "2024", "07")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Let the watcher pick up both new folders
+	time.Sleep(200 * time.Millisecond)
+
+	name := "2024/07/video_2024-07-24_09-14-01.mp4"
+	if err := os.WriteFile(filepath.Join(folder, "video_2024-07-24_09-14-01.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool {
+		item, ok := findTimelapse(s.Items, name)
+		return ok && item.Size == 100
+	})
+
+	if err := os.RemoveAll(filepath.Join(tmpDir, "2024")); err != nil {
+		t.Fatal(err)
+	}
+	waitForSnapshot(t, c, func(s CatalogSnapshot) bool { return len(s.Items) == 0 })
+}
+
+func TestIsThumbnailVariant(t *testing.T) {
+	others := []string{"video.mp4", "video_2.mp4", "video_clip_0s-5s.mp4"}
+	tests := []struct {
+		thumb string
+		want  bool
+	}{
+		{"video.jpg", true},
+		{"video_small.webp", true},
+		{"video.mp4.png", true},
+		{"video_2.jpg", false},
+		{"video_clip_0s-5s.jpg", false},
+		{"video_clip_0s-5s_small.jpg", false},
+		// A deleted clip's leftover thumbnail is still not the source's
+		{"video_clip_10s-12.5s.jpg", false},
+		{"video.txt", false},
+		{"videos.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := isThumbnailVariant("video.mp4", tt.thumb, others); got != tt.want {
+			t.Errorf("isThumbnailVariant(%q) = %v, want %v", tt.thumb, got, tt.want)
+		}
+	}
+}
+
+func TestCatalog_ClipThumbnails(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := "video_2024-07-01_10-00-00.mp4"
+	clip := "video_2024-07-01_10-00-00_clip_0s-5s.mp4"
+	files := []string{
+		source,
+		clip,
+		"thumbnail/video_2024-07-01_10-00-00.jpg",
+		"thumbnail/video_2024-07-01_10-00-00_small.jpg",
+		"thumbnail/video_2024-07-01_10-00-00_clip_0s-5s.jpg",
+	}
+	for _, f := range files {
+		p := filepath.Join(tmpDir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewCatalog(tmpDir)
+	if _, err := c.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"thumbnail/video_2024-07-01_10-00-00.jpg", "thumbnail/video_2024-07-01_10-00-00_small.jpg"}
+	if got := c.Thumbnails(source); !slices.Equal(got, want) {
+		t.Errorf("expected the source's own thumbnails %v, got %v", want, got)
+	}
+	if got := c.Thumbnails(clip); len(got) != 1 {
+		t.Errorf("expected the clip's thumbnail, got %v", got)
+	}
+
+	// Trashing the source leaves the clip's thumbnail in place
+	moved, err := NewTrash(tmpDir).Move(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(moved, want) {
+		t.Errorf("expected only the source's thumbnails moved, got %v", moved)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "thumbnail", "video_2024-07-01_10-00-00_clip_0s-5s.jpg")); err != nil {
+		t.Errorf("expected the clip's thumbnail to stay: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const clipTimeout = 30 * time.Minute
+
+// clipPattern matches clip names: the source's base name followed by
+// _clip_<start>s-<end>s. The source's date stays at the front of the name,
+// so clips sort and filter with their source.
+var clipPattern = regexp.MustCompile(`^(.+)_clip_(\d+(?:\.\d+)?)s-(\d+(?:\.\d+)?)s$`)
+
+// clipName returns the path of a clip of source, next to it.
+func clipName(source string, start, end time.Duration) string {
+	base := fmt.Sprintf("%s_clip_%ss-%ss.mp4", baseName(source), clipSeconds(start), clipSeconds(end))
+	if folder := folderOf(source); folder != "" {
+		return folder + "/" + base
+	}
+	return base
+}
+
+func clipSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Round(time.Millisecond).Seconds(), 'f', -1, 64)
+}
+
+// parseClipName reports whether name is a clip, and if so the base path of
+// its source (without extension) and the cut points in seconds.
+func parseClipName(name string) (sourceBase string, start, end float64, ok bool) {
+	m := clipPattern.FindStringSubmatch(baseName(name))
+	if m == nil {
+		return "", 0, 0, false
+	}
+	start, _ = strconv.ParseFloat(m[2], 64)
+	end, _ = strconv.ParseFloat(m[3], 64)
+	return path.Join(folderOf(name), m[1]), start, end, true
+}
+
+// linkClips sets Clip on every clip in items, pointing at the source video
+// in the same folder with the same base name.
+func linkClips(items []models.Timelapse) {
+	sources := make(map[string]string, len(items))
+	for _, t := range items {
+		sources[path.Join(t.Folder, baseName(t.Filename))] = t.Filename
+	}
+	for i := range items {
+		if base, start, end, ok := parseClipName(items[i].Filename); ok {
+			items[i].Clip = &models.ClipInfo{Source: sources[base], Start: start, End: end}
+		}
+	}
+}
+
+// clipTime is a position in a video, given in JSON either as seconds or as
+// a "[[hh:]mm:]ss[.fff]" string.
+type clipTime time.Duration
+
+func (t *clipTime) UnmarshalJSON(data []byte) error {
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err == nil {
+		return t.set(secs)
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("timestamps must be seconds or hh:mm:ss")
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid timestamp %q", s)
+	}
+	secs = 0
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		// Only the last part may have a fraction, and only the first may
+		// be 60 or more
+		if err != nil || n < 0 || (i < len(parts)-1 && n != math.Trunc(n)) || (i > 0 && n >= 60) {
+			return fmt.Errorf("invalid timestamp %q", s)
+		}
+		secs = secs*60 + n
+	}
+	return t.set(secs)
+}
+
+func (t *clipTime) set(secs float64) error {
+	if secs < 0 || math.IsNaN(secs) || math.IsInf(secs, 0) || secs > 7*24*3600 {
+		return errors.New("timestamps must be between zero and one week")
+	}
+	*t = clipTime(time.Duration(secs * float64(time.Second)).Round(time.Millisecond))
+	return nil
+}
+
+// clipRequest is the body of POST /timelapses/:filename/clip.
+type clipRequest struct {
+	Start    *clipTime `json:"start"`
+	End      *clipTime `json:"end"`
+	Reencode bool      `json:"reencode"`
+}
+
+// CreateClip cuts start to end out of a timelapse into a new MP4 next to
+// it. Streams are copied unless reencode is set, so by default the clip
+// begins at the keyframe before start.
+func (h *TimelapseHandler) CreateClip(c *gin.Context) {
+	var req clipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if req.Start == nil || req.End == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start and end are required"})
+		return
+	}
+	start, end := time.Duration(*req.Start), time.Duration(*req.End)
+	if end <= start {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be after start"})
+		return
+	}
+
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if item.Remote {
+		c.JSON(http.StatusConflict, gin.H{"error": "remote timelapses cannot be clipped"})
+		return
+	}
+	if item.Duration > 0 && end.Seconds() > item.Duration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("end is past the end of the video (%.3fs)", item.Duration)})
+		return
+	}
+	if !h.transcoder.ffmpeg.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "clipping is not configured"})
+		return
+	}
+
+	name := clipName(item.Filename, start, end)
+	dst := filepath.Join(h.dir, filepath.FromSlash(name))
+	if _, err := os.Lstat(dst); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "a timelapse with that name already exists"})
+		return
+	}
+
+	clearWriteDeadline(c, "clip")
+
+	if err := h.clip(c, item.Filename, dst, start, end, req.Reencode); err != nil {
+		log.Printf("clip: failed to clip %s: %v", item.Filename, err)
+		msg := "failed to create clip"
+		if !req.Reencode {
+			msg += "; the streams may not fit in MP4, try again with reencode"
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		return
+	}
+
+	h.catalog.Refresh(name)
+	clip, found, err := h.catalog.Lookup(name)
+	if err != nil || !found {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read clip"})
+		return
+	}
+	h.transcoder.attach(&clip)
+	h.previews.attach(&clip)
+	_, s, e, _ := parseClipName(name)
+	clip.Clip = &models.ClipInfo{Source: item.Filename, Start: s, End: e}
+	c.JSON(http.StatusCreated, clip)
+}
+
+func (h *TimelapseHandler) clip(c *gin.Context, name, dst string, start, end time.Duration, reencode bool) error {
+	release, err := h.transcoder.acquire(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), clipTimeout)
+	defer cancel()
+	// Shutting down stops the clip too
+	stop := context.AfterFunc(h.transcoder.ctx, cancel)
+	defer stop()
+	return h.transcoder.ffmpeg.Clip(ctx, filepath.Join(h.dir, filepath.FromSlash(name)), dst, start, end, reencode)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg/ffmpegtest"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestClipName(t *testing.T) {
+	name := clipName("2024/video_2024-07-01_10-00-00.mkv", 90*time.Second, 3*time.Minute+1500*time.Millisecond)
+	if name != "2024/video_2024-07-01_10-00-00_clip_90s-181.5s.mp4" {
+		t.Fatalf("unexpected clip name %q", name)
+	}
+
+	// Clips keep the source's date
+	if got := DefaultDateParser().ParseFilename(name); !got.Equal(time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the source date, got %v", got)
+	}
+
+	base, start, end, ok := parseClipName(name)
+	if !ok || base != "2024/video_2024-07-01_10-00-00" || start != 90 || end != 181.5 {
+		t.Errorf("unexpected parse: %q %v %v %v", base, start, end, ok)
+	}
+	if _, _, _, ok := parseClipName("video_2024-07-01_10-00-00.mp4"); ok {
+		t.Error("expected a plain video not to parse as a clip")
+	}
+}
+
+func TestCreateClip(t *testing.T) {
+	tmpDir := setupQueryDir(t)
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	t.Cleanup(func() { h.Close() })
+	source := "video_2024-07-03_10-00-00.mkv"
+
+	w := callMetadata(h.CreateClip, http.MethodPost, source, `{"start": "0:30", "end": 90}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var clip models.Timelapse
+	if err := json.Unmarshal(w.Body.Bytes(), &clip); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if clip.Filename != "video_2024-07-03_10-00-00_clip_30s-90s.mp4" || clip.Clip == nil || clip.Clip.Source != source {
+		t.Fatalf("unexpected clip: %+v", clip)
+	}
+	if clip.Clip.Start != 30 || clip.Clip.End != 90 || clip.TranscodeState != models.TranscodeNative {
+		t.Errorf("unexpected clip details: %+v %+v", clip.Clip, clip)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, clip.Filename)); err != nil {
+		t.Fatalf("expected the clip next to its source: %v", err)
+	}
+
+	// The listing links the clip to its source and sorts it alongside
+	_, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	names := filenames(page.Items)
+	if len(names) != 6 || names[3] != clip.Filename {
+		t.Fatalf("expected the clip after its source, got %v", names)
+	}
+	if listed := page.Items[3]; listed.Clip == nil || listed.Clip.Source != source {
+		t.Errorf("expected the listed clip to link to its source, got %+v", listed.Clip)
+	}
+	if page.Items[2].Clip != nil {
+		t.Errorf("expected the source not to be a clip, got %+v", page.Items[2].Clip)
+	}
+
+	if w = callMetadata(h.CreateClip, http.MethodPost, source, `{"start": 30, "end": 90}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an existing clip, got %d", w.Code)
+	}
+}
+
+func TestCreateClip_InvalidRequests(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t), WithFFmpeg(ffmpegtest.Fail(t, "Could not find tag for codec")))
+	t.Cleanup(func() { h.Close() })
+	name := "video_2024-07-01_10-00-00.mp4"
+
+	tests := []struct {
+		name     string
+		filename string
+		body     string
+		want     int
+	}{
+		{"missing end", name, `{"start": 1}`, http.StatusBadRequest},
+		{"end before start", name, `{"start": 10, "end": 5}`, http.StatusBadRequest},
+		{"negative", name, `{"start": -1, "end": 5}`, http.StatusBadRequest},
+		{"bad timestamp", name, `{"start": "1:75", "end": "2:00"}`, http.StatusBadRequest},
+		{"traversal", "../video.mp4", `{"start": 1, "end": 5}`, http.StatusBadRequest},
+		{"missing video", "missing.mp4", `{"start": 1, "end": 5}`, http.StatusNotFound},
+		{"ffmpeg fails", name, `{"start": 1, "end": 5}`, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if w := callMetadata(h.CreateClip, http.MethodPost, tt.filename, tt.body); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(h.dir, "video_2024-07-01_10-00-00_clip_1s-5s.mp4")); !os.IsNotExist(err) {
+		t.Error("expected no clip after a failure")
+	}
+
+	unconfigured := NewTimelapseHandler(setupQueryDir(t))
+	t.Cleanup(func() { unconfigured.Close() })
+	if w := callMetadata(unconfigured.CreateClip, http.MethodPost, name, `{"start": 1, "end": 5}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without ffmpeg, got %d", w.Code)
+	}
+}
+
+func TestClipTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{`12.5`, 12500 * time.Millisecond, true},
+		{`"45"`, 45 * time.Second, true},
+		{`"1:02.25"`, 62250 * time.Millisecond, true},
+		{`"1:00:00"`, time.Hour, true},
+		{`"90:00"`, 90 * time.Minute, true},
+		{`"1:60"`, 0, false},
+		{`"1.5:00"`, 0, false},
+		{`"a:b"`, 0, false},
+		{`"1:2:3:4"`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		var got clipTime
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err == nil) != tt.ok || (tt.ok && time.Duration(got) != tt.want) {
+			t.Errorf("%s: got %v, %v", tt.in, time.Duration(got), err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bootID tells apart validators from different runs of the server, whose
+// counters all start again from zero.
+var bootID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// changeCounter counts changes to state that responses show, and
+// remembers when the last one happened.
+type changeCounter struct {
+	mu sync.Mutex
+	n  uint64
+	at time.Time
+}
+
+func (c *changeCounter) bump() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	c.at = time.Now()
+}
+
+func (c *changeCounter) get() (uint64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n, c.at
+}
+
+// validators returns the ETag and Last-Modified of a response built from
+// the catalog at generation. Besides the catalog, listings show stored
+// metadata, transcoding and preview state and presigned URLs, so a change
+// to any of them changes both. Read them before building the response, so
+// a change while it is built makes them stale rather than the response.
+// extra parts go into the ETag for responses that depend on more than
+// that state.
+func (h *TimelapseHandler) validators(generation uint64, changedAt time.Time, extra ...string) (string, time.Time) {
+	lastModified := changedAt
+	parts := append([]string{bootID, strconv.FormatUint(generation, 10)}, extra...)
+	for _, counter := range []*changeCounter{&h.metadata.changes, &h.transcoder.changes, &h.previews.changes} {
+		n, at := counter.get()
+		parts = append(parts, strconv.FormatUint(n, 10))
+		if at.After(lastModified) {
+			lastModified = at
+		}
+	}
+	if rotated := h.remote.urlsChangedAt(time.Now()); !rotated.IsZero() {
+		parts = append(parts, strconv.FormatInt(rotated.Unix(), 36))
+		if rotated.After(lastModified) {
+			lastModified = rotated
+		}
+	}
+	return fmt.Sprintf(`W/"%s"`, strings.Join(parts, "-")), lastModified
+}
+
+// notModified sets the ETag and Last-Modified validators and, when the
+// request's conditional headers match them, answers 304 and returns true.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if Fresh(c.Request.Header, etag, lastModified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Fresh reports whether a GET request's conditional headers match a
+// response's validators, so a 304 can be sent instead. As in RFC 9110,
+// If-Modified-Since is ignored when If-None-Match is sent.
+func Fresh(header http.Header, etag string, lastModified time.Time) bool {
+	if inm := header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatches(inm, etag)
+	}
+	if ims := header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match list names etag, comparing
+// weakly as GET requests do.
+func etagMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// DefaultDateLayouts matches filenames like video_2024-07-24_09-14-01.mp4
+var DefaultDateLayouts = []string{"2006-01-02_15-04-05"}
+
+// layoutTokens maps Go reference-time elements to the text they match.
+// Elements without a regex are not supported and rejected, rather than
+// taken as literal text that never matches. Longer tokens come first so
+// "January" wins over "Jan" and "2006" over "2".
+var layoutTokens = []struct {
+	token string
+	regex string
+}{
+	{"January", ""},
+	{"Jan", `[A-Za-z]{3}`},
+	{"Monday", ""},
+	{"Mon", ""},
+	{"MST", ""},
+	{"2006", `\d{4}`},
+	{"_2006", `_\d{4}`},
+	{"__2", ""},
+	{"_2", ""},
+	{"002", ""},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"03", ""},
+	{"15", `\d{2}`},
+	{"04", `\d{2}`},
+	{"05", `\d{2}`},
+	{"06", `\d{2}`},
+	{"1", ""},
+	{"2", ""},
+	{"3", ""},
+	{"4", ""},
+	{"5", ""},
+	{"PM", ""},
+	{"pm", ""},
+	{"Z07", ""},
+	{"-07", ""},
+}
+
+// fractionToken returns the fractional-seconds element at the start of
+// layout, such as ".000" or ",999", using the same rule as package time:
+// a run of 0s or 9s after a separator that no other digit follows.
+func fractionToken(layout string) string {
+	if len(layout) < 2 || (layout[0] != '.' && layout[0] != ',') || (layout[1] != '0' && layout[1] != '9') {
+		return ""
+	}
+	j := 1
+	for j < len(layout) && layout[j] == layout[1] {
+		j++
+	}
+	if j < len(layout) && layout[j] >= '0' && layout[j] <= '9' {
+		return ""
+	}
+	return layout[:j]
+}
+
+type datePattern struct {
+	layout string
+	regex  *regexp.Regexp
+}
+
+// DateParser extracts print dates from timelapse filenames. Filenames carry
+// no zone, so times are interpreted in the printer's configured location.
+type DateParser struct {
+	patterns []datePattern
+	loc      *time.Location
+}
+
+// NewDateParser builds a parser from Go time layouts, tried in order, that
+// may appear anywhere in a filename.
+func NewDateParser(layouts []string, loc *time.Location) (*DateParser, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	p := &DateParser{loc: loc}
+	for _, layout := range layouts {
+		re, err := layoutRegexp(layout)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, datePattern{layout: layout, regex: re})
+	}
+	return p, nil
+}
+
+// DefaultDateParser parses DefaultDateLayouts as UTC.
+func DefaultDateParser() *DateParser {
+	p, _ := NewDateParser(DefaultDateLayouts, time.UTC)
+	return p
+}
+
+func layoutRegexp(layout string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	var hasYear bool
+	for rest := layout; rest != ""; {
+		if frac := fractionToken(rest); frac != "" {
+			if frac != ".000" {
+				return nil, fmt.Errorf("date layout %q uses unsupported element %q", layout, frac)
+			}
+			sb.WriteString(`\.\d{3}`)
+			rest = rest[len(frac):]
+			continue
+		}
+
+		matched := false
+		for _, t := range layoutTokens {
+			if strings.HasPrefix(rest, t.token) {
+				if t.regex == "" {
+					return nil, fmt.Errorf("date layout %q uses unsupported element %q", layout, t.token)
+				}
+				sb.WriteString(t.regex)
+				rest = rest[len(t.token):]
+				hasYear = hasYear || t.token == "2006" || t.token == "_2006" || t.token == "06"
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	if !hasYear {
+		return nil, fmt.Errorf("date layout %q has no year", layout)
+	}
+	return regexp.Compile(sb.String())
+}
+
+// ParseFilename returns the first valid date embedded in name, or the zero
+// time if no layout matches. Every position a layout matches at is tried,
+// so a number that only looks like a date doesn't hide a real one.
+func (p *DateParser) ParseFilename(name string) time.Time {
+	for _, pattern := range p.patterns {
+		for start := 0; start < len(name); {
+			loc := pattern.regex.FindStringIndex(name[start:])
+			if loc == nil {
+				break
+			}
+			match := name[start+loc[0] : start+loc[1]]
+			if t, err := time.ParseInLocation(pattern.layout, match, p.loc); err == nil {
+				return t
+			}
+			start += loc[0] + 1
+		}
+	}
+	return time.Time{}
+}
+
+// Resolve picks a timelapse's date, preferring the filename, then the
+// container creation time, then the file modification time, and reports
+// which source was used.
+func (p *DateParser) Resolve(name string, meta media.Info, modTime time.Time) (time.Time, string) {
+	if t := p.ParseFilename(name); !t.IsZero() {
+		return t, models.DateSourceFilename
+	}
+	if !meta.CreationTime.IsZero() {
+		return meta.CreationTime, models.DateSourceMetadata
+	}
+	return modTime, models.DateSourceModTime
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestParseFilename_DefaultLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected time.Time
+	}{
+		{"video_2024-07-24_09-14-01.mp4", time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC)},
+		{"video_2024-12-31_23-59-59.mp4", time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"random_file.mp4", time.Time{}},
+		{"no_date_here.txt", time.Time{}},
+		// Invalid date components: regex matches but time.Parse rejects
+		{"video_2024-13-32_25-61-61.mp4", time.Time{}},
+		// Multiple date patterns: should use the first match
+		{"video_2024-01-01_00-00-00_2025-12-31_23-59-59.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// An invalid match doesn't hide a valid one later on
+		{"video_2024-13-32_25-61-61_2024-07-24_09-14-01.mp4", time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC)},
+		{"video_12024-07-24_09-14-01.mp4", time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC)},
+		// Partial match
+		{"video_2024-07-24.mp4", time.Time{}},
+	}
+
+	p := DefaultDateParser()
+	for _, tt := range tests {
+		result := p.ParseFilename(tt.name)
+		if !result.Equal(tt.expected) {
+			t.Errorf("ParseFilename(%q) = %v, want %v", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestParseFilename_CustomLayoutsAndZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %v", err)
+	}
+
+	p, err := NewDateParser([]string{"2006-01-02_15-04-05", "20060102T150405", "Jan_02_2006"}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected time.Time
+	}{
+		{"video_2024-07-24_09-14-01.mp4", time.Date(2024, 7, 24, 9, 14, 1, 0, loc)},
+		{"print-20240724T091401.mkv", time.Date(2024, 7, 24, 9, 14, 1, 0, loc)},
+		{"Jul_24_2024_benchy.mp4", time.Date(2024, 7, 24, 0, 0, 0, 0, loc)},
+		{"benchy.mp4", time.Time{}},
+	}
+	for _, tt := range tests {
+		result := p.ParseFilename(tt.name)
+		if !result.Equal(tt.expected) {
+			t.Errorf("ParseFilename(%q) = %v, want %v", tt.name, result, tt.expected)
+		}
+	}
+
+	// Local 09:14 in July is 14:14 UTC (CDT, UTC-5)
+	got := p.ParseFilename("video_2024-07-24_09-14-01.mp4").UTC()
+	if want := time.Date(2024, 7, 24, 14, 14, 1, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("expected %v in UTC, got %v", want, got)
+	}
+}
+
+func TestNewDateParser_RejectsLayoutWithoutYear(t *testing.T) {
+	if _, err := NewDateParser([]string{"15-04-05"}, time.UTC); err == nil {
+		t.Error("expected error for layout without a year")
+	}
+}
+
+func TestNewDateParser_RejectsUnsupportedElements(t *testing.T) {
+	for _, layout := range []string{
+		"2006-1-2", "2006-01-_2", "Mon_2006-01-02", "January_2006", "2006-01-02_03-04PM",
+		"2006-01-02_15-04-05MST", "2006-01-02T15:04:05Z07:00", "2006-002", "2006-01-02_15-04-05.999",
+	} {
+		if _, err := NewDateParser([]string{layout}, time.UTC); err == nil {
+			t.Errorf("expected error for layout %q", layout)
+		}
+	}
+
+	// Literal digits and fractional seconds in milliseconds are fine
+	p, err := NewDateParser([]string{"v0_2006-01-02_15-04-05.000"}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.ParseFilename("v0_2024-07-24_09-14-01.250.mp4")
+	if want := time.Date(2024, 7, 24, 9, 14, 1, 250_000_000, time.UTC); !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestDateParserResolve(t *testing.T) {
+	p := DefaultDateParser()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	modTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		meta       media.Info
+		wantDate   time.Time
+		wantSource string
+	}{
+		{"video_2024-07-24_09-14-01.mp4", media.Info{CreationTime: created}, time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC), models.DateSourceFilename},
+		{"benchy.mp4", media.Info{CreationTime: created}, created, models.DateSourceMetadata},
+		{"benchy.mp4", media.Info{}, modTime, models.DateSourceModTime},
+	}
+	for _, tt := range tests {
+		date, source := p.Resolve(tt.name, tt.meta, modTime)
+		if !date.Equal(tt.wantDate) || source != tt.wantSource {
+			t.Errorf("Resolve(%q) = %v, %s; want %v, %s", tt.name, date, source, tt.wantDate, tt.wantSource)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clearWriteDeadline lifts the server's write timeout for a response that
+// can legitimately take longer, such as a large download or a request
+// that waits on a long job. who prefixes the log line on failure.
+func clearWriteDeadline(c *gin.Context, who string) {
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("%s: failed to clear write deadline: %v", who, err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// derivedCache remembers whether the files derived from each video, such
+// as renditions and previews, are current. Entries are keyed by the
+// video's size and mtime as the catalog last saw them, so a change the
+// catalog picks up is checked again while listings otherwise avoid
+// touching the disk. Whoever writes or removes the derived files calls
+// forget.
+type derivedCache struct {
+	mu      sync.Mutex
+	entries map[string]derivedEntry
+}
+
+type derivedEntry struct {
+	size    int64
+	modTime time.Time
+	fresh   bool
+}
+
+// check returns the cached answer for item, calling fresh when there is
+// none for this version of the video.
+func (d *derivedCache) check(item models.Timelapse, fresh func(name string) bool) bool {
+	d.mu.Lock()
+	e, ok := d.entries[item.Filename]
+	d.mu.Unlock()
+	if ok && e.size == item.Size && e.modTime.Equal(item.ModTime) {
+		return e.fresh
+	}
+
+	e = derivedEntry{size: item.Size, modTime: item.ModTime, fresh: fresh(item.Filename)}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]derivedEntry)
+	}
+	d.entries[item.Filename] = e
+	return e.fresh
+}
+
+func (d *derivedCache) forget(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, name)
+}
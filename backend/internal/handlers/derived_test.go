@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestDerivedCache(t *testing.T) {
+	var d derivedCache
+	calls := 0
+	fresh := func(string) bool {
+		calls++
+		return true
+	}
+	item := models.Timelapse{Filename: "video.mkv", Size: 10, ModTime: time.Unix(100, 0)}
+
+	d.check(item, fresh)
+	d.check(item, fresh)
+	if calls != 1 {
+		t.Errorf("expected one check for the same version, got %d", calls)
+	}
+
+	item.ModTime = time.Unix(200, 0)
+	d.check(item, fresh)
+	item.Size = 20
+	d.check(item, fresh)
+	if calls != 3 {
+		t.Errorf("expected a check for each new version, got %d", calls)
+	}
+
+	d.forget("video.mkv")
+	d.check(item, fresh)
+	if calls != 4 {
+		t.Errorf("expected a check after forget, got %d", calls)
+	}
+}
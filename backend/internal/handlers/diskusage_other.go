@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// diskUsage is not implemented on this platform.
+func diskUsage(dir string) (models.StorageUsage, error) {
+	return models.StorageUsage{}, errors.ErrUnsupported
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package handlers
+
+import (
+	"syscall"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// diskUsage reports the size and usage of the filesystem holding dir.
+func diskUsage(dir string) (models.StorageUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return models.StorageUsage{}, err
+	}
+	bsize := int64(st.Bsize)
+	return models.StorageUsage{
+		TotalBytes: int64(st.Blocks) * bsize,
+		UsedBytes:  (int64(st.Blocks) - int64(st.Bfree)) * bsize,
+		FreeBytes:  int64(st.Bavail) * bsize,
+	}, nil
+}
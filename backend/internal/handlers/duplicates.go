@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// copySuffix matches the endings file managers and sync tools add to
+// copies: "video (1)", "video copy", "video_copy2".
+var copySuffix = regexp.MustCompile(`(?i)(\s*\(\d+\)|[ _-]*copy\s*\d*)$`)
+
+// betterCopy reports whether a is a better copy to keep than b: one with
+// recorded metadata, then one whose name carries its date, then one
+// without a copy suffix, then the shallower and shorter path.
+func betterCopy(a, b models.Timelapse) bool {
+	if aMeta, bMeta := a.TimelapseMeta != nil, b.TimelapseMeta != nil; aMeta != bMeta {
+		return aMeta
+	}
+	if aDated, bDated := a.DateSource == models.DateSourceFilename, b.DateSource == models.DateSourceFilename; aDated != bDated {
+		return aDated
+	}
+	if aCopy, bCopy := copySuffix.MatchString(baseName(a.Filename)), copySuffix.MatchString(baseName(b.Filename)); aCopy != bCopy {
+		return bCopy
+	}
+	if d := strings.Count(a.Filename, "/") - strings.Count(b.Filename, "/"); d != 0 {
+		return d < 0
+	}
+	if len(a.Filename) != len(b.Filename) {
+		return len(a.Filename) < len(b.Filename)
+	}
+	return a.Filename < b.Filename
+}
+
+// findDuplicates groups items with identical content. Only files of equal
+// size are hashed, first partially and, with verify, in full.
+func (h *TimelapseHandler) findDuplicates(ctx context.Context, items []models.Timelapse, verify bool) (models.DuplicateReport, error) {
+	report := models.DuplicateReport{Groups: make([]models.DuplicateGroup, 0), Verified: verify}
+
+	bySize := make(map[int64][]models.Timelapse)
+	keep := make(map[string]bool, len(items))
+	for _, t := range items {
+		keep[h.catalog.abs(t.Filename)] = true
+		// Remote videos would have to be downloaded to hash
+		if t.Size > 0 && !t.Remote {
+			bySize[t.Size] = append(bySize[t.Size], t)
+		}
+	}
+	defer h.catalog.hashes.retain(keep)
+
+	for size, sameSize := range bySize {
+		if len(sameSize) < 2 {
+			continue
+		}
+		byPartial, err := groupBy(sameSize, h.catalog.PartialHash)
+		if err != nil {
+			return report, err
+		}
+		for partial, candidates := range byPartial {
+			if len(candidates) < 2 {
+				continue
+			}
+			if !verify {
+				report.Groups = append(report.Groups, newDuplicateGroup(size, partial, "", candidates))
+				continue
+			}
+			byFull, err := groupBy(candidates, func(name string) (string, error) {
+				return h.catalog.SHA256(ctx, name)
+			})
+			if err != nil {
+				return report, err
+			}
+			for full, identical := range byFull {
+				if len(identical) >= 2 {
+					report.Groups = append(report.Groups, newDuplicateGroup(size, partial, full, identical))
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(report.Groups, func(a, b models.DuplicateGroup) int {
+		if c := cmp.Compare(b.Size*int64(len(b.Items)-1), a.Size*int64(len(a.Items)-1)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Keep, b.Keep)
+	})
+	for _, g := range report.Groups {
+		report.DuplicateFiles += len(g.Items) - 1
+		report.ReclaimableBytes += g.Size * int64(len(g.Items)-1)
+	}
+	return report, nil
+}
+
+// groupBy splits items by the hash of each. Files removed since the
+// snapshot are left out.
+func groupBy(items []models.Timelapse, hash func(name string) (string, error)) (map[string][]models.Timelapse, error) {
+	groups := make(map[string][]models.Timelapse)
+	for _, t := range items {
+		sum, err := hash(t.Filename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		groups[sum] = append(groups[sum], t)
+	}
+	return groups, nil
+}
+
+func newDuplicateGroup(size int64, partial, full string, items []models.Timelapse) models.DuplicateGroup {
+	slices.SortFunc(items, func(a, b models.Timelapse) int {
+		if betterCopy(a, b) {
+			return -1
+		}
+		if betterCopy(b, a) {
+			return 1
+		}
+		return 0
+	})
+	return models.DuplicateGroup{Size: size, PartialHash: partial, SHA256: full, Keep: items[0].Filename, Items: items}
+}
+
+// duplicates builds the report for the current catalog. Hashing many
+// large files outlasts the server's write timeout, so it is cleared first.
+func (h *TimelapseHandler) duplicates(c *gin.Context, verify bool) (models.DuplicateReport, bool) {
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return models.DuplicateReport{}, false
+	}
+	if err := h.decorate(snapshot.Items); err != nil {
+		log.Printf("metadata: failed to read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse metadata"})
+		return models.DuplicateReport{}, false
+	}
+
+	clearWriteDeadline(c, "duplicates")
+	report, err := h.findDuplicates(c.Request.Context(), snapshot.Items, verify)
+	if err != nil {
+		log.Printf("duplicates: failed to hash timelapses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash timelapses"})
+		return models.DuplicateReport{}, false
+	}
+	return report, true
+}
+
+// Duplicates lists groups of identical timelapses. By default files are
+// matched on size and a partial hash; verify=true confirms each group
+// with a full SHA-256.
+func (h *TimelapseHandler) Duplicates(c *gin.Context) {
+	verify := false
+	if v := c.Query("verify"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verify"})
+			return
+		}
+		verify = b
+	}
+
+	if report, ok := h.duplicates(c, verify); ok {
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// Dedupe moves every copy but the best-named one of each verified group
+// to the trash, and returns what was moved.
+func (h *TimelapseHandler) Dedupe(c *gin.Context) {
+	report, ok := h.duplicates(c, true)
+	if !ok {
+		return
+	}
+
+	removed := make([]models.DedupedFile, 0)
+	for _, g := range report.Groups {
+		for _, t := range g.Items[1:] {
+			thumbs, err := h.trash.Move(t.Filename)
+			if err != nil {
+				if !errors.Is(err, errNotFound) {
+					log.Printf("duplicates: failed to remove %s: %v", t.Filename, err)
+				}
+				continue
+			}
+			h.catalog.Refresh(t.Filename, thumbs...)
+			log.Printf("duplicates: removed %s, a copy of %s", t.Filename, g.Keep)
+			removed = append(removed, models.DedupedFile{Filename: t.Filename, Kept: g.Keep, Size: t.Size})
+		}
+	}
+	c.JSON(http.StatusOK, removed)
+}
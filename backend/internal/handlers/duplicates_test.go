@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// setupDuplicateDir writes three copies of one video, a video of the same
+// size that differs only in the middle, and an unrelated one.
+func setupDuplicateDir(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	original := bytes.Repeat([]byte("timelapse frame "), 3*partialHashChunk/16)
+	changed := bytes.Clone(original)
+	copy(changed[len(changed)/2:], "different")
+
+	files := map[string][]byte{
+		"video_2024-07-01_10-00-00.mp4":            original,
+		"copies/video_2024-07-01_10-00-00 (1).mp4": original,
+		"backup.mp4":                    original,
+		"video_2024-07-02_10-00-00.mp4": changed,
+		"video_2024-07-03_10-00-00.mp4": []byte("unrelated"),
+	}
+	writeFiles(t, tmpDir, files)
+	return tmpDir
+}
+
+func duplicateReport(t *testing.T, h *TimelapseHandler, target string) models.DuplicateReport {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	h.Duplicates(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report models.DuplicateReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return report
+}
+
+func TestBetterCopy(t *testing.T) {
+	dated := models.Timelapse{Filename: "video_2024-07-01_10-00-00.mp4", DateSource: models.DateSourceFilename}
+	tests := []struct {
+		name        string
+		better, not models.Timelapse
+	}{
+		{"metadata wins", models.Timelapse{Filename: "backup.mp4", TimelapseMeta: &models.TimelapseMeta{Starred: true}}, dated},
+		{"dated name", dated, models.Timelapse{Filename: "backup.mp4", DateSource: models.DateSourceModTime}},
+		{"no copy suffix", dated, models.Timelapse{Filename: "video_2024-07-01_10-00-00 (1).mp4", DateSource: models.DateSourceFilename}},
+		{"no copy word", dated, models.Timelapse{Filename: "video_2024-07-01_10-00-00_copy2.mp4", DateSource: models.DateSourceFilename}},
+		{"shallower", dated, models.Timelapse{Filename: "a/video_2024-07-01.mp4", DateSource: models.DateSourceFilename}},
+		{"shorter", models.Timelapse{Filename: "b.mp4"}, models.Timelapse{Filename: "aa.mp4"}},
+	}
+	for _, tt := range tests {
+		if !betterCopy(tt.better, tt.not) || betterCopy(tt.not, tt.better) {
+			t.Errorf("%s: expected %s to be kept over %s", tt.name, tt.better.Filename, tt.not.Filename)
+		}
+	}
+}
+
+func TestPartialHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := NewCatalog(tmpDir)
+
+	for _, size := range []int{0, 10, partialHashChunk + 10, 3 * partialHashChunk} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		if err := os.WriteFile(filepath.Join(tmpDir, "a.mp4"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Same ends, different middle
+		if size > 0 {
+			data[size/2] = 'y'
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "b.mp4"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		a, errA := c.PartialHash("a.mp4")
+		b, errB := c.PartialHash("b.mp4")
+		if errA != nil || errB != nil {
+			t.Fatalf("%d bytes: %v, %v", size, errA, errB)
+		}
+		// Small files are hashed whole
+		if sameEnds := size == 0 || size > 2*partialHashChunk; (a == b) != sameEnds {
+			t.Errorf("%d bytes: expected equal partial hashes to be %v", size, sameEnds)
+		}
+
+		fullA, _ := c.SHA256(context.Background(), "a.mp4")
+		fullB, _ := c.SHA256(context.Background(), "b.mp4")
+		if (fullA == fullB) != (size == 0) {
+			t.Errorf("%d bytes: unexpected full hashes %s, %s", size, fullA, fullB)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	h := NewTimelapseHandler(setupDuplicateDir(t))
+	t.Cleanup(func() { h.Close() })
+
+	// Partial hashes cannot tell the changed middle apart
+	report := duplicateReport(t, h, "/api/timelapses/duplicates")
+	if report.Verified || len(report.Groups) != 1 || len(report.Groups[0].Items) != 4 {
+		t.Fatalf("expected one unverified group of 4, got %+v", report)
+	}
+
+	report = duplicateReport(t, h, "/api/timelapses/duplicates?verify=true")
+	if !report.Verified || len(report.Groups) != 1 {
+		t.Fatalf("expected one verified group, got %+v", report)
+	}
+	group := report.Groups[0]
+	// A dated name beats an undated one even with a copy suffix
+	want := []string{"video_2024-07-01_10-00-00.mp4", "copies/video_2024-07-01_10-00-00 (1).mp4", "backup.mp4"}
+	if got := filenames(group.Items); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if group.Keep != want[0] || group.SHA256 == "" {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if report.DuplicateFiles != 2 || report.ReclaimableBytes != 2*group.Size {
+		t.Errorf("unexpected totals %d files, %d bytes", report.DuplicateFiles, report.ReclaimableBytes)
+	}
+
+	// Recorded metadata makes a copy worth keeping
+	if _, err := h.metadata.Update("backup.mp4", func(m *models.TimelapseMeta) error {
+		m.Notes = "first print with the new nozzle"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if report = duplicateReport(t, h, "/api/timelapses/duplicates?verify=true"); report.Groups[0].Keep != "backup.mp4" {
+		t.Errorf("expected the annotated copy to be kept, got %s", report.Groups[0].Keep)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses/duplicates?verify=maybe", nil)
+	h.Duplicates(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid verify, got %d", w.Code)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := setupDuplicateDir(t)
+	h := NewTimelapseHandler(tmpDir)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/timelapses/duplicates/dedupe", nil)
+	h.Dedupe(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var removed []models.DedupedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &removed); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 copies removed, got %+v", removed)
+	}
+	for _, r := range removed {
+		if r.Kept != "video_2024-07-01_10-00-00.mp4" {
+			t.Errorf("expected the dated copy to be kept, got %+v", r)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, trashDirName, filepath.FromSlash(r.Filename))); err != nil {
+			t.Errorf("expected %s in the trash: %v", r.Filename, err)
+		}
+	}
+
+	// The file that only looked the same survives
+	_, page := listPage(t, h, "/api/timelapses")
+	if page.Total != 3 {
+		t.Errorf("expected 3 timelapses left, got %v", filenames(page.Items))
+	}
+	if report := duplicateReport(t, h, "/api/timelapses/duplicates?verify=true"); len(report.Groups) != 0 {
+		t.Errorf("expected no duplicates left, got %+v", report.Groups)
+	}
+}
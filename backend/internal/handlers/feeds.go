@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	// feedLimit is how many of the newest timelapses a feed lists.
+	feedLimit = 50
+
+	feedTitle     = "Printer timelapses"
+	mediaRSSSpace = "http://search.yahoo.com/mrss/"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Media   string      `xml:"xmlns:media,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Published string        `xml:"published"`
+	Updated   string        `xml:"updated"`
+	Summary   string        `xml:"summary"`
+	Links     []atomLink    `xml:"link"`
+	Media     []mediaObject `xml:"media:content"`
+	Thumbnail *mediaObject  `xml:"media:thumbnail"`
+}
+
+// mediaObject is a Media RSS media:content or media:thumbnail element.
+type mediaObject struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Medium string `xml:"medium,attr,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Enclosure   rssEnclosure  `xml:"enclosure"`
+	Media       []mediaObject `xml:"media:content"`
+	Thumbnail   *mediaObject  `xml:"media:thumbnail"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// feedEntry is what both feed formats say about one timelapse, with
+// absolute URLs.
+type feedEntry struct {
+	Title     string
+	Summary   string
+	Date      time.Time
+	URL       string
+	Type      string
+	Size      int64
+	Thumbnail string
+}
+
+// AtomFeed serves the newest timelapses as an Atom feed.
+func (h *TimelapseHandler) AtomFeed(c *gin.Context) {
+	h.feed(c, "atom")
+}
+
+// RSSFeed serves the newest timelapses as an RSS 2.0 feed.
+func (h *TimelapseHandler) RSSFeed(c *gin.Context) {
+	h.feed(c, "rss")
+}
+
+// feed writes the feed in format. Incomplete and corrupt videos are left
+// out, so a download in progress only appears once it has finished.
+func (h *TimelapseHandler) feed(c *gin.Context, format string) {
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+	// Presigned enclosure URLs change with each window
+	lastModified := snapshot.ChangedAt
+	etag := fmt.Sprintf(`W/"%s-%s-%d"`, format, bootID, snapshot.Generation)
+	if rotated := h.remote.urlsChangedAt(time.Now()); !rotated.IsZero() {
+		if rotated.After(lastModified) {
+			lastModified = rotated
+		}
+		etag = fmt.Sprintf(`W/"%s-%s-%d-%d"`, format, bootID, snapshot.Generation, rotated.Unix())
+	}
+	if notModified(c, etag, lastModified) {
+		return
+	}
+
+	base := requestBaseURL(c)
+	items := snapshot.Items
+	sortTimelapses(items, sortNewest)
+	h.remote.Attach(items)
+	entries := make([]feedEntry, 0, feedLimit)
+	for _, t := range items {
+		if len(entries) == feedLimit {
+			break
+		}
+		if t.Health != models.HealthHealthy {
+			continue
+		}
+		entries = append(entries, newFeedEntry(base, t))
+	}
+	// The feed changes when its newest entry does
+	updated := snapshot.ChangedAt
+	if len(entries) > 0 {
+		updated = entries[0].Date
+	}
+
+	self := resolveURL(base, c.Request.URL.Path)
+	var feed any
+	contentType := "application/atom+xml; charset=utf-8"
+	if format == "atom" {
+		feed = newAtomFeed(base, self, updated, entries)
+	} else {
+		feed = newRSSFeed(base, self, updated, entries)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("feeds: failed to encode %s feed: %v", format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build feed"})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), out...))
+}
+
+func newFeedEntry(base string, t models.Timelapse) feedEntry {
+	details := make([]string, 0, 3)
+	if t.Duration > 0 {
+		details = append(details, time.Duration(t.Duration*float64(time.Second)).Round(time.Second).String())
+	}
+	if t.Width > 0 && t.Height > 0 {
+		details = append(details, fmt.Sprintf("%dx%d", t.Width, t.Height))
+	}
+	details = append(details, formatSize(t.Size))
+
+	e := feedEntry{
+		Title:   "Timelapse " + t.Date.Format("Mon 2 Jan 2006 15:04"),
+		Summary: strings.Join(details, ", "),
+		Date:    t.Date,
+		URL:     resolveURL(base, t.URL),
+		Type:    mediaTypes[strings.ToLower(path.Ext(t.Filename))],
+		Size:    t.Size,
+	}
+	if t.ThumbnailURL != "" {
+		e.Thumbnail = resolveURL(base, t.ThumbnailURL)
+	}
+	return e
+}
+
+func newAtomFeed(base, self string, updated time.Time, entries []feedEntry) atomFeed {
+	feed := atomFeed{
+		Media:   mediaRSSSpace,
+		ID:      self,
+		Title:   feedTitle,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: self, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: base + "/", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		date := e.Date.UTC().Format(time.RFC3339)
+		entry := atomEntry{
+			ID:        e.URL,
+			Title:     e.Title,
+			Published: date,
+			Updated:   date,
+			Summary:   e.Summary,
+			Links: []atomLink{
+				{Rel: "alternate", Href: e.URL, Type: e.Type},
+				{Rel: "enclosure", Href: e.URL, Type: e.Type, Length: e.Size},
+			},
+		}
+		entry.Media, entry.Thumbnail = feedMedia(e)
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func newRSSFeed(base, self string, updated time.Time, entries []feedEntry) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Media:   mediaRSSSpace,
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + "/",
+			Description:   "New timelapses from the printer",
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Rel: "self", Href: self, Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(entries)),
+		},
+	}
+	for _, e := range entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.URL,
+			GUID:        rssGUID{Value: e.URL},
+			PubDate:     e.Date.UTC().Format(time.RFC1123Z),
+			Description: e.Summary,
+			Enclosure:   rssEnclosure{URL: e.URL, Length: e.Size, Type: e.Type},
+		}
+		item.Media, item.Thumbnail = feedMedia(e)
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+// feedMedia describes the video, and its thumbnail when there is one, as
+// Media RSS.
+func feedMedia(e feedEntry) ([]mediaObject, *mediaObject) {
+	content := []mediaObject{{URL: e.URL, Type: e.Type, Medium: "video"}}
+	if e.Thumbnail == "" {
+		return content, nil
+	}
+	thumbType := mediaTypes[strings.ToLower(path.Ext(e.Thumbnail))]
+	content = append(content, mediaObject{URL: e.Thumbnail, Type: thumbType, Medium: "image"})
+	return content, &mediaObject{URL: e.Thumbnail}
+}
+
+// formatSize formats a byte count with a binary unit, as "12.3 MiB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// requestBaseURL returns the scheme and host the client used, trusting
+// X-Forwarded-Proto from the reverse proxy.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto, _, _ := strings.Cut(c.GetHeader("X-Forwarded-Proto"), ","); proto != "" {
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// resolveURL makes ref absolute against base. References that are
+// already absolute, such as a videos URL on another host, are kept.
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
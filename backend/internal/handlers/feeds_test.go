@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+)
+
+// setupFeedDir writes two healthy videos, one with a thumbnail, and a
+// download still in progress.
+func setupFeedDir(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+	partial := mediatest.MP4(mediatest.Video{MoovAtEnd: true, MdatSize: 4096})
+	files := map[string][]byte{
+		"video_2024-07-01_10-00-00.mp4":           mediatest.MP4(mediatest.Video{Duration: 90 * time.Second, Width: 1920, Height: 1080}),
+		"video_2024-07-02_10-00-00.mkv":           mediatest.Matroska(mediatest.Video{Duration: 30 * time.Second}),
+		"video_2024-07-03_10-00-00.mp4":           partial[:2048],
+		"thumbnail/video_2024-07-01_10-00-00.jpg": []byte("thumb"),
+	}
+	writeFiles(t, tmpDir, files)
+	return tmpDir
+}
+
+func serveFeed(h *TimelapseHandler, handler func(*TimelapseHandler, *gin.Context), target string, header http.Header) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	c.Request.Header = header
+	handler(h, c)
+	return w
+}
+
+func TestAtomFeed(t *testing.T) {
+	h := NewTimelapseHandler(setupFeedDir(t))
+	w := serveFeed(h, (*TimelapseHandler).AtomFeed, "http://printer.example/feeds/timelapses.atom", http.Header{"X-Forwarded-Proto": {"https"}})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("expected an Atom content type, got %s", ct)
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse feed: %v", err)
+	}
+
+	if feed.ID != "https://printer.example/feeds/timelapses.atom" {
+		t.Errorf("expected the feed id to be its absolute URL, got %s", feed.ID)
+	}
+	// The partial download is left out
+	if len(feed.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", feed.Entries)
+	}
+	if feed.Updated != "2024-07-02T10:00:00Z" {
+		t.Errorf("expected the feed to be updated with its newest entry, got %s", feed.Updated)
+	}
+
+	mkv, mp4 := feed.Entries[0], feed.Entries[1]
+	if mkv.Title != "Timelapse Tue 2 Jul 2024 10:00" || mkv.Updated != "2024-07-02T10:00:00Z" {
+		t.Errorf("unexpected entry %+v", mkv)
+	}
+	if strings.Contains(w.Body.String(), "video_2024-07-02_10-00-00.jpg") {
+		t.Error("expected no thumbnail without one on disk")
+	}
+
+	wantURL := "https://printer.example/videos/video_2024-07-01_10-00-00.mp4"
+	size := int64(len(mediatest.MP4(mediatest.Video{Duration: 90 * time.Second, Width: 1920, Height: 1080})))
+	enclosure := mp4.Links[1]
+	if enclosure.Rel != "enclosure" || enclosure.Href != wantURL || enclosure.Type != "video/mp4" || enclosure.Length != size {
+		t.Errorf("unexpected enclosure %+v", enclosure)
+	}
+	wantThumb := "https://printer.example/videos/thumbnail/video_2024-07-01_10-00-00.jpg"
+	// encoding/xml cannot read back the prefixed Media RSS elements
+	for _, want := range []string{
+		`<media:content url="` + wantURL + `" type="video/mp4" medium="video"></media:content>`,
+		`<media:content url="` + wantThumb + `" type="image/jpeg" medium="image"></media:content>`,
+		`<media:thumbnail url="` + wantThumb + `"></media:thumbnail>`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected %s in the feed", want)
+		}
+	}
+	if !strings.Contains(mp4.Summary, "1m30s") || !strings.Contains(mp4.Summary, "1920x1080") {
+		t.Errorf("expected duration and resolution in the summary, got %q", mp4.Summary)
+	}
+}
+
+func TestRSSFeed(t *testing.T) {
+	h := NewTimelapseHandler(setupFeedDir(t))
+	w := serveFeed(h, (*TimelapseHandler).RSSFeed, "http://printer.example/feeds/timelapses.rss", http.Header{})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var feed rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse feed: %v", err)
+	}
+	items := feed.Channel.Items
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %+v", items)
+	}
+	if items[0].PubDate != "Tue, 02 Jul 2024 10:00:00 +0000" || feed.Channel.LastBuildDate != items[0].PubDate {
+		t.Errorf("unexpected dates %s, %s", items[0].PubDate, feed.Channel.LastBuildDate)
+	}
+	if e := items[0].Enclosure; e.URL != "http://printer.example/videos/video_2024-07-02_10-00-00.mkv" || e.Type != "video/x-matroska" || e.Length == 0 {
+		t.Errorf("unexpected enclosure %+v", e)
+	}
+	if items[0].GUID.IsPermaLink || items[0].GUID.Value != items[0].Enclosure.URL {
+		t.Errorf("unexpected guid %+v", items[0].GUID)
+	}
+}
+
+func TestFeed_ConditionalGet(t *testing.T) {
+	tmpDir := setupFeedDir(t)
+	h := NewTimelapseHandler(tmpDir)
+	target := "http://printer.example/feeds/timelapses.atom"
+
+	w := serveFeed(h, (*TimelapseHandler).AtomFeed, target, http.Header{})
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("expected validators, got %v", w.Header())
+	}
+
+	if w = serveFeed(h, (*TimelapseHandler).AtomFeed, target, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w = serveFeed(h, (*TimelapseHandler).AtomFeed, target, http.Header{"If-Modified-Since": {lastModified}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged feed, got %d", w.Code)
+	}
+	// If-None-Match wins over If-Modified-Since
+	header := http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}
+	if w = serveFeed(h, (*TimelapseHandler).AtomFeed, target, header); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", w.Code)
+	}
+
+	// A new timelapse changes the feed
+	name := "video_2024-07-04_10-00-00.mp4"
+	if err := os.WriteFile(filepath.Join(tmpDir, name), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	h.catalog.Refresh(name)
+	if w = serveFeed(h, (*TimelapseHandler).AtomFeed, target, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after a new timelapse, got %d", w.Code)
+	}
+	if w = serveFeed(h, (*TimelapseHandler).AtomFeed, target, http.Header{"If-Modified-Since": {lastModified}}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after a new timelapse, got %d", w.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+)
+
+// partialHashChunk is how much of each end of a file the partial hash
+// reads.
+const partialHashChunk = 64 << 10
+
+// hashCache memoises file hashes per path. Like the probe cache, an entry
+// is reused only while the file's size and modification time are
+// unchanged.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashEntry
+}
+
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	partial string
+	full    string
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{entries: make(map[string]hashEntry)}
+}
+
+// get returns the entry for path if it still matches info.
+func (c *hashCache) get(path string, info os.FileInfo) hashEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok || e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+		return hashEntry{size: info.Size(), modTime: info.ModTime()}
+	}
+	return e
+}
+
+func (c *hashCache) put(path string, e hashEntry) {
+	c.mu.Lock()
+	c.entries[path] = e
+	c.mu.Unlock()
+}
+
+// retain drops every entry whose path is not in keep.
+func (c *hashCache) retain(keep map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.entries {
+		if !keep[path] {
+			delete(c.entries, path)
+		}
+	}
+}
+
+// PartialHash returns a fast fingerprint of a video: SHA-256 over its size
+// and the first and last 64 KiB. Files with different fingerprints always
+// differ; equal fingerprints need SHA256 to confirm.
+func (c *Catalog) PartialHash(name string) (string, error) {
+	file := c.abs(name)
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	e := c.hashes.get(file, info)
+	if e.partial != "" {
+		return e.partial, nil
+	}
+
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(info.Size())))
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, partialHashChunk)); err != nil {
+		return "", err
+	}
+	if tail := info.Size() - partialHashChunk; tail > partialHashChunk {
+		if _, err := io.Copy(h, io.NewSectionReader(f, tail, partialHashChunk)); err != nil {
+			return "", err
+		}
+	} else if tail > 0 {
+		// The ends overlap; hash the rest once
+		if _, err := io.Copy(h, io.NewSectionReader(f, partialHashChunk, tail)); err != nil {
+			return "", err
+		}
+	}
+	e.partial = hex.EncodeToString(h.Sum(nil))
+	if cur := c.hashes.get(file, info); cur.full != "" {
+		e.full = cur.full
+	}
+	c.hashes.put(file, e)
+	return e.partial, nil
+}
+
+// SHA256 returns the SHA-256 of a whole video. Reading stops when ctx is
+// done.
+func (c *Catalog) SHA256(ctx context.Context, name string) (string, error) {
+	file := c.abs(name)
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	e := c.hashes.get(file, info)
+	if e.full != "" {
+		return e.full, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, storage.ContextReader(ctx, f)); err != nil {
+		return "", err
+	}
+	e.full = hex.EncodeToString(h.Sum(nil))
+	// Keep a partial hash computed while this one ran
+	if cur := c.hashes.get(file, info); cur.partial != "" {
+		e.partial = cur.partial
+	}
+	c.hashes.put(file, e)
+	return e.full, nil
+}
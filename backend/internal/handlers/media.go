@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	videosCacheControl = "public, max-age=86400"
+	liveCacheControl   = "no-cache, no-store, must-revalidate"
+)
+
+// mediaTypes matches the types blocks in the nginx config, so the browser
+// and hls.js see the same Content-Type from either server.
+var mediaTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mp4":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// MediaMount maps a URL prefix such as /videos or /live/printer-2 onto a
+// directory on disk.
+type MediaMount struct {
+	Prefix string
+	Dir    string
+	// Live mounts hold the HLS stream and are never cached
+	Live bool
+}
+
+// MediaServer serves timelapse videos and the HLS stream without a reverse
+// proxy in front. Files are opened through os.Root, so neither ".." nor a
+// symlink can reach outside a mount, and dotfiles such as .trash and the
+// metadata database are never served.
+type MediaServer struct {
+	mounts []MediaMount
+}
+
+// NewMediaServer checks the mounts and orders them so the longest prefix
+// wins when one is nested under another.
+func NewMediaServer(mounts []MediaMount) (*MediaServer, error) {
+	seen := make(map[string]bool)
+	for i, m := range mounts {
+		prefix := strings.TrimSuffix(m.Prefix, "/")
+		if !strings.HasPrefix(prefix, "/") || path.Clean(prefix) != prefix {
+			return nil, fmt.Errorf("invalid media prefix %q", m.Prefix)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("duplicate media prefix %q", prefix)
+		}
+		seen[prefix] = true
+		mounts[i].Prefix = prefix
+	}
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].Prefix) > len(mounts[j].Prefix) })
+	return &MediaServer{mounts: mounts}, nil
+}
+
+// MediaMounts returns where each printer's videos and stream directory
+// should be served. URLs that point at another host are left out.
+func (r *PrinterRegistry) MediaMounts() []MediaMount {
+	var mounts []MediaMount
+	for _, p := range r.printers {
+		if strings.HasPrefix(p.Config.VideosURL, "/") {
+			mounts = append(mounts, MediaMount{Prefix: p.Config.VideosURL, Dir: p.Config.TimelapseDir})
+		}
+		if strings.HasPrefix(p.Config.StreamURL, "/") {
+			mounts = append(mounts, MediaMount{
+				Prefix: path.Dir(p.Config.StreamURL),
+				Dir:    filepath.Dir(p.Config.StreamPath),
+				Live:   true,
+			})
+		}
+	}
+	return mounts
+}
+
+// Roots returns the first path segment of every mount, such as "/videos",
+// for registering catch-all routes.
+func (s *MediaServer) Roots() []string {
+	var roots []string
+	for _, m := range s.mounts {
+		root := "/" + strings.SplitN(m.Prefix[1:], "/", 2)[0]
+		if !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// Serve handles GET and HEAD for any path under a mount. Range,
+// If-Range, If-None-Match and If-Modified-Since are handled by
+// http.ServeContent.
+func (s *MediaServer) Serve(c *gin.Context) {
+	mount, name, ok := s.match(c.Request.URL.Path)
+	if !ok || !servableName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	f, err := os.OpenInRoot(mount.Dir, filepath.FromSlash(name))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	header := c.Writer.Header()
+	if ct, ok := mediaTypes[strings.ToLower(path.Ext(name))]; ok {
+		header.Set("Content-Type", ct)
+	}
+	header.Set("X-Content-Type-Options", "nosniff")
+	if mount.Live {
+		header.Set("Cache-Control", liveCacheControl)
+		header.Set("Pragma", "no-cache")
+		header.Set("Expires", "0")
+	} else {
+		header.Set("Cache-Control", videosCacheControl)
+		// Same shape as nginx's ETag: hex mtime and size
+		header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size()))
+	}
+
+	clearWriteDeadline(c, "media")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+}
+
+// match finds the mount serving urlPath and the slash path within it.
+func (s *MediaServer) match(urlPath string) (MediaMount, string, bool) {
+	for _, m := range s.mounts {
+		rest, ok := strings.CutPrefix(urlPath, m.Prefix)
+		if !ok || !strings.HasPrefix(rest, "/") {
+			continue
+		}
+		return m, rest[1:], true
+	}
+	return MediaMount{}, "", false
+}
+
+// servableName rejects anything but a clean relative path of visible names.
+func servableName(name string) bool {
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, `\`) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
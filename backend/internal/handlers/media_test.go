@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupMediaServer(t *testing.T) (*gin.Engine, string, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	videos := t.TempDir()
+	live := t.TempDir()
+	writeFiles(t, videos, map[string][]byte{
+		"video.mp4":           []byte("0123456789"),
+		"2024/video.mkv":      []byte("mkv"),
+		"thumbnail/video.jpg": []byte("jpg"),
+		".metadata.db":        []byte("secret"),
+		".trash/old.mp4":      []byte("trashed"),
+	})
+	writeFiles(t, live, map[string][]byte{
+		"stream.m3u8": []byte("#EXTM3U\n"),
+		"segment1.ts": []byte("ts"),
+	})
+	writeFiles(t, filepath.Dir(videos), map[string][]byte{"outside.mp4": []byte("outside")})
+
+	media, err := NewMediaServer([]MediaMount{
+		{Prefix: "/videos", Dir: videos},
+		{Prefix: "/live/", Dir: live, Live: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/videos/*filepath", media.Serve)
+	router.HEAD("/videos/*filepath", media.Serve)
+	router.GET("/live/*filepath", media.Serve)
+	return router, videos, live
+}
+
+func serveMedia(router *gin.Engine, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMediaServer_TypesAndCaching(t *testing.T) {
+	router, _, _ := setupMediaServer(t)
+
+	tests := []struct {
+		target, contentType, cacheControl string
+	}{
+		{"/videos/video.mp4", "video/mp4", videosCacheControl},
+		{"/videos/2024/video.mkv", "video/x-matroska", videosCacheControl},
+		{"/videos/thumbnail/video.jpg", "image/jpeg", videosCacheControl},
+		{"/live/stream.m3u8", "application/vnd.apple.mpegurl", liveCacheControl},
+		{"/live/segment1.ts", "video/mp2t", liveCacheControl},
+	}
+	for _, tt := range tests {
+		w := serveMedia(router, http.MethodGet, tt.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.target, got, tt.contentType)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.target, got, tt.cacheControl)
+		}
+	}
+
+	// Live files carry no validators, so players always refetch
+	if w := serveMedia(router, http.MethodGet, "/live/stream.m3u8", nil); w.Header().Get("ETag") != "" {
+		t.Error("expected no ETag on the live stream")
+	}
+}
+
+func TestMediaServer_RangeAndConditional(t *testing.T) {
+	router, _, _ := setupMediaServer(t)
+
+	w := serveMedia(router, http.MethodGet, "/videos/video.mp4", map[string]string{"Range": "bytes=2-5"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Fatalf("expected 206 with bytes 2-5, got %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("unexpected Content-Range %q", got)
+	}
+
+	w = serveMedia(router, http.MethodGet, "/videos/video.mp4", map[string]string{"Range": "bytes=20-"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416, got %d", w.Code)
+	}
+
+	w = serveMedia(router, http.MethodHead, "/videos/video.mp4", nil)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || lastModified == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected validators on HEAD, got %d %v", w.Code, w.Header())
+	}
+
+	if w = serveMedia(router, http.MethodGet, "/videos/video.mp4", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", w.Code)
+	}
+	if w = serveMedia(router, http.MethodGet, "/videos/video.mp4", map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for If-Modified-Since, got %d", w.Code)
+	}
+	// A stale If-Range falls back to the whole file
+	w = serveMedia(router, http.MethodGet, "/videos/video.mp4", map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`})
+	if w.Code != http.StatusOK || w.Body.Len() != 10 {
+		t.Errorf("expected full body for stale If-Range, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestMediaServer_Containment(t *testing.T) {
+	router, videos, _ := setupMediaServer(t)
+
+	if err := os.Symlink(filepath.Join(filepath.Dir(videos), "outside.mp4"), filepath.Join(videos, "link.mp4")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{
+		"/videos/../outside.mp4",
+		"/videos/%2e%2e/outside.mp4",
+		"/videos/2024/../../outside.mp4",
+		"/videos/link.mp4",
+		"/videos/.metadata.db",
+		"/videos/.trash/old.mp4",
+		"/videos/2024",
+		"/videos/",
+		"/videos/missing.mp4",
+		"/live/../video.mp4",
+	} {
+		if w := serveMedia(router, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestNewMediaServer_NestedPrefixes(t *testing.T) {
+	outer, inner := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(inner, "a.mp4"), []byte("inner"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	media, err := NewMediaServer([]MediaMount{{Prefix: "/videos", Dir: outer}, {Prefix: "/videos/p2", Dir: inner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roots := media.Roots(); len(roots) != 1 || roots[0] != "/videos" {
+		t.Errorf("expected a single /videos root, got %v", roots)
+	}
+	if m, name, ok := media.match("/videos/p2/a.mp4"); !ok || m.Dir != inner || name != "a.mp4" {
+		t.Errorf("expected the longer prefix to win, got %+v %q", m, name)
+	}
+
+	for _, mounts := range [][]MediaMount{
+		{{Prefix: "videos", Dir: outer}},
+		{{Prefix: "/", Dir: outer}},
+		{{Prefix: "/videos/../live", Dir: outer}},
+		{{Prefix: "/videos", Dir: outer}, {Prefix: "/videos/", Dir: inner}},
+	} {
+		if _, err := NewMediaServer(mounts); err == nil {
+			t.Errorf("expected %+v to be rejected", mounts)
+		}
+	}
+}
+
+// slowDownload fetches target from a server with a short write timeout,
+// pausing before reading so the response outlasts it, and returns how many
+// bytes arrived.
+func slowDownload(t *testing.T, handler http.Handler, target string) int64 {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for %s, got %d", target, resp.StatusCode)
+	}
+	time.Sleep(300 * time.Millisecond)
+	n, _ := io.Copy(io.Discard, resp.Body)
+	return n
+}
+
+// largeFile is big enough not to fit in the socket buffers.
+var largeFile = make([]byte, 32<<20)
+
+func TestMediaServer_SlowDownload(t *testing.T) {
+	router, videos, _ := setupMediaServer(t)
+	if err := os.WriteFile(filepath.Join(videos, "large.mp4"), largeFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n := slowDownload(t, router, "/videos/large.mp4"); n != int64(len(largeFile)) {
+		t.Errorf("expected %d bytes past the write timeout, got %d", len(largeFile), n)
+	}
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	metadataDBName = ".metadata.db"
+
+	maxTags      = 32
+	maxTagLength = 64
+	maxNotesLen  = 10000
+)
+
+var metadataBucket = []byte("timelapses")
+
+// MetadataStore keeps user-entered tags, notes, stars and ratings in a
+// bbolt database inside the timelapse directory, keyed by filename. The
+// database is only created once something is written, so reads against a
+// directory without one simply find nothing.
+type MetadataStore struct {
+	path string
+
+	mu sync.Mutex
+	db *bolt.DB
+
+	// changes counts writes so derived indexes know to rebuild
+	changes changeCounter
+}
+
+func NewMetadataStore(dir string) *MetadataStore {
+	return &MetadataStore{path: filepath.Join(dir, metadataDBName)}
+}
+
+// open returns the database, creating the file only when create is set.
+// It returns nil without an error if the file does not exist yet.
+func (s *MetadataStore) open(create bool) (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return s.db, nil
+	}
+	if !create {
+		if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+
+	db, err := bolt.Open(s.path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metadataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.db = db
+	return db, nil
+}
+
+func (s *MetadataStore) view(fn func(b *bolt.Bucket) error) error {
+	db, err := s.open(false)
+	if err != nil || db == nil {
+		return err
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(metadataBucket))
+	})
+}
+
+func (s *MetadataStore) update(fn func(b *bolt.Bucket) error) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(metadataBucket))
+	})
+}
+
+// Get returns the metadata for one timelapse and whether any was stored.
+func (s *MetadataStore) Get(name string) (models.TimelapseMeta, bool, error) {
+	var meta models.TimelapseMeta
+	var found bool
+	err := s.view(func(b *bolt.Bucket) error {
+		data := b.Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &meta)
+	})
+	return meta, found, err
+}
+
+// All returns every stored entry keyed by filename.
+func (s *MetadataStore) All() (map[string]models.TimelapseMeta, error) {
+	all := make(map[string]models.TimelapseMeta)
+	err := s.view(func(b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			var meta models.TimelapseMeta
+			if err := json.Unmarshal(v, &meta); err != nil {
+				log.Printf("metadata: skipping corrupt entry for %s: %v", k, err)
+				return nil
+			}
+			all[string(k)] = meta
+			return nil
+		})
+	})
+	return all, err
+}
+
+// Update applies fn to the stored metadata for name, or to an empty entry,
+// and saves the result. Nothing is saved if fn returns an error.
+func (s *MetadataStore) Update(name string, fn func(*models.TimelapseMeta) error) (models.TimelapseMeta, error) {
+	var meta models.TimelapseMeta
+	err := s.update(func(b *bolt.Bucket) error {
+		if data := b.Get([]byte(name)); data != nil {
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return err
+			}
+		}
+		if err := fn(&meta); err != nil {
+			return err
+		}
+		meta.UpdatedAt = time.Now().UTC()
+
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(name), data)
+	})
+	if err == nil {
+		s.changes.bump()
+	}
+	return meta, err
+}
+
+func (s *MetadataStore) Delete(name string) error {
+	db, err := s.open(false)
+	if err != nil || db == nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).Delete([]byte(name))
+	})
+	if err == nil {
+		s.changes.bump()
+	}
+	return err
+}
+
+// Version changes whenever stored metadata changes.
+func (s *MetadataStore) Version() uint64 {
+	n, _ := s.changes.get()
+	return n
+}
+
+// Starred reports whether a timelapse is starred. Lookup failures count as
+// starred so the retention worker errs on the side of keeping files.
+func (s *MetadataStore) Starred(name string) bool {
+	meta, _, err := s.Get(name)
+	if err != nil {
+		log.Printf("metadata: failed to read %s: %v", name, err)
+		return true
+	}
+	return meta.Starred
+}
+
+func (s *MetadataStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// metaPatch is the body of PATCH /timelapses/:filename/meta. Omitted fields
+// are left unchanged.
+type metaPatch struct {
+	Tags    *[]string `json:"tags"`
+	Notes   *string   `json:"notes"`
+	Starred *bool     `json:"starred"`
+	Rating  *int      `json:"rating"`
+}
+
+func (p metaPatch) apply(meta *models.TimelapseMeta) error {
+	if p.Tags != nil {
+		tags, err := normalizeTags(*p.Tags)
+		if err != nil {
+			return err
+		}
+		meta.Tags = tags
+	}
+	if p.Notes != nil {
+		if utf8.RuneCountInString(*p.Notes) > maxNotesLen {
+			return fmt.Errorf("notes must be at most %d characters", maxNotesLen)
+		}
+		meta.Notes = *p.Notes
+	}
+	if p.Starred != nil {
+		meta.Starred = *p.Starred
+	}
+	if p.Rating != nil {
+		if *p.Rating < 0 || *p.Rating > 5 {
+			return errors.New("rating must be 1-5, or 0 to clear it")
+		}
+		meta.Rating = *p.Rating
+	}
+	return nil
+}
+
+// normalizeTags trims and de-duplicates tags, case-insensitively keeping
+// the first spelling.
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		if slices.ContainsFunc(out, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			continue
+		}
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return out, nil
+}
+
+// Attach sets the stored metadata on each item in place.
+func (s *MetadataStore) Attach(items []models.Timelapse) error {
+	all, err := s.All()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if meta, ok := all[items[i].Filename]; ok {
+			items[i].TimelapseMeta = &meta
+		}
+	}
+	return nil
+}
+
+// PutMetadata replaces all metadata for a timelapse.
+func (h *TimelapseHandler) PutMetadata(c *gin.Context) {
+	var body models.TimelapseMeta
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	tags := body.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	h.updateMetadata(c, metaPatch{Tags: &tags, Notes: &body.Notes, Starred: &body.Starred, Rating: &body.Rating})
+}
+
+// PatchMetadata changes only the metadata fields present in the body.
+func (h *TimelapseHandler) PatchMetadata(c *gin.Context) {
+	var patch metaPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	h.updateMetadata(c, patch)
+}
+
+func (h *TimelapseHandler) updateMetadata(c *gin.Context, patch metaPatch) {
+	// Offloaded videos are in the catalog but not on disk
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	name := item.Filename
+
+	// Validate before touching the store so bad input never creates it
+	if err := patch.apply(&models.TimelapseMeta{}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	meta, err := h.metadata.Update(name, patch.apply)
+	if err != nil {
+		log.Printf("metadata: failed to update %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save metadata"})
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
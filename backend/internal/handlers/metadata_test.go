@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func callMetadata(h gin.HandlerFunc, method, filename, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "filename", Value: filename}}
+	h(c)
+	return w
+}
+
+func TestMetadata_PutAndPatch(t *testing.T) {
+	tmpDir := setupQueryDir(t)
+	h := NewTimelapseHandler(tmpDir)
+	t.Cleanup(func() { h.Close() })
+	name := "video_2024-07-01_10-00-00.mp4"
+
+	// Reading never creates the database
+	if code, _ := listPage(t, h, "/api/timelapses"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, metadataDBName)); !os.IsNotExist(err) {
+		t.Fatal("expected no metadata database before the first write")
+	}
+
+	w := callMetadata(h.PutMetadata, http.MethodPut, name, `{"tags": ["PLA", " failed ", "pla", ""], "notes": "spaghetti at layer 40", "rating": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var meta models.TimelapseMeta
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(meta.Tags) != 2 || meta.Tags[0] != "PLA" || meta.Tags[1] != "failed" {
+		t.Errorf("expected normalized tags, got %v", meta.Tags)
+	}
+	if meta.Notes != "spaghetti at layer 40" || meta.Rating != 2 || meta.Starred || meta.UpdatedAt.IsZero() {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+
+	// PATCH leaves omitted fields alone
+	w = callMetadata(h.PatchMetadata, http.MethodPatch, name, `{"starred": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if !meta.Starred || meta.Notes != "spaghetti at layer 40" || len(meta.Tags) != 2 {
+		t.Errorf("unexpected metadata after patch: %+v", meta)
+	}
+
+	// PUT replaces everything
+	w = callMetadata(h.PutMetadata, http.MethodPut, name, `{"rating": 5}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if meta.Starred || meta.Notes != "" || len(meta.Tags) != 0 || meta.Rating != 5 {
+		t.Errorf("unexpected metadata after put: %+v", meta)
+	}
+
+	// Metadata survives reopening the store
+	h.Close()
+	stored, found, err := NewMetadataStore(tmpDir).Get(name)
+	if err != nil || !found || stored.Rating != 5 {
+		t.Errorf("expected stored rating 5, got %+v, %v, %v", stored, found, err)
+	}
+}
+
+func TestMetadata_InvalidRequests(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+	t.Cleanup(func() { h.Close() })
+	name := "video_2024-07-01_10-00-00.mp4"
+
+	tests := []struct {
+		name     string
+		filename string
+		body     string
+		want     int
+	}{
+		{"rating too high", name, `{"rating": 6}`, http.StatusBadRequest},
+		{"negative rating", name, `{"rating": -1}`, http.StatusBadRequest},
+		{"tag too long", name, `{"tags": ["` + strings.Repeat("x", maxTagLength+1) + `"]}`, http.StatusBadRequest},
+		{"notes too long", name, `{"notes": "` + strings.Repeat("x", maxNotesLen+1) + `"}`, http.StatusBadRequest},
+		// Limits count characters, not bytes
+		{"multibyte tag", name, `{"tags": ["` + strings.Repeat("é", maxTagLength) + `"]}`, http.StatusOK},
+		{"multibyte notes", name, `{"notes": "` + strings.Repeat("é", maxNotesLen) + `"}`, http.StatusOK},
+		{"malformed body", name, `{"tags": "pla"}`, http.StatusBadRequest},
+		{"traversal", "../video.mp4", `{"starred": true}`, http.StatusBadRequest},
+		{"missing video", "missing.mp4", `{"starred": true}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := callMetadata(h.PatchMetadata, http.MethodPatch, tt.filename, tt.body); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestListTimelapses_MetadataFilters(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+	t.Cleanup(func() { h.Close() })
+
+	for name, body := range map[string]string{
+		"video_2024-07-01_10-00-00.mp4": `{"tags": ["PLA", "failed"], "rating": 1}`,
+		"video_2024-07-02_10-00-00.mp4": `{"tags": ["pla"], "starred": true, "rating": 4}`,
+		"video_2024-07-03_10-00-00.mkv": `{"tags": ["petg"], "starred": true, "rating": 5}`,
+	} {
+		if w := callMetadata(h.PutMetadata, http.MethodPut, name, body); w.Code != http.StatusOK {
+			t.Fatalf("failed to save metadata for %s: %d", name, w.Code)
+		}
+	}
+
+	code, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	first := page.Items[0]
+	if first.TimelapseMeta == nil || first.Rating != 1 || len(first.Tags) != 2 {
+		t.Errorf("expected metadata merged into listing, got %+v", first)
+	}
+	if page.Items[3].TimelapseMeta != nil {
+		t.Errorf("expected no metadata for untouched video, got %+v", page.Items[3].TimelapseMeta)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"tag=pla", []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4"}},
+		{"tag=pla,failed", []string{"video_2024-07-01_10-00-00.mp4"}},
+		{"tag=pla&tag=failed", []string{"video_2024-07-01_10-00-00.mp4"}},
+		{"starred=true", []string{"video_2024-07-02_10-00-00.mp4", "video_2024-07-03_10-00-00.mkv"}},
+		{"starred=false", []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-04_10-00-00.mp4", "video_2024-07-05_10-00-00.avi"}},
+		{"min_rating=4", []string{"video_2024-07-02_10-00-00.mp4", "video_2024-07-03_10-00-00.mkv"}},
+		{"tag=pla&min_rating=4", []string{"video_2024-07-02_10-00-00.mp4"}},
+	}
+	for _, tt := range tests {
+		code, page := listPage(t, h, "/api/timelapses?sort=oldest&"+tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.query, code)
+			continue
+		}
+		got := filenames(page.Items)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"starred=maybe", "min_rating=0", "min_rating=6"} {
+		if code, _ := listPage(t, h, "/api/timelapses?"+query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestMetadata_StarsProtectFromRetention(t *testing.T) {
+	h := NewTimelapseHandler(setupRetentionDir(t), WithRetentionPolicy(models.RetentionPolicy{MaxAgeDays: 3, ProtectStarred: true}))
+	t.Cleanup(func() { h.Close() })
+
+	if w := callMetadata(h.PatchMetadata, http.MethodPatch, "video_2024-07-01_10-00-00.mp4", `{"starred": true}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	plan, err := h.retention.Plan(time.Date(2024, 7, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := candidateNames(plan); len(got) != 1 || got[0] != "video_2024-07-02_10-00-00.mp4" {
+		t.Errorf("expected starred video to be protected, got %v", got)
+	}
+}
+
+func TestMetadata_PurgeRemovesMetadata(t *testing.T) {
+	h := NewTimelapseHandler(setupTrashDir(t))
+	t.Cleanup(func() { h.Close() })
+	name := "video_2024-07-24_09-14-01.mp4"
+
+	if w := callMetadata(h.PatchMetadata, http.MethodPatch, name, `{"notes": "keep"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	// Trashing keeps the metadata so a restore brings it back
+	if _, err := h.trash.Move(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := h.metadata.Get(name); !found {
+		t.Fatal("expected metadata to survive the trash")
+	}
+
+	if err := h.trash.Purge(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := h.metadata.Get(name); found {
+		t.Error("expected purge to remove metadata")
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/tlspin"
+)
+
+// SyncPins lists the pinned printer certificates, including changes
+// waiting for approval.
+func (h *TimelapseHandler) SyncPins(c *gin.Context) {
+	pins, err := h.syncer.pins.List()
+	if err != nil {
+		log.Printf("pins: failed to list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read pins"})
+		return
+	}
+	c.JSON(http.StatusOK, pins)
+}
+
+// ApprovePin trusts a printer's changed certificate. The request names the
+// new fingerprint so only the key that was checked is approved.
+func (h *TimelapseHandler) ApprovePin(c *gin.Context) {
+	var req struct {
+		Addr        string `json:"addr" binding:"required"`
+		Fingerprint string `json:"fingerprint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "addr and fingerprint are required"})
+		return
+	}
+
+	pin, err := h.syncer.pins.Approve(req.Addr, req.Fingerprint)
+	switch {
+	case errors.Is(err, tlspin.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no pin for that address"})
+	case errors.Is(err, tlspin.ErrNoPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "fingerprint does not match a pending change"})
+	case err != nil:
+		log.Printf("pins: failed to approve %s: %v", req.Addr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve pin"})
+	default:
+		log.Printf("pins: approved %s for %s", pin.Fingerprint, pin.Addr)
+		c.JSON(http.StatusOK, pin)
+	}
+}
+
+// ResetPin forgets a printer's pin, so the next certificate it presents is
+// trusted.
+func (h *TimelapseHandler) ResetPin(c *gin.Context) {
+	addr := c.Query("addr")
+	if addr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "addr is required"})
+		return
+	}
+
+	err := h.syncer.pins.Reset(addr)
+	switch {
+	case errors.Is(err, tlspin.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no pin for that address"})
+	case err != nil:
+		log.Printf("pins: failed to reset %s: %v", addr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset pin"})
+	default:
+		log.Printf("pins: reset %s", addr)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	previewDirName = ".previews"
+
+	// DefaultPreviewWorkers is how many sprite sheets are generated at once.
+	DefaultPreviewWorkers = 2
+
+	previewTimeout   = 10 * time.Minute
+	previewQueueSize = 256
+
+	// A frame per second of video, up to maxPreviewFrames, in rows of
+	// previewColumns tiles previewTileWidth pixels wide.
+	maxPreviewFrames = 100
+	previewColumns   = 10
+	previewTileWidth = 160
+)
+
+// Previews generates scrubbing previews: a JPEG sprite sheet of evenly
+// spaced frames and a WebVTT track mapping time ranges to tiles in it.
+// Both are cached under .previews in the timelapse directory with the
+// source's mtime, and regenerated when the source changes.
+type Previews struct {
+	dir     string
+	catalog *Catalog
+	ffmpeg  ffmpeg.Runner
+	apiURL  string
+	workers int
+
+	queue chan models.Timelapse
+
+	mu      sync.Mutex
+	pending map[string]bool
+	// failed holds the source mtime of videos ffmpeg could not read, so
+	// they are only retried once they change.
+	failed map[string]time.Time
+
+	ready derivedCache
+	// changes counts previews finished or removed, which listings show
+	changes changeCounter
+}
+
+func NewPreviews(dir string, catalog *Catalog) *Previews {
+	return &Previews{
+		dir:     dir,
+		catalog: catalog,
+		apiURL:  DefaultAPIURL,
+		workers: DefaultPreviewWorkers,
+		queue:   make(chan models.Timelapse, previewQueueSize),
+		pending: make(map[string]bool),
+		failed:  make(map[string]time.Time),
+	}
+}
+
+// previewLayout returns the sprite layout for item, or false if its
+// duration is unknown.
+func previewLayout(item models.Timelapse) (ffmpeg.SpriteLayout, bool) {
+	if item.Duration <= 0 {
+		return ffmpeg.SpriteLayout{}, false
+	}
+	frames := min(max(int(math.Ceil(item.Duration)), 1), maxPreviewFrames)
+	height := previewTileWidth * 9 / 16
+	if item.Width > 0 && item.Height > 0 {
+		height = int(math.Round(float64(previewTileWidth*item.Height) / float64(item.Width)))
+	}
+	// Most encoders want even dimensions
+	height = max(height+height%2, 2)
+	return ffmpeg.SpriteLayout{
+		Duration:   time.Duration(item.Duration * float64(time.Second)),
+		Frames:     frames,
+		Columns:    min(frames, previewColumns),
+		TileWidth:  previewTileWidth,
+		TileHeight: height,
+	}, true
+}
+
+func (p *Previews) sprite(name string) string {
+	return filepath.Join(p.dir, previewDirName, filepath.FromSlash(name)+".jpg")
+}
+
+func (p *Previews) vtt(name string) string {
+	return filepath.Join(p.dir, previewDirName, filepath.FromSlash(name)+".vtt")
+}
+
+func (p *Previews) url(name, file string) string {
+	return p.apiURL + "/timelapses/" + url.PathEscape(name) + "/" + file
+}
+
+// sourceModTime returns the mtime of the video itself.
+func (p *Previews) sourceModTime(name string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(p.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// fresh reports whether both preview files exist and were generated from
+// the current version of the source.
+func (p *Previews) fresh(name string) bool {
+	src, err := p.sourceModTime(name)
+	if err != nil {
+		return false
+	}
+	for _, file := range []string{p.sprite(name), p.vtt(name)} {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(src) {
+			return false
+		}
+	}
+	return true
+}
+
+// Attach sets SpriteURL and VTTURL on each item whose previews are ready,
+// and queues the rest.
+func (p *Previews) Attach(items []models.Timelapse) {
+	for i := range items {
+		p.attach(&items[i])
+	}
+}
+
+func (p *Previews) attach(item *models.Timelapse) {
+	// Previews are generated from local videos only
+	if !p.ffmpeg.Enabled() || item.Remote {
+		return
+	}
+	if _, ok := previewLayout(*item); !ok {
+		return
+	}
+	if !p.ready.check(*item, p.fresh) {
+		p.enqueue(*item)
+		return
+	}
+	item.SpriteURL = p.url(item.Filename, "sprite.jpg")
+	item.VTTURL = p.url(item.Filename, "thumbnails.vtt")
+}
+
+// enqueue queues item unless it is already queued or failed on this
+// version of the file. A full queue drops the item; the next sweep picks
+// it up again.
+func (p *Previews) enqueue(item models.Timelapse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[item.Filename] {
+		return
+	}
+	if failed, ok := p.failed[item.Filename]; ok && failed.Equal(item.ModTime) {
+		return
+	}
+	select {
+	case p.queue <- item:
+		p.pending[item.Filename] = true
+	default:
+	}
+}
+
+// Run starts the workers and queues every video without fresh previews
+// at start and then every interval, until ctx is cancelled.
+func (p *Previews) Run(ctx context.Context, interval time.Duration) {
+	if !p.ffmpeg.Enabled() {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for range p.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Previews) sweep() {
+	snapshot, err := p.catalog.Snapshot()
+	if err != nil {
+		log.Printf("previews: failed to read catalog: %v", err)
+		return
+	}
+	p.Attach(snapshot.Items)
+}
+
+func (p *Previews) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-p.queue:
+			err := p.generate(ctx, item)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("previews: failed to generate previews of %s: %v", item.Filename, err)
+			}
+			p.done(item.Filename, err)
+		}
+	}
+}
+
+func (p *Previews) done(name string, err error) {
+	p.ready.forget(name)
+	p.changes.bump()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, name)
+	delete(p.failed, name)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		if src, statErr := p.sourceModTime(name); statErr == nil {
+			p.failed[name] = src
+		}
+	}
+}
+
+// generate writes the sprite sheet and then the track, and stamps both
+// with the source's mtime once they are complete.
+func (p *Previews) generate(ctx context.Context, item models.Timelapse) error {
+	name := item.Filename
+	layout, ok := previewLayout(item)
+	if !ok || p.fresh(name) {
+		return nil
+	}
+	src, err := p.sourceModTime(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+	if err := p.ffmpeg.Sprite(ctx, filepath.Join(p.dir, filepath.FromSlash(name)), p.sprite(name), layout); err != nil {
+		return err
+	}
+
+	tmp := p.vtt(name) + ".part"
+	if err := os.WriteFile(tmp, []byte(thumbnailTrack(layout, "sprite.jpg")), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p.vtt(name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for _, file := range []string{p.sprite(name), p.vtt(name)} {
+		if err := os.Chtimes(file, src, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thumbnailTrack returns a WebVTT track with one cue per tile, pointing at
+// the tile's rectangle in the sprite sheet. The sprite URL is resolved
+// against the track's own URL.
+func thumbnailTrack(layout ffmpeg.SpriteLayout, sprite string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	step := layout.Duration / time.Duration(layout.Frames)
+	for i := range layout.Frames {
+		start := step * time.Duration(i)
+		end := step * time.Duration(i+1)
+		if i == layout.Frames-1 {
+			end = layout.Duration
+		}
+		x := (i % layout.Columns) * layout.TileWidth
+		y := (i / layout.Columns) * layout.TileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), sprite, x, y, layout.TileWidth, layout.TileHeight)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats d as hh:mm:ss.ttt.
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// Remove deletes the previews of name, if any.
+func (p *Previews) Remove(name string) error {
+	p.mu.Lock()
+	delete(p.failed, name)
+	p.mu.Unlock()
+	defer p.changes.bump()
+	defer p.ready.forget(name)
+
+	for _, file := range []string{p.sprite(name), p.vtt(name)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sprite serves the sprite sheet of a timelapse.
+func (h *TimelapseHandler) Sprite(c *gin.Context) {
+	h.servePreview(c, h.previews.sprite, "image/jpeg")
+}
+
+// ThumbnailTrack serves the WebVTT thumbnails track of a timelapse.
+func (h *TimelapseHandler) ThumbnailTrack(c *gin.Context) {
+	h.servePreview(c, h.previews.vtt, "text/vtt; charset=utf-8")
+}
+
+func (h *TimelapseHandler) servePreview(c *gin.Context, file func(string) string, contentType string) {
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if !h.previews.fresh(item.Filename) {
+		// The catalog may not have seen the change yet
+		h.previews.ready.forget(item.Filename)
+		h.previews.attach(&item)
+		c.JSON(http.StatusNotFound, gin.H{"error": "no preview yet"})
+		return
+	}
+
+	f, err := os.Open(file(item.Filename))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no preview yet"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read preview"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "no-cache")
+	clearWriteDeadline(c, "previews")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+}
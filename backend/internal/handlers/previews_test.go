@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg"
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg/ffmpegtest"
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestPreviewLayout(t *testing.T) {
+	tests := []struct {
+		name string
+		item models.Timelapse
+		want ffmpeg.SpriteLayout
+	}{
+		{
+			"frame per second",
+			models.Timelapse{Duration: 29.5, Width: 1920, Height: 1080},
+			ffmpeg.SpriteLayout{Duration: 29500 * time.Millisecond, Frames: 30, Columns: 10, TileWidth: 160, TileHeight: 90},
+		},
+		{
+			"capped",
+			models.Timelapse{Duration: 3600, Width: 1280, Height: 960},
+			ffmpeg.SpriteLayout{Duration: time.Hour, Frames: 100, Columns: 10, TileWidth: 160, TileHeight: 120},
+		},
+		{
+			"short with unknown size",
+			models.Timelapse{Duration: 3},
+			ffmpeg.SpriteLayout{Duration: 3 * time.Second, Frames: 3, Columns: 3, TileWidth: 160, TileHeight: 90},
+		},
+		{
+			"odd height rounded up",
+			models.Timelapse{Duration: 10, Width: 1000, Height: 1000 * 89 / 160},
+			ffmpeg.SpriteLayout{Duration: 10 * time.Second, Frames: 10, Columns: 10, TileWidth: 160, TileHeight: 90},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := previewLayout(tt.item)
+		if !ok || got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.name, tt.want, got, ok)
+		}
+	}
+
+	if _, ok := previewLayout(models.Timelapse{}); ok {
+		t.Error("expected no layout without a duration")
+	}
+}
+
+func TestThumbnailTrack(t *testing.T) {
+	layout := ffmpeg.SpriteLayout{Duration: 2500 * time.Millisecond, Frames: 3, Columns: 2, TileWidth: 160, TileHeight: 90}
+	want := `WEBVTT
+
+00:00:00.000 --> 00:00:00.833
+sprite.jpg#xywh=0,0,160,90
+
+00:00:00.833 --> 00:00:01.666
+sprite.jpg#xywh=160,0,160,90
+
+00:00:01.666 --> 00:00:02.500
+sprite.jpg#xywh=0,90,160,90
+`
+	if got := thumbnailTrack(layout, "sprite.jpg"); got != want {
+		t.Errorf("unexpected track:\n%s", got)
+	}
+
+	if got := vttTimestamp(time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond); got != "01:02:03.045" {
+		t.Errorf("unexpected timestamp %q", got)
+	}
+}
+
+func waitPreviews(t *testing.T, h *TimelapseHandler, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !h.previews.fresh(name) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for previews of %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPreviews_Lifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	name := "video_2024-07-24_09-14-01.mp4"
+	video := mediatest.MP4(mediatest.Video{Duration: 20 * time.Second, Width: 1920, Height: 1080})
+	if err := os.WriteFile(filepath.Join(tmpDir, name), video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Previews().Run(ctx, time.Hour)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		h.Close()
+	})
+
+	waitPreviews(t, h, name)
+
+	_, page := listPage(t, h, "/api/timelapses")
+	if len(page.Items) != 1 {
+		t.Fatalf("expected 1 timelapse, got %d", len(page.Items))
+	}
+	item := page.Items[0]
+	if item.SpriteURL != "/api/timelapses/"+name+"/sprite.jpg" || item.VTTURL != "/api/timelapses/"+name+"/thumbnails.vtt" {
+		t.Errorf("unexpected preview URLs %q, %q", item.SpriteURL, item.VTTURL)
+	}
+
+	w := callWithFilename(h.ThumbnailTrack, http.MethodGet, name)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/vtt") {
+		t.Fatalf("expected the track, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "WEBVTT\n") || strings.Count(body, " --> ") != 20 {
+		t.Errorf("expected a cue per second, got:\n%s", body)
+	}
+
+	w = callWithFilename(h.Sprite, http.MethodGet, name)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" || w.Body.Len() != len(video) {
+		t.Errorf("expected the sprite, got %d %q with %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+
+	// A changed source makes the previews stale until they are regenerated
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(tmpDir, name), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if h.previews.fresh(name) {
+		t.Error("expected previews to be stale after the source changed")
+	}
+	if w := callWithFilename(h.Sprite, http.MethodGet, name); w.Code != http.StatusNotFound && w.Code != http.StatusOK {
+		t.Errorf("expected 404 or a regenerated sprite, got %d", w.Code)
+	}
+	waitPreviews(t, h, name)
+
+	if _, err := h.trash.Move(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.trash.Purge(name); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{h.previews.sprite(name), h.previews.vtt(name)} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("expected purge to remove %s, got %v", file, err)
+		}
+	}
+}
+
+func TestPreviews_NotConfigured(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+	t.Cleanup(func() { h.Close() })
+
+	_, page := listPage(t, h, "/api/timelapses")
+	for _, item := range page.Items {
+		if item.SpriteURL != "" || item.VTTURL != "" {
+			t.Errorf("expected no previews without ffmpeg, got %+v", item)
+		}
+	}
+	if w := callWithFilename(h.Sprite, http.MethodGet, "video_2024-07-01_10-00-00.mp4"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	if w := callWithFilename(h.ThumbnailTrack, http.MethodGet, "../video.mp4"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestPreviews_FailureNotRetried(t *testing.T) {
+	tmpDir := t.TempDir()
+	name := "video_2024-07-24_09-14-01.mp4"
+	if err := os.WriteFile(filepath.Join(tmpDir, name), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Fail(t, "Invalid data found when processing input")))
+	t.Cleanup(func() { h.Close() })
+
+	item, _, err := h.catalog.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.previews.enqueue(item)
+	queued := <-h.previews.queue
+	err = h.previews.generate(context.Background(), queued)
+	if err == nil {
+		t.Fatal("expected generation to fail")
+	}
+	h.previews.done(name, err)
+
+	h.previews.enqueue(item)
+	if len(h.previews.queue) != 0 {
+		t.Error("expected a failed video not to be queued again until it changes")
+	}
+}
+
+func TestPreviews_SlowDownload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := t.TempDir()
+	name := "video_2024-07-24_09-14-01.mp4"
+	if err := os.WriteFile(filepath.Join(tmpDir, name), mediatest.MP4(mediatest.Video{Duration: 20 * time.Second}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	t.Cleanup(func() { h.Close() })
+	if err := os.MkdirAll(filepath.Dir(h.previews.sprite(name)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A sprite sheet too big for the socket buffers
+	mtime := time.Now().Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(tmpDir, name), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string][]byte{h.previews.sprite(name): largeFile, h.previews.vtt(name): []byte("WEBVTT\n")} {
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/timelapses/:filename/sprite.jpg", h.Sprite)
+	if n := slowDownload(t, router, "/timelapses/"+name+"/sprite.jpg"); n != int64(len(largeFile)) {
+		t.Errorf("expected %d bytes past the write timeout, got %d", len(largeFile), n)
+	}
+}
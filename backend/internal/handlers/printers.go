@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+var printerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// PrinterConfig describes one printer in the printers config file. Name
+// defaults to the ID, and the URLs default to per-printer paths under
+// /videos and /live that the reverse proxy is expected to serve.
+type PrinterConfig struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	TimelapseDir string `json:"timelapseDir"`
+	StreamPath   string `json:"streamPath"`
+	VideosURL    string `json:"videosUrl"`
+	StreamURL    string `json:"streamUrl"`
+	// Sync, when set, mirrors timelapses from the printer over FTPS.
+	Sync *SyncConfig `json:"sync,omitempty"`
+}
+
+// LoadPrinterConfigs reads a JSON array of printer configs.
+func LoadPrinterConfigs(path string) ([]PrinterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []PrinterConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("invalid printers config %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// Printer is a configured printer with the handlers serving it.
+type Printer struct {
+	Config     PrinterConfig
+	Timelapses *TimelapseHandler
+	Stream     *StreamHandler
+}
+
+// PrinterRegistry holds the configured printers in config order. The
+// first one is the default served by the unscoped /api routes.
+type PrinterRegistry struct {
+	printers []*Printer
+	byID     map[string]*Printer
+}
+
+// NewPrinterRegistry validates the configs and builds handlers for each
+// printer. The options apply to every printer's TimelapseHandler.
+func NewPrinterRegistry(configs []PrinterConfig, opts ...TimelapseOption) (*PrinterRegistry, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no printers configured")
+	}
+
+	r := &PrinterRegistry{byID: make(map[string]*Printer)}
+	for _, cfg := range configs {
+		if !printerIDPattern.MatchString(cfg.ID) {
+			return nil, fmt.Errorf("invalid printer id %q (use lowercase letters, digits, - and _)", cfg.ID)
+		}
+		if _, ok := r.byID[cfg.ID]; ok {
+			return nil, fmt.Errorf("duplicate printer id %q", cfg.ID)
+		}
+		if cfg.TimelapseDir == "" || cfg.StreamPath == "" {
+			return nil, fmt.Errorf("printer %q needs a timelapseDir and a streamPath", cfg.ID)
+		}
+		if cfg.Name == "" {
+			cfg.Name = cfg.ID
+		}
+		if cfg.VideosURL == "" {
+			cfg.VideosURL = DefaultVideosURL + "/" + cfg.ID
+		}
+		if cfg.StreamURL == "" {
+			cfg.StreamURL = "/live/" + cfg.ID + "/stream.m3u8"
+		}
+
+		perPrinter := []TimelapseOption{
+			WithVideosURL(cfg.VideosURL),
+			WithAPIURL(DefaultAPIURL + "/printers/" + cfg.ID),
+			withRemotePrefix(cfg.ID),
+		}
+		if cfg.Sync != nil {
+			perPrinter = append(perPrinter, WithSync(*cfg.Sync))
+		}
+		p := &Printer{
+			Config:     cfg,
+			Timelapses: NewTimelapseHandler(cfg.TimelapseDir, slices.Concat(opts, perPrinter)...),
+			Stream:     NewStreamHandler(cfg.StreamPath),
+		}
+		r.printers = append(r.printers, p)
+		r.byID[cfg.ID] = p
+	}
+	return r, nil
+}
+
+// Printers returns every printer in config order.
+func (r *PrinterRegistry) Printers() []*Printer {
+	return r.printers
+}
+
+// Default returns the first configured printer.
+func (r *PrinterRegistry) Default() *Printer {
+	return r.printers[0]
+}
+
+func (r *PrinterRegistry) Get(id string) (*Printer, bool) {
+	p, ok := r.byID[id]
+	return p, ok
+}
+
+// Status summarises a printer's stream and timelapses.
+func (p *Printer) Status() models.Printer {
+	status := models.Printer{
+		ID:        p.Config.ID,
+		Name:      p.Config.Name,
+		StreamURL: p.Config.StreamURL,
+		Stream:    p.Stream.Current(),
+	}
+
+	snapshot, err := p.Timelapses.Catalog().Snapshot()
+	if err != nil {
+		log.Printf("printers: failed to read timelapses for %s: %v", p.Config.ID, err)
+		return status
+	}
+	for _, t := range snapshot.Items {
+		status.TimelapseCount++
+		status.TimelapseBytes += t.Size
+		if t.Date.After(status.LatestTimelapse) {
+			status.LatestTimelapse = t.Date
+		}
+	}
+	return status
+}
+
+// List returns every printer with its status and totals across them.
+func (r *PrinterRegistry) List(c *gin.Context) {
+	list := models.PrinterList{Printers: make([]models.Printer, 0, len(r.printers))}
+	for _, p := range r.printers {
+		status := p.Status()
+		if status.Stream.Online {
+			list.Online++
+		}
+		list.TimelapseCount += status.TimelapseCount
+		list.TimelapseBytes += status.TimelapseBytes
+		list.Printers = append(list.Printers, status)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// Timelapses adapts a TimelapseHandler method, such as
+// (*TimelapseHandler).List, into a handler for routes under
+// /printers/:id that dispatches to the printer named in the path.
+func (r *PrinterRegistry) Timelapses(method func(*TimelapseHandler, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := r.lookup(c); ok {
+			method(p.Timelapses, c)
+		}
+	}
+}
+
+// Stream is the StreamHandler counterpart of Timelapses.
+func (r *PrinterRegistry) Stream(method func(*StreamHandler, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := r.lookup(c); ok {
+			method(p.Stream, c)
+		}
+	}
+}
+
+func (r *PrinterRegistry) lookup(c *gin.Context) (*Printer, bool) {
+	p, ok := r.byID[c.Param("id")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "printer not found"})
+	}
+	return p, ok
+}
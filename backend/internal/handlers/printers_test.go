@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestLoadPrinterConfigs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "printers.json")
+
+	config := `[
+		{"id": "x1c", "name": "X1 Carbon", "timelapseDir": "/videos/x1c", "streamPath": "/live/x1c/stream.m3u8"},
+		{"id": "p1s", "timelapseDir": "/videos/p1s", "streamPath": "/live/p1s/stream.m3u8", "videosUrl": "/p1s-videos/"}
+	]`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := LoadPrinterConfigs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[0].Name != "X1 Carbon" || configs[1].VideosURL != "/p1s-videos/" {
+		t.Errorf("unexpected configs: %+v", configs)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"id": "x1c", "timelapse_dir": "/videos"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrinterConfigs(path); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestNewPrinterRegistry(t *testing.T) {
+	valid := PrinterConfig{ID: "x1c", TimelapseDir: t.TempDir(), StreamPath: "/nonexistent/stream.m3u8"}
+
+	r, err := NewPrinterRegistry([]PrinterConfig{valid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := r.Get("x1c")
+	if !ok || r.Default() != p {
+		t.Fatal("expected x1c to be registered as the default")
+	}
+	if p.Config.Name != "x1c" || p.Config.VideosURL != "/videos/x1c" || p.Config.StreamURL != "/live/x1c/stream.m3u8" {
+		t.Errorf("unexpected defaults: %+v", p.Config)
+	}
+
+	tests := []struct {
+		name    string
+		configs []PrinterConfig
+		wantErr string
+	}{
+		{"empty", nil, "no printers"},
+		{"bad id", []PrinterConfig{{ID: "X1 C", TimelapseDir: "/v", StreamPath: "/s"}}, "invalid printer id"},
+		{"duplicate", []PrinterConfig{valid, valid}, "duplicate"},
+		{"missing dir", []PrinterConfig{{ID: "p1s", StreamPath: "/s"}}, "timelapseDir"},
+	}
+	for _, tt := range tests {
+		_, err := NewPrinterRegistry(tt.configs)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestPrinterRegistryList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	onlineDir := t.TempDir()
+	for _, name := range []string{"video_2024-07-24_09-14-01.mp4", "video_2024-07-25_09-14-01.mp4"} {
+		if err := os.WriteFile(filepath.Join(onlineDir, name), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m3u8Path := filepath.Join(t.TempDir(), "stream.m3u8")
+	if err := os.WriteFile(m3u8Path, []byte("#EXTM3U\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewPrinterRegistry([]PrinterConfig{
+		{ID: "x1c", Name: "X1 Carbon", TimelapseDir: onlineDir, StreamPath: m3u8Path},
+		{ID: "p1s", TimelapseDir: "/nonexistent/path", StreamPath: "/nonexistent/stream.m3u8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/printers", nil)
+	r.List(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var list models.PrinterList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(list.Printers) != 2 || list.Printers[0].ID != "x1c" {
+		t.Fatalf("unexpected printers: %+v", list.Printers)
+	}
+	x1c := list.Printers[0]
+	if !x1c.Stream.Online || x1c.TimelapseCount != 2 || x1c.TimelapseBytes != 200 {
+		t.Errorf("unexpected x1c status: %+v", x1c)
+	}
+	if want := time.Date(2024, 7, 25, 9, 14, 1, 0, time.UTC); !x1c.LatestTimelapse.Equal(want) {
+		t.Errorf("expected latest %v, got %v", want, x1c.LatestTimelapse)
+	}
+
+	// A printer with a missing directory is still listed, just offline
+	p1s := list.Printers[1]
+	if p1s.Stream.Online || p1s.TimelapseCount != 0 || p1s.Name != "p1s" {
+		t.Errorf("unexpected p1s status: %+v", p1s)
+	}
+
+	if list.Online != 1 || list.TimelapseCount != 2 || list.TimelapseBytes != 200 {
+		t.Errorf("unexpected totals: %+v", list)
+	}
+}
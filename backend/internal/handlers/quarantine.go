@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	quarantineDirName = ".quarantine"
+
+	// quarantineSettle is how long an unhealthy file must go unmodified
+	// before it is quarantined, so downloads in progress are left alone.
+	quarantineSettle = time.Hour
+)
+
+// Quarantine moves incomplete and corrupt videos out of the library into
+// .quarantine in the timelapse directory, mirroring their paths. Their
+// thumbnails stay put, so a complete copy synced later picks them up again.
+type Quarantine struct {
+	dir     string
+	catalog *Catalog
+	enabled bool
+
+	mu sync.Mutex
+}
+
+func NewQuarantine(dir string, catalog *Catalog) *Quarantine {
+	return &Quarantine{dir: dir, catalog: catalog}
+}
+
+func (q *Quarantine) path(name string) string {
+	return filepath.Join(q.dir, quarantineDirName, filepath.FromSlash(name))
+}
+
+// Apply quarantines every unhealthy video that has not changed for
+// quarantineSettle and returns what was moved.
+func (q *Quarantine) Apply(now time.Time) ([]models.QuarantinedFile, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	moved := make([]models.QuarantinedFile, 0)
+	snapshot, err := q.catalog.Snapshot()
+	if err != nil {
+		return moved, err
+	}
+
+	for _, t := range snapshot.Items {
+		if t.Health == models.HealthHealthy {
+			continue
+		}
+		src := q.catalog.abs(t.Filename)
+		info, err := os.Stat(src)
+		if err != nil || now.Sub(info.ModTime()) < quarantineSettle {
+			continue
+		}
+		// The catalog may be behind a download that has since finished
+		health := media.Check(src)
+		if health.Status == media.Healthy {
+			q.catalog.Refresh(t.Filename)
+			continue
+		}
+
+		dst := q.path(t.Filename)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			log.Printf("quarantine: failed to move %s: %v", t.Filename, err)
+			continue
+		}
+		q.catalog.Refresh(t.Filename)
+
+		log.Printf("quarantine: moved %s (%s: %s)", t.Filename, health.Status, health.Reason)
+		moved = append(moved, models.QuarantinedFile{
+			Filename: t.Filename,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Health:   health.Status,
+			Reason:   health.Reason,
+		})
+	}
+	return moved, nil
+}
+
+// List returns every quarantined video, checking each one again.
+func (q *Quarantine) List() ([]models.QuarantinedFile, error) {
+	files := make([]models.QuarantinedFile, 0)
+	root := filepath.Join(q.dir, quarantineDirName)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && p == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !videoExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		health := media.Check(p)
+		files = append(files, models.QuarantinedFile{
+			Filename: filepath.ToSlash(rel),
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Health:   health.Status,
+			Reason:   health.Reason,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// Run quarantines unhealthy videos every interval until ctx is cancelled.
+// It returns immediately if automatic quarantine is disabled.
+func (q *Quarantine) Run(ctx context.Context, interval time.Duration) {
+	if !q.enabled {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if moved, err := q.Apply(time.Now()); err != nil {
+			log.Printf("quarantine: run failed: %v", err)
+		} else if len(moved) > 0 {
+			log.Printf("quarantine: moved %d timelapses", len(moved))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HealthReport summarises container health across the library, including
+// what is in quarantine.
+func (h *TimelapseHandler) HealthReport(c *gin.Context) {
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+
+	items := snapshot.Items
+	sortTimelapses(items, sortNewest)
+	report := models.HealthReport{Total: len(items), Issues: make([]models.HealthIssue, 0)}
+	for _, t := range items {
+		switch t.Health {
+		case models.HealthHealthy:
+			report.Healthy++
+			continue
+		case models.HealthIncomplete:
+			report.Incomplete++
+		default:
+			report.Corrupt++
+		}
+		report.Issues = append(report.Issues, models.HealthIssue{
+			Filename: t.Filename,
+			Size:     t.Size,
+			Date:     t.Date,
+			Health:   t.Health,
+			Reason:   t.HealthReason,
+		})
+	}
+
+	files, err := h.quarantine.List()
+	if err != nil {
+		log.Printf("quarantine: failed to list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read quarantine directory"})
+		return
+	}
+	report.Quarantine = models.QuarantineStatus{Enabled: h.quarantine.enabled, Files: files}
+	c.JSON(http.StatusOK, report)
+}
+
+// RunQuarantine quarantines unhealthy videos now, whether or not automatic
+// quarantine is enabled.
+func (h *TimelapseHandler) RunQuarantine(c *gin.Context) {
+	moved, err := h.quarantine.Apply(time.Now())
+	if err != nil {
+		log.Printf("quarantine: run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "quarantine run failed"})
+		return
+	}
+	c.JSON(http.StatusOK, moved)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// setupHealthDir writes a healthy MP4, an MP4 cut off before its moov box
+// and an MKV with an unknown document type, all older than the quarantine settle
+// time.
+func setupHealthDir(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+	partial := mediatest.MP4(mediatest.Video{MoovAtEnd: true, MdatSize: 4096})
+	files := map[string][]byte{
+		"video_2024-07-01_10-00-00.mp4":           mediatest.MP4(mediatest.Video{}),
+		"2024/video_2024-07-02_10-00-00.mp4":      partial[:2048],
+		"video_2024-07-03_10-00-00.mkv":           []byte("\x1A\x45\xDF\xA3\x84\x42\x82\x81x not a matroska file"),
+		"thumbnail/video_2024-07-02_10-00-00.jpg": []byte("thumb"),
+	}
+	old := time.Now().Add(-2 * quarantineSettle)
+	writeFiles(t, tmpDir, files)
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(tmpDir, filepath.FromSlash(name)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tmpDir
+}
+
+func healthReport(t *testing.T, h *TimelapseHandler) models.HealthReport {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses/health", nil)
+	h.HealthReport(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report models.HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return report
+}
+
+func TestHealthReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewTimelapseHandler(setupHealthDir(t))
+
+	report := healthReport(t, h)
+	if report.Total != 3 || report.Healthy != 1 || report.Incomplete != 1 || report.Corrupt != 1 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	if len(report.Issues) != 2 || report.Issues[0].Filename != "video_2024-07-03_10-00-00.mkv" || report.Issues[1].Filename != "2024/video_2024-07-02_10-00-00.mp4" {
+		t.Fatalf("expected both issues newest first, got %+v", report.Issues)
+	}
+	if report.Issues[1].Health != models.HealthIncomplete || report.Issues[1].Reason == "" {
+		t.Errorf("expected the partial download to be incomplete with a reason, got %+v", report.Issues[1])
+	}
+	if report.Quarantine.Enabled || len(report.Quarantine.Files) != 0 {
+		t.Errorf("expected an empty, disabled quarantine, got %+v", report.Quarantine)
+	}
+
+	_, page := listPage(t, h, "/api/timelapses?health=healthy")
+	if got := filenames(page.Items); len(got) != 1 || got[0] != "video_2024-07-01_10-00-00.mp4" {
+		t.Errorf("expected only the healthy video, got %v", got)
+	}
+	_, page = listPage(t, h, "/api/timelapses?health=incomplete,corrupt")
+	if page.Total != 2 {
+		t.Errorf("expected 2 unhealthy videos, got %d", page.Total)
+	}
+	if code, _ := listPage(t, h, "/api/timelapses?health=broken"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown health, got %d", code)
+	}
+}
+
+func TestQuarantine_Apply(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := setupHealthDir(t)
+	h := NewTimelapseHandler(tmpDir, WithQuarantine(true))
+
+	// A download still in progress is left alone
+	recent := "video_2024-07-04_10-00-00.mp4"
+	if err := os.WriteFile(filepath.Join(tmpDir, recent), []byte("\x00\x00\x00\x20ftypisom"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.catalog.Refresh(recent)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/timelapses/health/quarantine", nil)
+	h.RunQuarantine(c)
+
+	var moved []models.QuarantinedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &moved); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(moved) != 2 {
+		t.Fatalf("expected 2 files quarantined, got %+v", moved)
+	}
+
+	for _, name := range []string{"2024/video_2024-07-02_10-00-00.mp4", "video_2024-07-03_10-00-00.mkv"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, quarantineDirName, filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected %s in quarantine: %v", name, err)
+		}
+		if _, found, _ := h.catalog.Lookup(name); found {
+			t.Errorf("expected %s to leave the catalog", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "thumbnail", "video_2024-07-02_10-00-00.jpg")); err != nil {
+		t.Errorf("expected the thumbnail to stay: %v", err)
+	}
+	if _, found, _ := h.catalog.Lookup(recent); !found {
+		t.Error("expected the recent download to stay")
+	}
+
+	report := healthReport(t, h)
+	if !report.Quarantine.Enabled || len(report.Quarantine.Files) != 2 {
+		t.Errorf("expected 2 quarantined files in the report, got %+v", report.Quarantine)
+	}
+	if report.Total != 2 || report.Incomplete != 1 {
+		t.Errorf("expected only the in-progress download to remain unhealthy, got %+v", report)
+	}
+}
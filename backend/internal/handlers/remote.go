@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+)
+
+// presignWindow is how often presigned URLs change. They are signed at the
+// start of each window and stay valid for two, so a URL handed out is good
+// for at least a window and responses, with their ETags, stay stable
+// within one.
+const presignWindow = time.Hour
+
+// Remote moves timelapses older than the offload age from the timelapse
+// directory to remote storage, and keeps the catalog aware of what is
+// there. Thumbnails, metadata and the rest stay local; only the video
+// moves.
+type Remote struct {
+	catalog *Catalog
+	store   storage.Storage
+	after   time.Duration
+	urls    string
+	apiURL  string
+
+	// offloaded is called after a video's local copy has been removed.
+	offloaded func(name string)
+
+	mu      sync.Mutex
+	lastRun *models.OffloadRun
+}
+
+func NewRemote(catalog *Catalog) *Remote {
+	return &Remote{catalog: catalog, urls: models.RemoteURLsPresign, apiURL: DefaultAPIURL}
+}
+
+// Enabled reports whether remote storage is configured.
+func (r *Remote) Enabled() bool {
+	return r.store != nil
+}
+
+// presigner returns the store as a Presigner when presigned URLs are
+// wanted and it can make them.
+func (r *Remote) presigner() (storage.Presigner, bool) {
+	p, ok := r.store.(storage.Presigner)
+	return p, ok && r.urls == models.RemoteURLsPresign
+}
+
+// urlsChangedAt returns when the URLs Attach hands out last changed: the
+// start of the current presign window when presigning, otherwise zero.
+func (r *Remote) urlsChangedAt(now time.Time) time.Time {
+	if r.store == nil {
+		return time.Time{}
+	}
+	if _, ok := r.presigner(); !ok {
+		return time.Time{}
+	}
+	return now.Truncate(presignWindow)
+}
+
+// Attach points each remote item's URL at the object.
+func (r *Remote) Attach(items []models.Timelapse) {
+	for i := range items {
+		r.attach(&items[i])
+	}
+}
+
+func (r *Remote) attach(item *models.Timelapse) {
+	if !item.Remote || r.store == nil {
+		return
+	}
+	if p, ok := r.presigner(); ok {
+		u, err := p.PresignGet(item.Filename, time.Now().Truncate(presignWindow), 2*presignWindow)
+		if err == nil {
+			item.URL = u
+			return
+		}
+		log.Printf("remote: failed to presign %s: %v", item.Filename, err)
+	}
+	item.URL = r.apiURL + "/timelapses/" + url.PathEscape(item.Filename) + "/content"
+}
+
+// Sync reloads the list of remote videos into the catalog.
+func (r *Remote) Sync(ctx context.Context) error {
+	objects, err := r.store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	r.catalog.SetRemote(objects)
+	return nil
+}
+
+// Offload uploads every healthy local video dated before now minus the
+// offload age, then removes the local copy. A video that changes while it
+// uploads is left for the next run.
+func (r *Remote) Offload(ctx context.Context, now time.Time, after time.Duration) (models.OffloadRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run := models.OffloadRun{StartedAt: now, Files: make([]models.OffloadedFile, 0)}
+	defer func() {
+		run.FinishedAt = time.Now()
+		r.lastRun = &run
+	}()
+
+	snapshot, err := r.catalog.Snapshot()
+	if err != nil {
+		run.Error = err.Error()
+		return run, err
+	}
+	items := snapshot.Items
+	sortTimelapses(items, sortOldest)
+	cutoff := now.Add(-after)
+	for _, t := range items {
+		if t.Remote || t.Health != models.HealthHealthy || !t.Date.Before(cutoff) {
+			continue
+		}
+		size, err := r.upload(ctx, t.Filename)
+		if err != nil {
+			if ctx.Err() != nil {
+				run.Error = ctx.Err().Error()
+				return run, ctx.Err()
+			}
+			log.Printf("remote: failed to offload %s: %v", t.Filename, err)
+			continue
+		}
+		log.Printf("remote: offloaded %s", t.Filename)
+		run.Files = append(run.Files, models.OffloadedFile{Filename: t.Filename, Size: size})
+	}
+	return run, nil
+}
+
+func (r *Remote) upload(ctx context.Context, name string) (int64, error) {
+	file := r.catalog.abs(name)
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.store.Put(ctx, name, f, before.Size()); err != nil {
+		return 0, err
+	}
+	obj, err := r.store.Stat(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	if obj.Size != before.Size() {
+		return 0, fmt.Errorf("uploaded %d bytes, expected %d", obj.Size, before.Size())
+	}
+	after, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return 0, errors.New("changed while uploading")
+	}
+
+	// Record the remote copy first, so the video never drops out of the
+	// listing
+	r.catalog.AddRemote(obj)
+	if err := os.Remove(file); err != nil {
+		return 0, err
+	}
+	r.catalog.Refresh(name)
+	if r.offloaded != nil {
+		r.offloaded(name)
+	}
+	return obj.Size, nil
+}
+
+// Delete removes a remote video for good; remote storage has no trash.
+func (r *Remote) Delete(ctx context.Context, name string) error {
+	if err := r.store.Delete(ctx, name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r.catalog.RemoveRemote(name)
+	return nil
+}
+
+// Run syncs the remote listing and offloads old videos every interval
+// until ctx is cancelled. It returns immediately without remote storage.
+func (r *Remote) Run(ctx context.Context, interval time.Duration) {
+	if r.store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Sync(ctx); err != nil {
+			log.Printf("remote: failed to list %v: %v", r.store, err)
+		} else if r.after > 0 {
+			if run, err := r.Offload(ctx, time.Now(), r.after); err != nil {
+				log.Printf("remote: offload failed: %v", err)
+			} else if len(run.Files) > 0 {
+				log.Printf("remote: offloaded %d timelapses", len(run.Files))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// objectReader reads a remote object as an io.ReadSeeker for
+// http.ServeContent, opening it at the current offset on the first read
+// after each seek.
+type objectReader struct {
+	ctx    context.Context
+	store  storage.Storage
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.store.Open(o.ctx, o.key, o.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start")
+	}
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// Content serves a timelapse's video with range support, proxying remote
+// videos from storage. Local videos are redirected to their /videos URL.
+func (h *TimelapseHandler) Content(c *gin.Context) {
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if !item.Remote {
+		c.Redirect(http.StatusFound, item.URL)
+		return
+	}
+	if !h.remote.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "remote storage is not configured"})
+		return
+	}
+
+	obj, err := h.remote.store.Stat(c.Request.Context(), item.Filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+			return
+		}
+		log.Printf("remote: failed to stat %s: %v", item.Filename, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to read remote storage"})
+		return
+	}
+
+	clearWriteDeadline(c, "remote")
+
+	r := &objectReader{ctx: c.Request.Context(), store: h.remote.store, key: item.Filename, size: obj.Size}
+	defer r.Close()
+	c.Header("Content-Type", mediaTypes[strings.ToLower(path.Ext(item.Filename))])
+	c.Header("Cache-Control", videosCacheControl)
+	http.ServeContent(c.Writer, c.Request, path.Base(item.Filename), obj.ModTime, r)
+}
+
+// RemoteStatus describes the remote storage and the last offload run.
+func (h *TimelapseHandler) RemoteStatus(c *gin.Context) {
+	r := h.remote
+	status := models.RemoteStatus{Enabled: r.Enabled()}
+	if r.Enabled() {
+		status.Storage = fmt.Sprint(r.store)
+		status.URLs = models.RemoteURLsProxy
+		if _, ok := r.presigner(); ok {
+			status.URLs = models.RemoteURLsPresign
+		}
+		status.OffloadAfterDays = int(r.after / (24 * time.Hour))
+	}
+
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+	for _, t := range snapshot.Items {
+		if t.Remote {
+			status.Count++
+			status.Bytes += t.Size
+		}
+	}
+
+	r.mu.Lock()
+	status.LastRun = r.lastRun
+	r.mu.Unlock()
+	c.JSON(http.StatusOK, status)
+}
+
+// RunOffload offloads timelapses now. older_than_days overrides the
+// configured offload age, which is required when none is configured.
+func (h *TimelapseHandler) RunOffload(c *gin.Context) {
+	if !h.remote.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "remote storage is not configured"})
+		return
+	}
+	after := h.remote.after
+	if v := c.Query("older_than_days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid older_than_days"})
+			return
+		}
+		after = time.Duration(days) * 24 * time.Hour
+	} else if after == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "older_than_days is required when no offload age is configured"})
+		return
+	}
+
+	clearWriteDeadline(c, "remote")
+	run, err := h.remote.Offload(c.Request.Context(), time.Now(), after)
+	if err != nil {
+		log.Printf("remote: offload failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "offload failed"})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
+)
+
+// presignedLocal makes local storage look like a store clients can
+// download from directly.
+type presignedLocal struct {
+	storage.Storage
+}
+
+func (presignedLocal) PresignGet(key string, signedAt time.Time, expires time.Duration) (string, error) {
+	return fmt.Sprintf("https://cdn.example/%s?signed=%d&expires=%d", key, signedAt.Unix(), int(expires.Seconds())), nil
+}
+
+func setupRemoteHandler(t *testing.T, wrap func(storage.Storage) storage.Storage, urls string) (*TimelapseHandler, string, string) {
+	t.Helper()
+	tmpDir := setupFeedDir(t)
+	remoteDir := t.TempDir()
+	store, err := storage.NewLocal(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir, WithRemoteStorage(wrap(store), 24*time.Hour, urls))
+	t.Cleanup(func() { h.Close() })
+	return h, tmpDir, remoteDir
+}
+
+func TestRemote_Offload(t *testing.T) {
+	h, tmpDir, remoteDir := setupRemoteHandler(t, func(s storage.Storage) storage.Storage { return s }, models.RemoteURLsProxy)
+	original, err := os.ReadFile(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := h.remote.Offload(t.Context(), time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The incomplete video stays local
+	if len(run.Files) != 2 || run.Files[0].Filename != "video_2024-07-01_10-00-00.mp4" || run.Files[1].Filename != "video_2024-07-02_10-00-00.mkv" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected the local copy to be removed, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(remoteDir, "video_2024-07-01_10-00-00.mp4")); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("expected the remote copy to match, got %d bytes, %v", len(data), err)
+	}
+
+	_, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	if got := filenames(page.Items); len(got) != 3 {
+		t.Fatalf("expected offloaded videos to stay listed, got %v", got)
+	}
+	first := page.Items[0]
+	if !first.Remote || first.Size != int64(len(original)) || !first.Date.Equal(time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected remote item %+v", first)
+	}
+	if want := DefaultAPIURL + "/timelapses/video_2024-07-01_10-00-00.mp4/content"; first.URL != want || first.PlayableURL != want {
+		t.Errorf("expected proxied URL %s, got %s and %s", want, first.URL, first.PlayableURL)
+	}
+	if first.ThumbnailURL == "" {
+		t.Error("expected the local thumbnail to stay attached")
+	}
+	if page.Items[1].TranscodeState != models.TranscodeUnavailable || page.Items[2].Remote {
+		t.Errorf("unexpected items %+v", page.Items[1:])
+	}
+
+	// A fresh catalog finds them again from the listing
+	h2 := NewTimelapseHandler(tmpDir, WithRemoteStorage(h.remote.store, 0, models.RemoteURLsProxy))
+	defer h2.Close()
+	if err := h2.remote.Sync(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, page := listPage(t, h2, "/api/timelapses"); page.Total != 3 {
+		t.Errorf("expected 3 timelapses after syncing, got %v", filenames(page.Items))
+	}
+
+	w := callWithFilename(h.RemoteStatus, http.MethodGet, "")
+	var status models.RemoteStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.URLs != models.RemoteURLsProxy || status.OffloadAfterDays != 1 || status.Count != 2 || status.LastRun == nil || len(status.LastRun.Files) != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestRemote_Content(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, tmpDir, _ := setupRemoteHandler(t, func(s storage.Storage) storage.Storage { return s }, models.RemoteURLsProxy)
+	original, err := os.ReadFile(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.remote.Offload(t.Context(), time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("Range", "bytes=4-11")
+	c.Params = gin.Params{{Key: "filename", Value: "video_2024-07-01_10-00-00.mp4"}}
+	h.Content(c)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d: %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), original[4:12]) || w.Header().Get("Content-Type") != "video/mp4" {
+		t.Errorf("unexpected range %q as %s", w.Body.Bytes(), w.Header().Get("Content-Type"))
+	}
+
+	// Local videos redirect to where they are served
+	w = callWithFilename(h.Content, http.MethodGet, "video_2024-07-03_10-00-00.mp4")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/videos/video_2024-07-03_10-00-00.mp4" {
+		t.Errorf("expected a redirect to the local video, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// Remote videos cannot be clipped
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"start": 1, "end": 2}`))
+	c.Params = gin.Params{{Key: "filename", Value: "video_2024-07-01_10-00-00.mp4"}}
+	h.CreateClip(c)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 clipping a remote video, got %d", w.Code)
+	}
+}
+
+func TestRemote_Delete(t *testing.T) {
+	h, tmpDir, remoteDir := setupRemoteHandler(t, func(s storage.Storage) storage.Storage { return s }, models.RemoteURLsProxy)
+	if _, err := h.remote.Offload(t.Context(), time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	w := callWithFilename(h.Delete, http.MethodDelete, "video_2024-07-01_10-00-00.mp4")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "video_2024-07-01_10-00-00.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected the remote object to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "thumbnail", "video_2024-07-01_10-00-00.jpg")); !os.IsNotExist(err) {
+		t.Errorf("expected the thumbnail to be deleted, got %v", err)
+	}
+	if _, page := listPage(t, h, "/api/timelapses"); page.Total != 2 {
+		t.Errorf("expected 2 timelapses left, got %v", filenames(page.Items))
+	}
+	if items := listTrash(t, h); len(items) != 0 {
+		t.Errorf("expected nothing in the trash, got %+v", items)
+	}
+}
+
+func TestRemote_Presigned(t *testing.T) {
+	h, _, _ := setupRemoteHandler(t, func(s storage.Storage) storage.Storage { return presignedLocal{s} }, models.RemoteURLsPresign)
+	if _, err := h.remote.Offload(t.Context(), time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	_, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	signedAt := time.Now().Truncate(presignWindow).Unix()
+	want := fmt.Sprintf("https://cdn.example/video_2024-07-01_10-00-00.mp4?signed=%d&expires=7200", signedAt)
+	if got := page.Items[0].URL; got != want {
+		t.Errorf("expected presigned URL %s, got %s", want, got)
+	}
+
+	// Proxy mode ignores the presigner
+	h.remote.urls = models.RemoteURLsProxy
+	_, page = listPage(t, h, "/api/timelapses?sort=oldest")
+	if got := page.Items[0].URL; got != DefaultAPIURL+"/timelapses/video_2024-07-01_10-00-00.mp4/content" {
+		t.Errorf("expected proxied URL, got %s", got)
+	}
+}
+
+func TestRemote_Disabled(t *testing.T) {
+	h := NewTimelapseHandler(setupFeedDir(t))
+	defer h.Close()
+
+	w := callWithFilename(h.RunOffload, http.MethodPost, "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without remote storage, got %d", w.Code)
+	}
+	w = callWithFilename(h.RemoteStatus, http.MethodGet, "")
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"enabled":false`)) {
+		t.Errorf("unexpected status %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRemote_SearchFeedsAndMetadata(t *testing.T) {
+	h, _, _ := setupRemoteHandler(t, func(s storage.Storage) storage.Storage { return presignedLocal{s} }, models.RemoteURLsPresign)
+	if _, err := h.remote.Offload(t.Context(), time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	signedAt := time.Now().Truncate(presignWindow).Unix()
+	want := fmt.Sprintf("https://cdn.example/video_2024-07-01_10-00-00.mp4?signed=%d&expires=7200", signedAt)
+
+	_, page := searchPage(t, h, "q=2024-07-01")
+	if len(page.Items) != 1 || page.Items[0].URL != want {
+		t.Errorf("expected the presigned URL in search results, got %+v", page.Items)
+	}
+
+	w := serveFeed(h, (*TimelapseHandler).RSSFeed, "/feed.rss", http.Header{})
+	if !bytes.Contains(w.Body.Bytes(), []byte(`url="`+strings.ReplaceAll(want, "&", "&amp;")+`"`)) {
+		t.Errorf("expected the presigned URL as the enclosure, got:\n%s", w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); !strings.Contains(etag, fmt.Sprint(signedAt)) {
+		t.Errorf("expected the ETag to change with the presign window, got %s", etag)
+	}
+
+	w = callMetadata(h.PatchMetadata, http.MethodPatch, "video_2024-07-01_10-00-00.mp4", `{"starred":true}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected offloaded videos to accept metadata, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const retentionLogName = ".retention.jsonl"
+
+// Retention removes timelapses that fall outside a RetentionPolicy. Removed
+// videos go to the trash, so a bad policy can still be undone until the
+// trash is purged. With a size limit they are purged at once instead, since
+// the trash shares their disk and would free nothing. Every removal is
+// appended to a log file in the timelapse directory.
+type Retention struct {
+	catalog *Catalog
+	trash   *Trash
+	logPath string
+	policy  models.RetentionPolicy
+
+	// starred reports whether a timelapse is protected by a star; nil
+	// means nothing is starred.
+	starred func(filename string) bool
+
+	mu      sync.Mutex
+	lastRun time.Time
+}
+
+func NewRetention(dir string, catalog *Catalog, trash *Trash) *Retention {
+	return &Retention{
+		catalog: catalog,
+		trash:   trash,
+		logPath: filepath.Join(dir, retentionLogName),
+	}
+}
+
+// Enabled reports whether the policy has any rule that can remove files.
+func (r *Retention) Enabled() bool {
+	return r.policy.MaxAgeDays > 0 || r.policy.MaxTotalBytes > 0
+}
+
+// Plan evaluates the policy against the catalog without changing anything.
+// Candidates are ordered oldest first.
+func (r *Retention) Plan(now time.Time) (models.RetentionPlan, error) {
+	plan := models.RetentionPlan{
+		Policy:      r.policy,
+		EvaluatedAt: now,
+		Candidates:  make([]models.RetentionCandidate, 0),
+	}
+
+	snapshot, err := r.catalog.Snapshot()
+	if err != nil {
+		return plan, err
+	}
+	// Retention frees local space, so remote videos don't count
+	items := slices.DeleteFunc(snapshot.Items, func(t models.Timelapse) bool { return t.Remote })
+	sortTimelapses(items, sortNewest)
+
+	protected := func(i int) bool {
+		if i < r.policy.KeepNewest {
+			return true
+		}
+		return r.policy.ProtectStarred && r.starred != nil && r.starred(items[i].Filename)
+	}
+
+	reasons := make(map[int]string)
+	for _, t := range items {
+		plan.TotalBytes += t.Size
+	}
+
+	if r.policy.MaxAgeDays > 0 {
+		cutoff := now.AddDate(0, 0, -r.policy.MaxAgeDays)
+		for i, t := range items {
+			if !protected(i) && t.Date.Before(cutoff) {
+				reasons[i] = models.RetentionReasonMaxAge
+				plan.FreedBytes += t.Size
+			}
+		}
+	}
+
+	if r.policy.MaxTotalBytes > 0 {
+		// Drop the oldest until the remainder fits
+		for i := len(items) - 1; i >= 0 && plan.TotalBytes-plan.FreedBytes > r.policy.MaxTotalBytes; i-- {
+			if _, ok := reasons[i]; ok || protected(i) {
+				continue
+			}
+			reasons[i] = models.RetentionReasonMaxTotalBytes
+			plan.FreedBytes += items[i].Size
+		}
+	}
+
+	for i := len(items) - 1; i >= 0; i-- {
+		if reason, ok := reasons[i]; ok {
+			plan.Candidates = append(plan.Candidates, models.RetentionCandidate{
+				Filename: items[i].Filename,
+				Size:     items[i].Size,
+				Date:     items[i].Date,
+				Reason:   reason,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// Apply moves every planned candidate to the trash, purging them when the
+// policy limits the total size, and returns what was removed.
+func (r *Retention) Apply(now time.Time) ([]models.RetentionLogEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := make([]models.RetentionLogEntry, 0)
+	if !r.Enabled() {
+		return removed, nil
+	}
+
+	plan, err := r.Plan(now)
+	if err != nil {
+		return removed, err
+	}
+	r.lastRun = now
+
+	for _, candidate := range plan.Candidates {
+		thumbs, err := r.trash.Move(candidate.Filename)
+		if err != nil {
+			if !errors.Is(err, errNotFound) {
+				log.Printf("retention: failed to remove %s: %v", candidate.Filename, err)
+			}
+			continue
+		}
+		r.catalog.Refresh(candidate.Filename, thumbs...)
+		// The plan counts every candidate as freed towards the size limit
+		if r.policy.MaxTotalBytes > 0 {
+			if err := r.trash.Purge(candidate.Filename); err != nil {
+				log.Printf("retention: failed to purge %s: %v", candidate.Filename, err)
+			}
+		}
+
+		entry := models.RetentionLogEntry{RetentionCandidate: candidate, RemovedAt: time.Now().UTC()}
+		if err := r.appendLog(entry); err != nil {
+			log.Printf("retention: failed to write log: %v", err)
+		}
+		log.Printf("retention: removed %s (%s)", candidate.Filename, candidate.Reason)
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
+
+func (r *Retention) appendLog(entry models.RetentionLogEntry) error {
+	f, err := os.OpenFile(r.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Log returns up to limit removals, most recent first.
+func (r *Retention) Log(limit int) ([]models.RetentionLogEntry, error) {
+	entries := make([]models.RetentionLogEntry, 0)
+
+	f, err := os.Open(r.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry models.RetentionLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(entries)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Run applies the policy every interval until ctx is cancelled. It returns
+// immediately if the policy is disabled.
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	if !r.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := r.Apply(time.Now()); err != nil {
+			log.Printf("retention: run failed: %v", err)
+		} else if len(removed) > 0 {
+			log.Printf("retention: removed %d timelapses", len(removed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *TimelapseHandler) RetentionStatus(c *gin.Context) {
+	h.retention.mu.Lock()
+	lastRun := h.retention.lastRun
+	h.retention.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": h.retention.Enabled(),
+		"policy":  h.retention.policy,
+		"lastRun": lastRun,
+	})
+}
+
+// RetentionDryRun shows what the retention worker would remove right now.
+func (h *TimelapseHandler) RetentionDryRun(c *gin.Context) {
+	plan, err := h.retention.Plan(time.Now())
+	if err != nil {
+		log.Printf("retention: failed to plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to evaluate retention policy"})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h *TimelapseHandler) RetentionLog(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.retention.Log(limit)
+	if err != nil {
+		log.Printf("retention: failed to read log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read retention log"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// RunRetention applies the policy immediately instead of waiting for the
+// next scheduled run.
+func (h *TimelapseHandler) RunRetention(c *gin.Context) {
+	removed, err := h.retention.Apply(time.Now())
+	if err != nil {
+		log.Printf("retention: run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "retention run failed"})
+		return
+	}
+	c.JSON(http.StatusOK, removed)
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// setupRetentionDir creates one 100-byte video per day from 2024-07-01 to
+// 2024-07-05.
+func setupRetentionDir(t *testing.T) string {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	for day := 1; day <= 5; day++ {
+		name := fmt.Sprintf("video_2024-07-%02d_10-00-00.mp4", day)
+		if err := os.WriteFile(filepath.Join(tmpDir, name), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tmpDir
+}
+
+func candidateNames(plan models.RetentionPlan) []string {
+	names := make([]string, len(plan.Candidates))
+	for i, c := range plan.Candidates {
+		names[i] = c.Filename
+	}
+	return names
+}
+
+func TestRetentionPlan(t *testing.T) {
+	now := time.Date(2024, 7, 6, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		policy  models.RetentionPolicy
+		starred string
+		want    []string
+		reasons []string
+	}{
+		{
+			name:   "disabled",
+			policy: models.RetentionPolicy{},
+			want:   []string{},
+		},
+		{
+			name:    "max age",
+			policy:  models.RetentionPolicy{MaxAgeDays: 3},
+			want:    []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4"},
+			reasons: []string{models.RetentionReasonMaxAge, models.RetentionReasonMaxAge},
+		},
+		{
+			name:    "max total bytes removes oldest first",
+			policy:  models.RetentionPolicy{MaxTotalBytes: 250},
+			want:    []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4", "video_2024-07-03_10-00-00.mp4"},
+			reasons: []string{models.RetentionReasonMaxTotalBytes, models.RetentionReasonMaxTotalBytes, models.RetentionReasonMaxTotalBytes},
+		},
+		{
+			name:   "keep newest overrides max age",
+			policy: models.RetentionPolicy{MaxAgeDays: 1, KeepNewest: 4},
+			want:   []string{"video_2024-07-01_10-00-00.mp4"},
+		},
+		{
+			name:    "starred are protected",
+			policy:  models.RetentionPolicy{MaxAgeDays: 3, ProtectStarred: true},
+			starred: "video_2024-07-01_10-00-00.mp4",
+			want:    []string{"video_2024-07-02_10-00-00.mp4"},
+		},
+		{
+			name:    "stars ignored when protection is off",
+			policy:  models.RetentionPolicy{MaxAgeDays: 3},
+			starred: "video_2024-07-01_10-00-00.mp4",
+			want:    []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4"},
+		},
+		{
+			name:    "max age then size",
+			policy:  models.RetentionPolicy{MaxAgeDays: 4, MaxTotalBytes: 250},
+			want:    []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4", "video_2024-07-03_10-00-00.mp4"},
+			reasons: []string{models.RetentionReasonMaxAge, models.RetentionReasonMaxTotalBytes, models.RetentionReasonMaxTotalBytes},
+		},
+	}
+
+	for _, tt := range tests {
+		h := NewTimelapseHandler(setupRetentionDir(t), WithRetentionPolicy(tt.policy))
+		h.retention.starred = func(name string) bool { return name == tt.starred }
+
+		plan, err := h.retention.Plan(now)
+		if err != nil {
+			t.Fatalf("%s: plan failed: %v", tt.name, err)
+		}
+
+		got := candidateNames(plan)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+			if tt.reasons != nil && plan.Candidates[i].Reason != tt.reasons[i] {
+				t.Errorf("%s: candidate %d reason %q, want %q", tt.name, i, plan.Candidates[i].Reason, tt.reasons[i])
+			}
+		}
+		if plan.TotalBytes != 500 || plan.FreedBytes != int64(len(tt.want))*100 {
+			t.Errorf("%s: unexpected totals %d/%d", tt.name, plan.TotalBytes, plan.FreedBytes)
+		}
+	}
+}
+
+func TestRetentionApplyAndLog(t *testing.T) {
+	tmpDir := setupRetentionDir(t)
+	h := NewTimelapseHandler(tmpDir, WithRetentionPolicy(models.RetentionPolicy{MaxTotalBytes: 300}))
+
+	// Dry run changes nothing
+	w := callWithFilename(h.RetentionDryRun, http.MethodGet, "")
+	var plan models.RetentionPlan
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(plan.Candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %+v", plan.Candidates)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, plan.Candidates[0].Filename)); err != nil {
+		t.Fatalf("dry run should not remove files: %v", err)
+	}
+
+	w = callWithFilename(h.RunRetention, http.MethodPost, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var removed []models.RetentionLogEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &removed); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 removed, got %+v", removed)
+	}
+
+	// Videos over the size limit are purged, not left in the trash on the
+	// same disk, and leave the catalog
+	for _, name := range []string{"video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mp4"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s removed, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, ".trash", name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s purged from trash, got %v", name, err)
+		}
+	}
+	if trashed, err := h.trash.List(); err != nil || len(trashed) != 0 {
+		t.Errorf("expected empty trash, got %+v, %v", trashed, err)
+	}
+	snap, _ := h.catalog.Snapshot()
+	if len(snap.Items) != 3 {
+		t.Errorf("expected 3 videos left in catalog, got %d", len(snap.Items))
+	}
+
+	// A second run has nothing left to do
+	if again, err := h.retention.Apply(time.Now()); err != nil || len(again) != 0 {
+		t.Errorf("expected no-op second run, got %v, %v", again, err)
+	}
+
+	w = callWithFilename(h.RetentionLog, http.MethodGet, "")
+	var entries []models.RetentionLogEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %+v", entries)
+	}
+	// Most recent removal first
+	if entries[0].Filename != "video_2024-07-02_10-00-00.mp4" || entries[0].Reason != models.RetentionReasonMaxTotalBytes {
+		t.Errorf("unexpected first log entry: %+v", entries[0])
+	}
+	if entries[0].RemovedAt.IsZero() {
+		t.Error("expected removal time in log entry")
+	}
+}
+
+func TestRetentionApply_MaxAgeKeepsTrash(t *testing.T) {
+	tmpDir := setupRetentionDir(t)
+	h := NewTimelapseHandler(tmpDir, WithRetentionPolicy(models.RetentionPolicy{MaxAgeDays: 3}))
+
+	removed, err := h.retention.Apply(time.Date(2024, 7, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("expected 2 removed, got %+v, %v", removed, err)
+	}
+
+	// Videos past the age limit can still be restored
+	if _, err := os.Stat(filepath.Join(tmpDir, ".trash", "video_2024-07-01_10-00-00.mp4")); err != nil {
+		t.Errorf("expected removed video in trash: %v", err)
+	}
+	if trashed, err := h.trash.List(); err != nil || len(trashed) != 2 {
+		t.Errorf("expected 2 trashed, got %+v, %v", trashed, err)
+	}
+}
+
+func TestRetentionApply_MaxAgeAndSizePurges(t *testing.T) {
+	tmpDir := setupRetentionDir(t)
+	h := NewTimelapseHandler(tmpDir, WithRetentionPolicy(models.RetentionPolicy{MaxAgeDays: 4, MaxTotalBytes: 250}))
+
+	removed, err := h.retention.Apply(time.Date(2024, 7, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(removed) != 3 {
+		t.Fatalf("expected 3 removed, got %+v, %v", removed, err)
+	}
+	if removed[0].Reason != models.RetentionReasonMaxAge {
+		t.Errorf("expected the oldest removed for its age, got %+v", removed[0])
+	}
+
+	// Videos past the age limit are purged too, or the plan's freed bytes
+	// would still sit in the trash on the same disk
+	if trashed, err := h.trash.List(); err != nil || len(trashed) != 0 {
+		t.Errorf("expected empty trash, got %+v, %v", trashed, err)
+	}
+	var used int64
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".mp4" {
+			info, _ := e.Info()
+			used += info.Size()
+		}
+	}
+	if used > 250 {
+		t.Errorf("expected at most 250 bytes of video left, got %d", used)
+	}
+}
+
+func TestRetentionApply_DisabledPolicy(t *testing.T) {
+	h := NewTimelapseHandler(setupRetentionDir(t))
+	if h.retention.Enabled() {
+		t.Fatal("expected default policy to be disabled")
+	}
+	removed, err := h.retention.Apply(time.Now())
+	if err != nil || len(removed) != 0 {
+		t.Errorf("expected nothing removed, got %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+
+	// noteSnippetRadius is roughly how many bytes of notes are kept on
+	// either side of the first match.
+	noteSnippetRadius = 40
+)
+
+// Searchable fields.
+const (
+	fieldFilename = "filename"
+	fieldDate     = "date"
+	fieldTags     = "tags"
+	fieldNotes    = "notes"
+)
+
+// fieldWeights is how much a match in each field counts towards the score.
+// An exact token match scores the full weight and a prefix match half.
+var fieldWeights = map[string]float64{fieldTags: 4, fieldFilename: 3, fieldNotes: 1}
+
+var dateExprPattern = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2}|\d{4}-\d{2}|\d{4}|today|yesterday|(?:this|last) (?:week|month|year)|(?:last|past) \d+ days?)\b`)
+
+type posting struct {
+	doc   int
+	field string
+}
+
+// searchState is one immutable build of the index.
+type searchState struct {
+	docs     []models.Timelapse // oldest first
+	postings map[string][]posting
+	tokens   []string // sorted keys of postings, for prefix lookups
+}
+
+// SearchIndex is an inverted index over filenames, tags and notes, plus a
+// date-sorted list of timelapses for date ranges. It is rebuilt when the
+// catalog generation or the metadata version changes, so a query only
+// touches the postings for its terms.
+type SearchIndex struct {
+	catalog  *Catalog
+	metadata *MetadataStore
+
+	mu          sync.Mutex
+	state       *searchState
+	generation  uint64
+	metaVersion uint64
+}
+
+func NewSearchIndex(catalog *Catalog, metadata *MetadataStore) *SearchIndex {
+	return &SearchIndex{catalog: catalog, metadata: metadata}
+}
+
+// current returns an up to date index, rebuilding it if needed.
+func (s *SearchIndex) current() (*searchState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	generation, loaded := s.catalog.Generation()
+	version := s.metadata.Version()
+	if s.state != nil && loaded && generation == s.generation && version == s.metaVersion {
+		return s.state, nil
+	}
+
+	snapshot, err := s.catalog.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.metadata.Attach(snapshot.Items); err != nil {
+		return nil, err
+	}
+	linkClips(snapshot.Items)
+
+	s.state = buildSearchState(snapshot.Items)
+	s.generation = snapshot.Generation
+	s.metaVersion = version
+	return s.state, nil
+}
+
+func buildSearchState(items []models.Timelapse) *searchState {
+	sortTimelapses(items, sortOldest)
+	st := &searchState{docs: items, postings: make(map[string][]posting)}
+
+	for i, t := range items {
+		st.add(i, fieldFilename, t.Filename)
+		if t.TimelapseMeta != nil {
+			for _, tag := range t.Tags {
+				st.add(i, fieldTags, tag)
+			}
+			st.add(i, fieldNotes, t.Notes)
+		}
+	}
+	st.tokens = slices.Sorted(maps.Keys(st.postings))
+	return st
+}
+
+func (st *searchState) add(doc int, field, text string) {
+	for _, tok := range tokenize(text) {
+		list := st.postings[tok.text]
+		if n := len(list); n > 0 && list[n-1] == (posting{doc, field}) {
+			continue
+		}
+		st.postings[tok.text] = append(list, posting{doc, field})
+	}
+}
+
+// prefixed returns every indexed token starting with term.
+func (st *searchState) prefixed(term string) []string {
+	i := sort.SearchStrings(st.tokens, term)
+	j := i
+	for j < len(st.tokens) && strings.HasPrefix(st.tokens[j], term) {
+		j++
+	}
+	return st.tokens[i:j]
+}
+
+// search returns the matching timelapses, best first. Every term must
+// match some field; a date range narrows the candidates first.
+func (st *searchState) search(q searchQuery) []models.SearchResult {
+	lo, hi := 0, len(st.docs)
+	if !q.from.IsZero() {
+		lo = sort.Search(len(st.docs), func(i int) bool { return !st.docs[i].Date.Before(q.from) })
+		hi = sort.Search(len(st.docs), func(i int) bool { return !st.docs[i].Date.Before(q.to) })
+	}
+
+	scores := make(map[int]float64)
+	if len(q.terms) == 0 {
+		if q.from.IsZero() {
+			return []models.SearchResult{}
+		}
+		for i := lo; i < hi; i++ {
+			scores[i] = 0
+		}
+	}
+
+	type hit struct {
+		doc   int
+		field string
+	}
+	for n, term := range q.terms {
+		best := make(map[hit]float64)
+		for _, tok := range st.prefixed(term) {
+			weight := 0.5
+			if tok == term {
+				weight = 1
+			}
+			for _, p := range st.postings[tok] {
+				if p.doc < lo || p.doc >= hi {
+					continue
+				}
+				key := hit{p.doc, p.field}
+				best[key] = max(best[key], weight*fieldWeights[p.field])
+			}
+		}
+
+		termScores := make(map[int]float64)
+		for key, score := range best {
+			termScores[key.doc] += score
+		}
+		if n == 0 {
+			scores = termScores
+			continue
+		}
+		for doc := range scores {
+			if score, ok := termScores[doc]; ok {
+				scores[doc] += score
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	results := make([]models.SearchResult, 0, len(scores))
+	for doc, score := range scores {
+		results = append(results, models.SearchResult{Timelapse: st.docs[doc], Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Date.After(results[j].Date)
+	})
+	return results
+}
+
+// searchQuery is a parsed query: free-text terms plus an optional date
+// range [from, to) taken from date expressions. relative is set when the
+// range depends on the current date.
+type searchQuery struct {
+	terms    []string
+	from, to time.Time
+	relative bool
+}
+
+// parseSearchQuery pulls date expressions ("2024-07", "last week", "past 3
+// days") out of q and tokenizes the rest. Several date expressions narrow
+// the range to their overlap. Relative dates are resolved against now.
+func parseSearchQuery(q string, now time.Time) searchQuery {
+	var query searchQuery
+	lower := strings.ToLower(q)
+
+	var rest strings.Builder
+	last := 0
+	for _, m := range dateExprPattern.FindAllStringIndex(lower, -1) {
+		from, to, ok := dateRange(lower[m[0]:m[1]], now)
+		if !ok {
+			continue
+		}
+		rest.WriteString(lower[last:m[0]])
+		rest.WriteByte(' ')
+		last = m[1]
+		// Only absolute dates start with a digit
+		if lower[m[0]] < '0' || lower[m[0]] > '9' {
+			query.relative = true
+		}
+
+		if query.from.IsZero() || from.After(query.from) {
+			query.from = from
+		}
+		if query.to.IsZero() || to.Before(query.to) {
+			query.to = to
+		}
+	}
+	rest.WriteString(lower[last:])
+
+	// Disjoint ranges match nothing
+	if !query.from.IsZero() && !query.from.Before(query.to) {
+		query.to = query.from
+	}
+
+	for _, tok := range tokenize(rest.String()) {
+		if !slices.Contains(query.terms, tok.text) {
+			query.terms = append(query.terms, tok.text)
+		}
+	}
+	return query
+}
+
+// dateRange converts one date expression into a half-open range in now's
+// location.
+func dateRange(expr string, now time.Time) (time.Time, time.Time, bool) {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	// Weeks start on Monday
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc)
+
+	switch expr {
+	case "today":
+		return today, today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, true
+	case "this week":
+		return weekStart, weekStart.AddDate(0, 0, 7), true
+	case "last week":
+		return weekStart.AddDate(0, 0, -7), weekStart, true
+	case "this month":
+		return monthStart, monthStart.AddDate(0, 1, 0), true
+	case "last month":
+		return monthStart.AddDate(0, -1, 0), monthStart, true
+	case "this year":
+		return yearStart, yearStart.AddDate(1, 0, 0), true
+	case "last year":
+		return yearStart.AddDate(-1, 0, 0), yearStart, true
+	}
+
+	if fields := strings.Fields(expr); len(fields) == 3 {
+		// "last N days" includes today
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > 3650 {
+			return time.Time{}, time.Time{}, false
+		}
+		return today.AddDate(0, 0, 1-n), today.AddDate(0, 0, 1), true
+	}
+
+	for _, f := range []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if len(expr) != len(f.layout) {
+			continue
+		}
+		t, err := time.ParseInLocation(f.layout, expr, loc)
+		// Other four-digit numbers are left as search terms
+		if err != nil || t.Year() < 1970 || t.Year() > 2100 {
+			return time.Time{}, time.Time{}, false
+		}
+		return t, t.AddDate(f.years, f.months, f.days), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+type token struct {
+	text       string // lowercased
+	start, end int    // byte offsets in the original text
+}
+
+// tokenize splits text into runs of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// highlight splits text into fragments, marking tokens that start with any
+// of the terms, and reports whether anything matched.
+func highlight(text string, terms []string) ([]models.HighlightFragment, bool) {
+	var frags []models.HighlightFragment
+	last := 0
+	for _, tok := range tokenize(text) {
+		if !slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(tok.text, term) }) {
+			continue
+		}
+		if tok.start > last {
+			frags = append(frags, models.HighlightFragment{Text: text[last:tok.start]})
+		}
+		frags = append(frags, models.HighlightFragment{Text: text[tok.start:tok.end], Match: true})
+		last = tok.end
+	}
+	if last == 0 {
+		return nil, false
+	}
+	if last < len(text) {
+		frags = append(frags, models.HighlightFragment{Text: text[last:]})
+	}
+	return frags, true
+}
+
+// noteSnippet highlights the part of the notes around the first match,
+// cutting at whitespace and marking the cuts with an ellipsis.
+func noteSnippet(notes string, terms []string) ([]models.HighlightFragment, bool) {
+	start, end := -1, -1
+	for _, tok := range tokenize(notes) {
+		if slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(tok.text, term) }) {
+			start, end = tok.start, tok.end
+			break
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+
+	from, to := 0, len(notes)
+	if start > noteSnippetRadius {
+		from = start
+		if i := strings.IndexAny(notes[start-noteSnippetRadius:start], " \t\n"); i >= 0 {
+			from = start - noteSnippetRadius + i + 1
+		}
+	}
+	if len(notes)-end > noteSnippetRadius {
+		to = end
+		if i := strings.LastIndexAny(notes[end:end+noteSnippetRadius], " \t\n"); i >= 0 {
+			to = end + i
+		}
+	}
+
+	frags, _ := highlight(notes[from:to], terms)
+	if from > 0 {
+		frags = append([]models.HighlightFragment{{Text: "…"}}, frags...)
+	}
+	if to < len(notes) {
+		frags = append(frags, models.HighlightFragment{Text: "…"})
+	}
+	return frags, true
+}
+
+func (q searchQuery) highlights(t models.Timelapse) []models.SearchHighlight {
+	highlights := make([]models.SearchHighlight, 0)
+	if !q.from.IsZero() {
+		highlights = append(highlights, models.SearchHighlight{
+			Field:     fieldDate,
+			Fragments: []models.HighlightFragment{{Text: t.Date.Format("2006-01-02 15:04"), Match: true}},
+		})
+	}
+	if len(q.terms) == 0 {
+		return highlights
+	}
+
+	if frags, ok := highlight(t.Filename, q.terms); ok {
+		highlights = append(highlights, models.SearchHighlight{Field: fieldFilename, Fragments: frags})
+	}
+	if t.TimelapseMeta == nil {
+		return highlights
+	}
+	for _, tag := range t.Tags {
+		if frags, ok := highlight(tag, q.terms); ok {
+			highlights = append(highlights, models.SearchHighlight{Field: fieldTags, Fragments: frags})
+		}
+	}
+	if frags, ok := noteSnippet(t.Notes, q.terms); ok {
+		highlights = append(highlights, models.SearchHighlight{Field: fieldNotes, Fragments: frags})
+	}
+	return highlights
+}
+
+// Search ranks timelapses against ?q=, which may mix words from filenames,
+// tags and notes with date expressions.
+func (h *TimelapseHandler) Search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing search query"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	now := time.Now().In(h.catalog.dates.loc)
+	query := parseSearchQuery(q, now)
+	generation, changedAt := h.catalog.Changed()
+	var extra []string
+	if query.relative {
+		// Relative dates resolve to a new range from midnight on
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		extra = append(extra, today.Format("20060102"))
+		if today.After(changedAt) {
+			changedAt = today
+		}
+	}
+	etag, lastModified := h.validators(generation, changedAt, extra...)
+	if notModified(c, etag, lastModified) {
+		return
+	}
+	state, err := h.search.current()
+	if err != nil {
+		log.Printf("search: failed to build index: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search timelapses"})
+		return
+	}
+
+	results := state.search(query)
+	page := models.SearchPage{
+		Query: q,
+		Items: results[:min(limit, len(results))],
+		Total: len(results),
+		From:  query.from,
+		To:    query.to,
+	}
+	for i := range page.Items {
+		h.remote.attach(&page.Items[i].Timelapse)
+		h.transcoder.attach(&page.Items[i].Timelapse)
+		h.previews.attach(&page.Items[i].Timelapse)
+		page.Items[i].Highlights = query.highlights(page.Items[i].Timelapse)
+	}
+	c.JSON(http.StatusOK, page)
+}
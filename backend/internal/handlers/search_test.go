@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func searchPage(t *testing.T, h *TimelapseHandler, query string) (int, models.SearchPage) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses/search?"+query, nil)
+	h.Search(c)
+
+	var page models.SearchPage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+	}
+	return w.Code, page
+}
+
+func resultNames(items []models.SearchResult) []string {
+	names := make([]string, len(items))
+	for i, r := range items {
+		names[i] = r.Filename
+	}
+	return names
+}
+
+func fragmentsText(frags []models.HighlightFragment) string {
+	var sb strings.Builder
+	for _, f := range frags {
+		if f.Match {
+			sb.WriteString("[" + f.Text + "]")
+		} else {
+			sb.WriteString(f.Text)
+		}
+	}
+	return sb.String()
+}
+
+func setupSearchHandler(t *testing.T) *TimelapseHandler {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"benchy_2024-07-01_10-00-00.mp4",
+		"vase_2024-07-15_10-00-00.mp4",
+		"plate_2024-08-02_10-00-00.mkv",
+		"calibration_2023-12-31_10-00-00.mp4",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewTimelapseHandler(tmpDir)
+	t.Cleanup(func() { h.Close() })
+	for name, body := range map[string]string{
+		"vase_2024-07-15_10-00-00.mp4":  `{"tags": ["PLA", "benchmark"], "notes": "Silk filament, came out great"}`,
+		"plate_2024-08-02_10-00-00.mkv": `{"tags": ["PETG"], "notes": "Warped at the corners because the bed was not level enough, reprint with a brim next time"}`,
+	} {
+		if w := callMetadata(h.PutMetadata, http.MethodPut, name, body); w.Code != http.StatusOK {
+			t.Fatalf("failed to save metadata for %s: %d", name, w.Code)
+		}
+	}
+	return h
+}
+
+func TestSearch_RanksAndHighlights(t *testing.T) {
+	h := setupSearchHandler(t)
+
+	code, page := searchPage(t, h, "q=bench")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// A tag prefix match outranks a filename prefix match
+	if got := resultNames(page.Items); strings.Join(got, ",") != "vase_2024-07-15_10-00-00.mp4,benchy_2024-07-01_10-00-00.mp4" {
+		t.Fatalf("unexpected ranking: %v", got)
+	}
+	if page.Total != 2 || page.Items[0].Score <= page.Items[1].Score {
+		t.Errorf("unexpected scores: %+v", page.Items)
+	}
+	hl := page.Items[1].Highlights
+	if len(hl) != 1 || hl[0].Field != "filename" || fragmentsText(hl[0].Fragments) != "[benchy]_2024-07-01_10-00-00.mp4" {
+		t.Errorf("unexpected filename highlight: %+v", hl)
+	}
+	hl = page.Items[0].Highlights
+	if len(hl) != 1 || hl[0].Field != "tags" || fragmentsText(hl[0].Fragments) != "[benchmark]" {
+		t.Errorf("unexpected tag highlight: %+v", hl)
+	}
+
+	// Every term must match, in any field
+	_, page = searchPage(t, h, "q=pla+silk")
+	if got := resultNames(page.Items); len(got) != 1 || got[0] != "vase_2024-07-15_10-00-00.mp4" {
+		t.Errorf("expected only the vase, got %v", got)
+	}
+
+	// Long notes are cut down around the match
+	_, page = searchPage(t, h, "q=brim")
+	if len(page.Items) != 1 {
+		t.Fatalf("expected 1 result, got %v", resultNames(page.Items))
+	}
+	notes := page.Items[0].Highlights[0]
+	if notes.Field != "notes" || fragmentsText(notes.Fragments) != "…was not level enough, reprint with a [brim] next time" {
+		t.Errorf("unexpected notes snippet: %q", fragmentsText(notes.Fragments))
+	}
+
+	if _, page = searchPage(t, h, "q=nylon"); len(page.Items) != 0 {
+		t.Errorf("expected no results, got %v", resultNames(page.Items))
+	}
+}
+
+func TestSearch_DateExpressions(t *testing.T) {
+	h := setupSearchHandler(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"q=2024-07", []string{"vase_2024-07-15_10-00-00.mp4", "benchy_2024-07-01_10-00-00.mp4"}},
+		{"q=2024-07-15", []string{"vase_2024-07-15_10-00-00.mp4"}},
+		{"q=2023", []string{"calibration_2023-12-31_10-00-00.mp4"}},
+		{"q=pla+2024-07", []string{"vase_2024-07-15_10-00-00.mp4"}},
+		{"q=2024+2024-08", []string{"plate_2024-08-02_10-00-00.mkv"}},
+		{"q=2023+2024", []string{}},
+	}
+	for _, tt := range tests {
+		code, page := searchPage(t, h, tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.query, code)
+			continue
+		}
+		if got := resultNames(page.Items); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	_, page := searchPage(t, h, "q=2024-07-15")
+	if page.From.IsZero() || page.Items[0].Highlights[0].Field != "date" {
+		t.Errorf("expected date range and highlight, got %+v", page)
+	}
+}
+
+func TestParseSearchQuery_RelativeDates(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 7, 24, 15, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2024, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		q        string
+		from, to time.Time
+		terms    []string
+	}{
+		{"today", day(7, 24), day(7, 25), nil},
+		{"Yesterday", day(7, 23), day(7, 24), nil},
+		{"this week", day(7, 22), day(7, 29), nil},
+		{"failed last week", day(7, 15), day(7, 22), []string{"failed"}},
+		{"last month", day(6, 1), day(7, 1), nil},
+		{"past 3 days", day(7, 22), day(7, 25), nil},
+		{"last year", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"part 1234", time.Time{}, time.Time{}, []string{"part", "1234"}},
+		{"2024-13", time.Time{}, time.Time{}, []string{"2024", "13"}},
+	}
+	for _, tt := range tests {
+		q := parseSearchQuery(tt.q, now)
+		if !q.from.Equal(tt.from) || !q.to.Equal(tt.to) {
+			t.Errorf("%q: got [%v, %v), want [%v, %v)", tt.q, q.from, q.to, tt.from, tt.to)
+		}
+		if strings.Join(q.terms, ",") != strings.Join(tt.terms, ",") {
+			t.Errorf("%q: got terms %v, want %v", tt.q, q.terms, tt.terms)
+		}
+		if q.relative != !tt.from.IsZero() {
+			t.Errorf("%q: got relative %v", tt.q, q.relative)
+		}
+	}
+	if q := parseSearchQuery("2024-07 benchy", now); q.relative {
+		t.Error("expected an absolute date not to be relative")
+	}
+}
+
+func TestSearch_RelativeDateValidators(t *testing.T) {
+	h := setupSearchHandler(t)
+	search := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses/search?"+query, nil)
+		h.Search(c)
+		return w
+	}
+
+	absolute, relative := search("q=2024-07"), search("q=today")
+	now := time.Now().In(h.catalog.dates.loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if strings.Contains(absolute.Header().Get("ETag"), today.Format("20060102")) {
+		t.Errorf("expected an absolute query's ETag not to depend on the date, got %s", absolute.Header().Get("ETag"))
+	}
+	// The ETag and Last-Modified move on at midnight, so yesterday's
+	// "today" is not answered with 304
+	if etag := relative.Header().Get("ETag"); !strings.Contains(etag, today.Format("20060102")) {
+		t.Errorf("expected today's date in the ETag, got %s", etag)
+	}
+	lastModified, err := http.ParseTime(relative.Header().Get("Last-Modified"))
+	if err != nil || lastModified.Before(today) {
+		t.Errorf("expected Last-Modified no earlier than %v, got %v, %v", today, lastModified, err)
+	}
+}
+
+func TestSearch_IndexFollowsChanges(t *testing.T) {
+	h := setupSearchHandler(t)
+
+	if _, page := searchPage(t, h, "q=abs"); len(page.Items) != 0 {
+		t.Fatalf("expected no results yet, got %v", resultNames(page.Items))
+	}
+
+	// Metadata edits are picked up
+	if w := callMetadata(h.PatchMetadata, http.MethodPatch, "benchy_2024-07-01_10-00-00.mp4", `{"tags": ["ABS"]}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if _, page := searchPage(t, h, "q=abs"); len(page.Items) != 1 {
+		t.Errorf("expected the new tag to be searchable, got %v", resultNames(page.Items))
+	}
+
+	// So are catalog changes
+	if err := os.WriteFile(filepath.Join(h.dir, "abs_case_2024-09-01_10-00-00.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.catalog.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, page := searchPage(t, h, "q=abs"); len(page.Items) != 2 {
+		t.Errorf("expected the new video to be searchable, got %v", resultNames(page.Items))
+	}
+
+	// An unchanged catalog reuses the same build
+	first, _ := h.search.current()
+	second, _ := h.search.current()
+	if first != second {
+		t.Error("expected the index to be reused when nothing changed")
+	}
+}
+
+func TestSearch_InvalidQuery(t *testing.T) {
+	h := setupSearchHandler(t)
+
+	for _, query := range []string{"", "q=", "q=+", "q=pla&limit=0", "q=pla&limit=abc"} {
+		if code, _ := searchPage(t, h, query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, code)
+		}
+	}
+
+	// Punctuation alone is a valid query with nothing to match
+	if code, page := searchPage(t, h, "q=---"); code != http.StatusOK || len(page.Items) != 0 {
+		t.Errorf("expected empty results, got %d %v", code, resultNames(page.Items))
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+// libraryStats computes statistics over a catalog snapshot. Timelapses are
+// bucketed by their date in the location it was parsed in, which is the
+// printer's.
+func libraryStats(items []models.Timelapse) models.LibraryStats {
+	stats := models.LibraryStats{Count: len(items)}
+	days := make(map[string]*models.PeriodCount)
+	weeks := make(map[string]*models.PeriodCount)
+	months := make(map[string]*models.PeriodCount)
+	formats := make(map[string]*models.FormatCount)
+
+	for _, t := range items {
+		stats.TotalBytes += t.Size
+		if stats.Oldest.IsZero() || t.Date.Before(stats.Oldest) {
+			stats.Oldest = t.Date
+		}
+		if t.Date.After(stats.Newest) {
+			stats.Newest = t.Date
+		}
+
+		year, week := t.Date.ISOWeek()
+		addPeriod(days, t.Date.Format("2006-01-02"), t.Size)
+		addPeriod(weeks, fmt.Sprintf("%04d-W%02d", year, week), t.Size)
+		addPeriod(months, t.Date.Format("2006-01"), t.Size)
+
+		format := strings.TrimPrefix(strings.ToLower(path.Ext(t.Filename)), ".")
+		f, ok := formats[format]
+		if !ok {
+			f = &models.FormatCount{Format: format}
+			formats[format] = f
+		}
+		f.Count++
+		f.Bytes += t.Size
+	}
+	if stats.Count > 0 {
+		stats.AverageBytes = stats.TotalBytes / int64(stats.Count)
+	}
+
+	stats.PerDay = sortedPeriods(days)
+	stats.PerWeek = sortedPeriods(weeks)
+	stats.PerMonth = sortedPeriods(months)
+	stats.Formats = make([]models.FormatCount, 0, len(formats))
+	for _, f := range formats {
+		stats.Formats = append(stats.Formats, *f)
+	}
+	slices.SortFunc(stats.Formats, func(a, b models.FormatCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Format, b.Format)
+	})
+	return stats
+}
+
+func addPeriod(periods map[string]*models.PeriodCount, key string, size int64) {
+	p, ok := periods[key]
+	if !ok {
+		p = &models.PeriodCount{Period: key}
+		periods[key] = p
+	}
+	p.Count++
+	p.Bytes += size
+}
+
+// sortedPeriods returns the periods oldest first; every key format used
+// sorts chronologically as a string.
+func sortedPeriods(periods map[string]*models.PeriodCount) []models.PeriodCount {
+	sorted := make([]models.PeriodCount, 0, len(periods))
+	for _, p := range periods {
+		sorted = append(sorted, *p)
+	}
+	slices.SortFunc(sorted, func(a, b models.PeriodCount) int {
+		return strings.Compare(a.Period, b.Period)
+	})
+	return sorted
+}
+
+// Stats summarises the library from the catalog, along with how full the
+// filesystem holding the timelapse directory is.
+func (h *TimelapseHandler) Stats(c *gin.Context) {
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+
+	stats := libraryStats(snapshot.Items)
+	stats.Generation = snapshot.Generation
+	usage, err := diskUsage(h.dir)
+	switch {
+	case err == nil:
+		stats.Storage = &usage
+	case !errors.Is(err, errors.ErrUnsupported):
+		log.Printf("stats: failed to read filesystem usage for %s: %v", h.dir, err)
+	}
+	c.JSON(http.StatusOK, stats)
+}
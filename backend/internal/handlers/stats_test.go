@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func TestStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := setupQueryDir(t)
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-08-12_10-00-00.mp4"), make([]byte, 600), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	h.Stats(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats models.LibraryStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if stats.Count != 6 || stats.TotalBytes != 2100 || stats.AverageBytes != 350 {
+		t.Errorf("unexpected totals %d files, %d bytes, %d average", stats.Count, stats.TotalBytes, stats.AverageBytes)
+	}
+	if want := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC); !stats.Oldest.Equal(want) {
+		t.Errorf("expected oldest %v, got %v", want, stats.Oldest)
+	}
+	if want := time.Date(2024, 8, 12, 10, 0, 0, 0, time.UTC); !stats.Newest.Equal(want) {
+		t.Errorf("expected newest %v, got %v", want, stats.Newest)
+	}
+
+	if len(stats.PerDay) != 6 || stats.PerDay[0] != (models.PeriodCount{Period: "2024-07-01", Count: 1, Bytes: 300}) {
+		t.Errorf("unexpected days %+v", stats.PerDay)
+	}
+	wantWeeks := []models.PeriodCount{{Period: "2024-W27", Count: 5, Bytes: 1500}, {Period: "2024-W33", Count: 1, Bytes: 600}}
+	if len(stats.PerWeek) != 2 || stats.PerWeek[0] != wantWeeks[0] || stats.PerWeek[1] != wantWeeks[1] {
+		t.Errorf("expected weeks %+v, got %+v", wantWeeks, stats.PerWeek)
+	}
+	if len(stats.PerMonth) != 2 || stats.PerMonth[0].Period != "2024-07" || stats.PerMonth[1].Period != "2024-08" {
+		t.Errorf("unexpected months %+v", stats.PerMonth)
+	}
+
+	wantFormats := []models.FormatCount{{Format: "mp4", Count: 4, Bytes: 1200}, {Format: "avi", Count: 1, Bytes: 400}, {Format: "mkv", Count: 1, Bytes: 500}}
+	if len(stats.Formats) != 3 || stats.Formats[0] != wantFormats[0] || stats.Formats[1] != wantFormats[1] || stats.Formats[2] != wantFormats[2] {
+		t.Errorf("expected formats %+v, got %+v", wantFormats, stats.Formats)
+	}
+
+	if stats.Storage == nil || stats.Storage.TotalBytes <= 0 || stats.Storage.FreeBytes > stats.Storage.TotalBytes {
+		t.Errorf("unexpected storage %+v", stats.Storage)
+	}
+}
+
+func TestStats_Empty(t *testing.T) {
+	stats := libraryStats(nil)
+	if stats.Count != 0 || stats.AverageBytes != 0 || !stats.Oldest.IsZero() || stats.PerDay == nil || stats.Formats == nil {
+		t.Errorf("unexpected stats for an empty library %+v", stats)
+	}
+}
@@ -11,6 +11,10 @@ import (
 	"github.com/codyseavey/3d-printer/backend/internal/models"
 )
 
+// streamStaleAfter is how long after the last playlist write the stream
+// counts as offline.
+const streamStaleAfter = 30 * time.Second
+
 type StreamHandler struct {
 	m3u8Path string
 }
@@ -20,22 +24,35 @@ func NewStreamHandler(m3u8Path string) *StreamHandler {
 }
 
 func (h *StreamHandler) Status(c *gin.Context) {
+	status := h.Current()
+	// The status changes when the playlist is written and again when it
+	// goes stale
+	changed := status.LastUpdated
+	if !status.Online && !changed.IsZero() {
+		changed = changed.Add(streamStaleAfter)
+	}
+	if notModified(c, "", changed) {
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Current reports whether the playlist has been written recently.
+func (h *StreamHandler) Current() models.StreamStatus {
 	info, err := os.Stat(h.m3u8Path)
 	if err != nil {
 		log.Printf("stream status: cannot stat %s: %v", h.m3u8Path, err)
-		c.JSON(http.StatusOK, models.StreamStatus{
+		return models.StreamStatus{
 			Online:      false,
 			LastUpdated: time.Time{},
-		})
-		return
+		}
 	}
 
 	mtime := info.ModTime()
-	staleThreshold := 30 * time.Second
-	online := time.Since(mtime) < staleThreshold
+	online := time.Since(mtime) < streamStaleAfter
 
-	c.JSON(http.StatusOK, models.StreamStatus{
+	return models.StreamStatus{
 		Online:      online,
 		LastUpdated: mtime,
-	})
+	}
 }
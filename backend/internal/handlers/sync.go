@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ftps"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/tlspin"
+)
+
+const (
+	syncDirName      = ".sync"
+	syncStateName    = "state.json"
+	syncPartialsName = "partial"
+	syncHistoryName  = "history.json"
+	syncPinsName     = "pins.json"
+
+	// DefaultSyncInterval is how often the printer is mirrored.
+	DefaultSyncInterval = time.Hour
+
+	// DefaultSyncRemoteDir is where Bambu printers write timelapses.
+	DefaultSyncRemoteDir = "/timelapse"
+
+	// DefaultSyncHistory is how many runs the sync history keeps.
+	DefaultSyncHistory = 50
+)
+
+// SyncConfig describes the printer's FTPS server to mirror timelapses
+// from. Port defaults to the implicit FTPS port and RemoteDir to
+// DefaultSyncRemoteDir.
+type SyncConfig struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port,omitempty"`
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	RemoteDir string `json:"remoteDir,omitempty"`
+}
+
+// syncedEntry records a file that has been downloaded in full, so it is
+// not fetched again after it is deleted, offloaded or quarantined here.
+type syncedEntry struct {
+	Size     int64     `json:"size"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// Syncer mirrors the printer's timelapse folder, thumbnails included, into
+// the timelapse directory. Downloads go to .sync/partial first and are
+// renamed into place once complete; an interrupted download resumes where
+// it stopped on the next run. Finished runs are kept in .sync/history.json.
+type Syncer struct {
+	dir          string
+	catalog      *Catalog
+	config       SyncConfig
+	historyLimit int
+	pins         *tlspin.Store
+
+	// mu serialises runs.
+	mu sync.Mutex
+
+	// state guards what the status reports.
+	state    sync.Mutex
+	ctx      context.Context
+	current  chan struct{}
+	progress *models.SyncProgress
+	history  []models.SyncRun
+	loaded   bool
+	nextRun  time.Time
+}
+
+func NewSyncer(dir string, catalog *Catalog) *Syncer {
+	s := &Syncer{dir: dir, catalog: catalog, historyLimit: DefaultSyncHistory}
+	s.pins = tlspin.New(s.path(syncPinsName))
+	return s
+}
+
+// Enabled reports whether a printer to sync from is configured.
+func (s *Syncer) Enabled() bool {
+	return s.config.Host != ""
+}
+
+// path returns the filesystem path of a slash-separated path in the sync
+// folder.
+func (s *Syncer) path(elem ...string) string {
+	return filepath.Join(s.dir, syncDirName, filepath.FromSlash(path.Join(elem...)))
+}
+
+// loadHistory reads the history file on first use. The caller holds
+// s.state.
+func (s *Syncer) loadHistory() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	data, err := os.ReadFile(s.path(syncHistoryName))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &s.history)
+	}
+	if err != nil {
+		log.Printf("sync: failed to read history: %v", err)
+	}
+}
+
+// record adds a finished run to the front of the history.
+func (s *Syncer) record(run models.SyncRun) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.loadHistory()
+	s.history = slices.Insert(s.history, 0, run)
+	if len(s.history) > s.historyLimit {
+		s.history = s.history[:s.historyLimit]
+	}
+	data, err := json.MarshalIndent(s.history, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(s.path(), 0o755)
+	}
+	if err == nil {
+		tmp := s.path(syncHistoryName + ".tmp")
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, s.path(syncHistoryName))
+		}
+	}
+	if err != nil {
+		log.Printf("sync: failed to write history: %v", err)
+	}
+}
+
+// History returns up to limit runs, most recent first.
+func (s *Syncer) History(limit int) []models.SyncRun {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.loadHistory()
+	runs := s.history
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return slices.Clone(runs)
+}
+
+// Status reports the run in flight, the last finished one and when the
+// next is due.
+func (s *Syncer) Status() models.SyncStatus {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.loadHistory()
+	status := models.SyncStatus{Enabled: s.Enabled(), Running: s.current != nil || s.progress != nil, NextRun: s.nextRun}
+	if s.progress != nil {
+		progress := *s.progress
+		status.Progress = &progress
+	}
+	if len(s.history) > 0 {
+		last := s.history[0]
+		status.LastRun = &last
+	}
+	for _, run := range s.history {
+		if run.Error != "" {
+			status.LastError, status.LastErrorAt = run.Error, run.FinishedAt
+			break
+		}
+	}
+	return status
+}
+
+// setProgress updates the progress of the run in flight.
+func (s *Syncer) setProgress(update func(*models.SyncProgress)) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	if s.progress != nil {
+		update(s.progress)
+	}
+}
+
+func (s *Syncer) readState() (map[string]syncedEntry, error) {
+	state := make(map[string]syncedEntry)
+	data, err := os.ReadFile(s.path(syncStateName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("corrupt sync state: %w", err)
+	}
+	return state, nil
+}
+
+func (s *Syncer) writeState(state map[string]syncedEntry) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.path(), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path(syncStateName + ".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(syncStateName))
+}
+
+func (s *Syncer) dial(ctx context.Context) (*ftps.Conn, error) {
+	port := s.config.Port
+	if port == 0 {
+		port = ftps.DefaultPort
+	}
+	// Printers present a self-signed certificate, so its key is pinned on
+	// first use instead of being verified against a CA
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(port))
+	return ftps.Dial(ctx, ftps.Config{
+		Addr:     addr,
+		User:     s.config.User,
+		Password: s.config.Password,
+		TLS:      s.pins.Config(addr),
+	})
+}
+
+// Sync mirrors new and changed files. A file that fails is left for the
+// next run while the rest carry on; losing the connection ends the run.
+func (s *Syncer) Sync(ctx context.Context) (models.SyncRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := models.SyncRun{StartedAt: time.Now(), Files: make([]models.SyncedFile, 0)}
+	s.state.Lock()
+	s.progress = &models.SyncProgress{StartedAt: run.StartedAt}
+	s.state.Unlock()
+
+	err := s.sync(ctx, &run)
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if len(run.Files) > 0 {
+		if err := s.catalog.Rescan(); err != nil {
+			log.Printf("sync: failed to rescan: %v", err)
+		}
+	}
+
+	s.record(run)
+	s.state.Lock()
+	s.progress = nil
+	s.state.Unlock()
+	return run, err
+}
+
+// Start begins a sync in the background unless one is already running,
+// and returns a channel closed when the run in flight finishes. Runs
+// started here outlive the request that asked for them, until the Run
+// loop's context ends.
+func (s *Syncer) Start() (done <-chan struct{}, started bool) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	if s.current != nil {
+		return s.current, false
+	}
+
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ch := make(chan struct{})
+	s.current = ch
+	go func() {
+		if run, err := s.Sync(ctx); err != nil {
+			log.Printf("sync: run failed: %v", err)
+		} else if len(run.Files) > 0 {
+			log.Printf("sync: downloaded %d files", len(run.Files))
+		}
+		s.state.Lock()
+		s.current = nil
+		s.state.Unlock()
+		close(ch)
+	}()
+	return ch, true
+}
+
+func (s *Syncer) sync(ctx context.Context, run *models.SyncRun) error {
+	state, err := s.readState()
+	if err != nil {
+		return err
+	}
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+
+	remoteDir := s.config.RemoteDir
+	if remoteDir == "" {
+		remoteDir = DefaultSyncRemoteDir
+	}
+	m := &mirror{s: s, conn: conn, state: state, run: run}
+	err = m.dir(ctx, remoteDir, "", 0)
+	if err := s.writeState(state); err != nil {
+		log.Printf("sync: failed to save state: %v", err)
+	}
+	if err == nil && m.failed > 0 {
+		err = fmt.Errorf("%d files failed, last: %w", m.failed, m.lastErr)
+	}
+	return err
+}
+
+// mirror is one run's walk of the printer's folders.
+type mirror struct {
+	s       *Syncer
+	conn    *ftps.Conn
+	state   map[string]syncedEntry
+	run     *models.SyncRun
+	failed  int
+	lastErr error
+}
+
+// dir mirrors the remote folder into the local folder rel, files first so
+// thumbnails and videos arrive before deeper folders are walked.
+func (m *mirror) dir(ctx context.Context, remote, rel string, depth int) error {
+	entries, err := m.conn.List(ctx, remote)
+	if err != nil {
+		if depth > 0 && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	slices.SortFunc(entries, func(a, b ftps.Entry) int { return strings.Compare(a.Name, b.Name) })
+
+	var subdirs []ftps.Entry
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name, ".") || strings.ContainsAny(e.Name, "/\\\x00") {
+			continue
+		}
+		if e.Dir {
+			subdirs = append(subdirs, e)
+			continue
+		}
+		name := path.Join(rel, e.Name)
+		if !m.wanted(name) {
+			continue
+		}
+		if err := m.file(ctx, path.Join(remote, e.Name), name, e); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("sync: failed to download %s: %v", name, err)
+			m.failed++
+			m.lastErr = err
+		}
+	}
+
+	// The catalog reads every scanned folder's thumbnails whatever the
+	// depth, and nothing below them
+	if path.Base(rel) == thumbnailDirName {
+		return nil
+	}
+	for _, e := range subdirs {
+		if e.Name != thumbnailDirName && depth >= m.s.catalog.depth {
+			continue
+		}
+		if err := m.dir(ctx, path.Join(remote, e.Name), path.Join(rel, e.Name), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wanted reports whether name is a video or a thumbnail the catalog would
+// pick up.
+func (m *mirror) wanted(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if path.Base(path.Dir(name)) == thumbnailDirName {
+		return imageExtensions[ext]
+	}
+	return videoExtensions[ext] && validFilename(name)
+}
+
+// file downloads one file unless it is already here or was synced before.
+func (m *mirror) file(ctx context.Context, remote, name string, e ftps.Entry) error {
+	if synced, ok := m.state[name]; ok && synced.Size == e.Size {
+		return nil
+	}
+	dst := m.s.catalog.abs(name)
+	// Files mirrored before the state was kept
+	if info, err := os.Stat(dst); err == nil && info.Size() == e.Size {
+		m.state[name] = syncedEntry{Size: e.Size, SyncedAt: time.Now()}
+		return nil
+	}
+
+	partial := m.s.path(syncPartialsName, name)
+	if err := os.MkdirAll(filepath.Dir(partial), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Resume, unless the partial file is from a different version
+	offset := info.Size()
+	if offset > e.Size {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	if offset < e.Size {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		r, err := m.conn.Retrieve(ctx, remote, offset)
+		if err != nil {
+			return err
+		}
+		m.s.setProgress(func(p *models.SyncProgress) {
+			p.File, p.FileBytes, p.FileSize = name, offset, e.Size
+		})
+		n, err := io.Copy(progressWriter{f, m.s}, r)
+		m.run.Bytes += n
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if offset+n != e.Size {
+			return fmt.Errorf("downloaded %d bytes, expected %d", offset+n, e.Size)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if !e.ModTime.IsZero() {
+		if err := os.Chtimes(partial, e.ModTime, e.ModTime); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return err
+	}
+	log.Printf("sync: downloaded %s", name)
+	m.state[name] = syncedEntry{Size: e.Size, SyncedAt: time.Now()}
+	m.run.Files = append(m.run.Files, models.SyncedFile{Filename: name, Size: e.Size})
+	m.s.setProgress(func(p *models.SyncProgress) { p.Files++ })
+	return nil
+}
+
+// progressWriter counts downloaded bytes into the sync progress.
+type progressWriter struct {
+	w io.Writer
+	s *Syncer
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.s.setProgress(func(p *models.SyncProgress) {
+		p.FileBytes += int64(n)
+		p.Bytes += int64(n)
+	})
+	return n, err
+}
+
+// Run mirrors the printer until ctx is cancelled, waiting interval after
+// each run finishes. It returns immediately when no printer is configured.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	if !s.Enabled() {
+		return
+	}
+	s.state.Lock()
+	s.ctx = ctx
+	s.state.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		done, _ := s.Start()
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+		}
+
+		s.state.Lock()
+		s.nextRun = time.Now().Add(interval)
+		s.state.Unlock()
+		timer.Reset(interval)
+	}
+}
+
+func (h *TimelapseHandler) SyncStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.syncer.Status())
+}
+
+func (h *TimelapseHandler) SyncHistory(c *gin.Context) {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, h.syncer.History(limit))
+}
+
+// RunSync starts a sync now instead of waiting for the schedule. A request
+// while one is running joins it rather than starting another. With
+// wait=true the response waits for the run to finish.
+func (h *TimelapseHandler) RunSync(c *gin.Context) {
+	if !h.syncer.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sync is not configured"})
+		return
+	}
+	wait := false
+	if v := c.Query("wait"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait"})
+			return
+		}
+		wait = b
+	}
+
+	done, _ := h.syncer.Start()
+	if !wait {
+		c.JSON(http.StatusAccepted, h.syncer.Status())
+		return
+	}
+
+	clearWriteDeadline(c, "sync")
+	select {
+	case <-done:
+	case <-c.Request.Context().Done():
+		return
+	}
+	c.JSON(http.StatusOK, h.syncer.Status())
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ftps/ftpstest"
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/tlspin"
+)
+
+func setupPrinterFTP(t *testing.T) (*ftpstest.Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string][]byte{
+		"timelapse/video_2024-07-01_10-00-00.mp4":           mediatest.MP4(mediatest.Video{MdatSize: 50_000}),
+		"timelapse/video_2024-07-02_10-00-00.mkv":           mediatest.Matroska(mediatest.Video{}),
+		"timelapse/thumbnail/video_2024-07-01_10-00-00.jpg": []byte("thumb"),
+		"timelapse/notes.txt":                               []byte("not a timelapse"),
+		"timelapse/.hidden.mp4":                             []byte("hidden"),
+	}
+	mtime := time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)
+	writeFiles(t, root, files)
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ftpstest.NewServer(t, root), root
+}
+
+func syncConfig(t *testing.T, s *ftpstest.Server) SyncConfig {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+	return SyncConfig{Host: host, Port: n, User: ftpstest.User, Password: ftpstest.Password}
+}
+
+func TestSync(t *testing.T) {
+	server, root := setupPrinterFTP(t)
+	tmpDir := t.TempDir()
+	h := NewTimelapseHandler(tmpDir, WithSync(syncConfig(t, server)))
+	defer h.Close()
+
+	run, err := h.syncer.Sync(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"thumbnail/video_2024-07-01_10-00-00.jpg", "video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mkv"}
+	var got []string
+	for _, f := range run.Files {
+		got = append(got, f.Filename)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v downloaded, got %v", want, got)
+	}
+	for _, name := range want {
+		local, err := os.ReadFile(filepath.Join(tmpDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, _ := os.ReadFile(filepath.Join(root, "timelapse", filepath.FromSlash(name)))
+		if !bytes.Equal(local, remote) {
+			t.Errorf("%s differs from the printer's copy", name)
+		}
+	}
+	info, err := os.Stat(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4"))
+	if err != nil || !info.ModTime().Equal(time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the printer's modification time, got %v", info.ModTime())
+	}
+
+	_, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	if len(page.Items) != 2 || page.Items[0].ThumbnailURL == "" {
+		t.Errorf("expected synced timelapses in the catalog, got %+v", page.Items)
+	}
+
+	// Nothing new, and deleted files stay deleted
+	if err := os.Remove(filepath.Join(tmpDir, "video_2024-07-02_10-00-00.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	run, err = h.syncer.Sync(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Files) != 0 || run.Bytes != 0 {
+		t.Errorf("expected nothing to download, got %+v", run)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "video_2024-07-02_10-00-00.mkv")); !os.IsNotExist(err) {
+		t.Errorf("expected the deleted video to stay deleted, got %v", err)
+	}
+
+	// A new print is picked up
+	if err := os.WriteFile(filepath.Join(root, "timelapse", "video_2024-07-03_10-00-00.mp4"), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run, err = h.syncer.Sync(t.Context())
+	if err != nil || len(run.Files) != 1 || run.Files[0].Filename != "video_2024-07-03_10-00-00.mp4" {
+		t.Errorf("expected the new video to download, got %+v, %v", run, err)
+	}
+}
+
+func TestSync_ScanDepthZero(t *testing.T) {
+	server, root := setupPrinterFTP(t)
+	writeFiles(t, root, map[string][]byte{"timelapse/old/video_2024-06-01_10-00-00.mp4": mediatest.MP4(mediatest.Video{})})
+	h := NewTimelapseHandler(t.TempDir(), WithScanDepth(0), WithSync(syncConfig(t, server)))
+	defer h.Close()
+
+	run, err := h.syncer.Sync(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Thumbnails are still mirrored, subfolders the catalog skips are not
+	var got []string
+	for _, f := range run.Files {
+		got = append(got, f.Filename)
+	}
+	slices.Sort(got)
+	want := []string{"thumbnail/video_2024-07-01_10-00-00.jpg", "video_2024-07-01_10-00-00.mp4", "video_2024-07-02_10-00-00.mkv"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v downloaded, got %v", want, got)
+	}
+}
+
+func TestSync_Resume(t *testing.T) {
+	server, root := setupPrinterFTP(t)
+	tmpDir := t.TempDir()
+	h := NewTimelapseHandler(tmpDir, WithSync(syncConfig(t, server)))
+	defer h.Close()
+
+	// The thumbnail folder is walked after the videos, so the first
+	// download is the MP4
+	server.CutAfter.Store(1000)
+	run, err := h.syncer.Sync(t.Context())
+	if err == nil || run.Error == "" {
+		t.Fatal("expected the interrupted download to fail the run")
+	}
+	if slices.ContainsFunc(run.Files, func(f models.SyncedFile) bool { return f.Filename == "video_2024-07-01_10-00-00.mp4" }) {
+		t.Fatalf("expected the interrupted video not to be listed, got %+v", run.Files)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected no partial video in the timelapse directory, got %v", err)
+	}
+	partial, err := os.Stat(filepath.Join(tmpDir, syncDirName, syncPartialsName, "video_2024-07-01_10-00-00.mp4"))
+	if err != nil || partial.Size() != 1000 {
+		t.Fatalf("expected 1000 bytes kept to resume, got %v", err)
+	}
+
+	run, err = h.syncer.Sync(t.Context())
+	if err != nil || len(run.Files) != 1 || run.Bytes != printerFileSize(t, root)-1000 {
+		t.Fatalf("expected the video to resume, got %+v, %v", run, err)
+	}
+	if !slices.Contains(server.Commands(), "REST 1000") {
+		t.Errorf("expected the download to resume at 1000, got %v", server.Commands())
+	}
+	local, _ := os.ReadFile(filepath.Join(tmpDir, "video_2024-07-01_10-00-00.mp4"))
+	remote, _ := os.ReadFile(filepath.Join(root, "timelapse", "video_2024-07-01_10-00-00.mp4"))
+	if !bytes.Equal(local, remote) {
+		t.Error("resumed video differs from the printer's copy")
+	}
+}
+
+func printerFileSize(t *testing.T, root string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(root, "timelapse", "video_2024-07-01_10-00-00.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestSync_BadLogin(t *testing.T) {
+	server, _ := setupPrinterFTP(t)
+	cfg := syncConfig(t, server)
+	cfg.Password = "wrong"
+	h := NewTimelapseHandler(t.TempDir(), WithSync(cfg))
+	defer h.Close()
+
+	if _, err := h.syncer.Sync(t.Context()); err == nil {
+		t.Fatal("expected a login error")
+	}
+}
+
+func TestSync_History(t *testing.T) {
+	server, _ := setupPrinterFTP(t)
+	tmpDir := t.TempDir()
+	h := NewTimelapseHandler(tmpDir, WithSync(syncConfig(t, server)), WithSyncHistory(2))
+
+	if status := h.syncer.Status(); !status.Enabled || status.Running || status.LastRun != nil {
+		t.Fatalf("unexpected status before the first run: %+v", status)
+	}
+	first, err := h.syncer.Sync(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CutAfter.Store(10)
+	if err := os.WriteFile(filepath.Join(server.Root, "timelapse", "video_2024-07-03_10-00-00.mp4"), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.syncer.Sync(t.Context()); err == nil {
+		t.Fatal("expected the cut download to fail")
+	}
+	if _, err := h.syncer.Sync(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	status := h.syncer.Status()
+	if status.Running || status.Progress != nil || status.LastRun == nil || status.LastRun.Error != "" {
+		t.Errorf("expected the last run to have succeeded, got %+v", status)
+	}
+	if status.LastError == "" || status.LastErrorAt.IsZero() {
+		t.Errorf("expected the failed run's error, got %+v", status)
+	}
+	h.Close()
+
+	// The history survives a restart and keeps only the newest two runs
+	h = NewTimelapseHandler(tmpDir, WithSync(syncConfig(t, server)), WithSyncHistory(2))
+	defer h.Close()
+	runs := h.syncer.History(0)
+	if len(runs) != 2 || runs[1].Error == "" || runs[0].Error != "" {
+		t.Fatalf("expected the last two runs, newest first, got %+v", runs)
+	}
+	if slices.ContainsFunc(runs, func(r models.SyncRun) bool { return r.StartedAt.Equal(first.StartedAt) }) {
+		t.Error("expected the oldest run to be dropped")
+	}
+	if runs := h.syncer.History(1); len(runs) != 1 {
+		t.Errorf("expected one run, got %d", len(runs))
+	}
+}
+
+func TestSync_Start(t *testing.T) {
+	server, _ := setupPrinterFTP(t)
+	h := NewTimelapseHandler(t.TempDir(), WithSync(syncConfig(t, server)))
+	defer h.Close()
+
+	// Hold the run back so the second trigger finds it in flight
+	h.syncer.mu.Lock()
+	done, started := h.syncer.Start()
+	again, startedAgain := h.syncer.Start()
+	if !started || startedAgain || done != again {
+		t.Fatalf("expected the second trigger to join the first run, got %v and %v", started, startedAgain)
+	}
+	if !h.syncer.Status().Running {
+		t.Error("expected the status to report the run")
+	}
+	h.syncer.mu.Unlock()
+	<-done
+
+	runs := h.syncer.History(0)
+	if len(runs) != 1 || len(runs[0].Files) != 3 {
+		t.Fatalf("expected a single run downloading 3 files, got %+v", runs)
+	}
+	if _, started := h.syncer.Start(); !started {
+		t.Error("expected a new run once the last one finished")
+	}
+}
+
+func TestSync_PinnedCertificate(t *testing.T) {
+	server, root := setupPrinterFTP(t)
+	tmpDir := t.TempDir()
+	h := NewTimelapseHandler(tmpDir, WithSync(syncConfig(t, server)))
+	defer h.Close()
+
+	if _, err := h.syncer.Sync(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	pins, err := h.syncer.pins.List()
+	if err != nil || len(pins) != 1 || pins[0].Addr != server.Addr {
+		t.Fatalf("expected the printer to be pinned on first use, got %+v, %v", pins, err)
+	}
+
+	// Something else answers on the printer's address
+	server.Close()
+	impostor := ftpstest.NewServerWithCertificate(t, root, server.Addr, ftpstest.Certificate(t))
+	if err := os.WriteFile(filepath.Join(root, "timelapse", "video_2024-07-03_10-00-00.mp4"), mediatest.MP4(mediatest.Video{}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run, err := h.syncer.Sync(t.Context())
+	if !errors.Is(err, tlspin.ErrMismatch) || len(run.Files) != 0 {
+		t.Fatalf("expected the changed certificate to be refused, got %+v, %v", run, err)
+	}
+	if slices.Contains(impostor.Commands(), "PASS") {
+		t.Error("expected the password not to be sent")
+	}
+
+	pins, _ = h.syncer.pins.List()
+	if _, err := h.syncer.pins.Approve(server.Addr, pins[0].Pending); err != nil {
+		t.Fatal(err)
+	}
+	run, err = h.syncer.Sync(t.Context())
+	if err != nil || len(run.Files) != 1 {
+		t.Errorf("expected the sync to resume once approved, got %+v, %v", run, err)
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	defaultPageLimit = 24
+	maxPageLimit     = 200
+)
+
+const (
+	sortNewest = "newest"
+	sortOldest = "oldest"
+	sortSize   = "size"
+)
+
+// listQuery holds the parsed pagination, sorting and filter parameters
+// accepted by GET /api/timelapses.
+type listQuery struct {
+	page    int
+	limit   int
+	sort    string
+	minSize int64
+	from    time.Time
+	to      time.Time
+	exts    map[string]bool
+	folder  string
+	health  map[string]bool
+
+	// Metadata filters
+	tags      []string
+	starred   *bool
+	minRating int
+}
+
+func parseListQuery(values url.Values) (listQuery, error) {
+	q := listQuery{page: 1, limit: defaultPageLimit, sort: sortNewest}
+
+	if v := values.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid page %q", v)
+		}
+		q.page = n
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return q, fmt.Errorf("invalid limit %q (must be 1-%d)", v, maxPageLimit)
+		}
+		q.limit = n
+	}
+
+	if v := values.Get("sort"); v != "" {
+		switch v {
+		case sortNewest, sortOldest, sortSize:
+			q.sort = v
+		default:
+			return q, fmt.Errorf("invalid sort %q (must be newest, oldest or size)", v)
+		}
+	}
+
+	if v := values.Get("min_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid min_size %q", v)
+		}
+		q.minSize = n
+	}
+
+	if v := values.Get("from"); v != "" {
+		t, _, err := parseQueryDate(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid from %q", v)
+		}
+		q.from = t
+	}
+
+	if v := values.Get("to"); v != "" {
+		t, dateOnly, err := parseQueryDate(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid to %q", v)
+		}
+		// A bare date includes the whole day
+		if dateOnly {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		q.to = t
+	}
+
+	if v := values.Get("ext"); v != "" {
+		q.exts = make(map[string]bool)
+		for _, ext := range strings.Split(v, ",") {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if !videoExtensions[ext] {
+				return q, fmt.Errorf("invalid ext %q", ext)
+			}
+			q.exts[ext] = true
+		}
+	}
+
+	if v := values.Get("health"); v != "" {
+		q.health = make(map[string]bool)
+		for _, status := range strings.Split(v, ",") {
+			switch status = strings.TrimSpace(status); status {
+			case models.HealthHealthy, models.HealthIncomplete, models.HealthCorrupt:
+				q.health[status] = true
+			default:
+				return q, fmt.Errorf("invalid health %q", status)
+			}
+		}
+	}
+
+	for _, v := range values["tag"] {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				q.tags = append(q.tags, tag)
+			}
+		}
+	}
+
+	if v := values.Get("starred"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid starred %q", v)
+		}
+		q.starred = &b
+	}
+
+	if v := values.Get("min_rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 5 {
+			return q, fmt.Errorf("invalid min_rating %q (must be 1-5)", v)
+		}
+		q.minRating = n
+	}
+
+	if v := strings.Trim(values.Get("folder"), "/"); v != "" {
+		if !validFilename(v) {
+			return q, fmt.Errorf("invalid folder %q", v)
+		}
+		q.folder = v
+	}
+
+	return q, nil
+}
+
+// parseQueryDate accepts either a bare date (2024-07-24) or an RFC 3339
+// timestamp, and reports whether the value was a bare date.
+func parseQueryDate(v string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+func (q listQuery) matches(t models.Timelapse) bool {
+	if t.Size < q.minSize {
+		return false
+	}
+	if !q.from.IsZero() && t.Date.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && t.Date.After(q.to) {
+		return false
+	}
+	if q.exts != nil && !q.exts[strings.ToLower(filepath.Ext(t.Filename))] {
+		return false
+	}
+	if q.health != nil && !q.health[t.Health] {
+		return false
+	}
+	// A folder includes everything below it
+	if q.folder != "" && t.Folder != q.folder && !strings.HasPrefix(t.Folder, q.folder+"/") {
+		return false
+	}
+
+	var meta models.TimelapseMeta
+	if t.TimelapseMeta != nil {
+		meta = *t.TimelapseMeta
+	}
+	// Every requested tag must be present
+	for _, tag := range q.tags {
+		if !slices.ContainsFunc(meta.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+	if q.starred != nil && meta.Starred != *q.starred {
+		return false
+	}
+	if meta.Rating < q.minRating {
+		return false
+	}
+	return true
+}
+
+// apply filters, sorts and paginates items into a page. Links to the
+// neighbouring pages reuse base with only the page parameter replaced.
+func (q listQuery) apply(items []models.Timelapse, base *url.URL) models.TimelapsePage {
+	filtered := make([]models.Timelapse, 0, len(items))
+	for _, t := range items {
+		if q.matches(t) {
+			filtered = append(filtered, t)
+		}
+	}
+
+	sortTimelapses(filtered, q.sort)
+
+	totalPages := int(math.Ceil(float64(len(filtered)) / float64(q.limit)))
+	if totalPages < 1 {
+		totalPages = 1
+	}
+
+	start := min((q.page-1)*q.limit, len(filtered))
+	end := min(start+q.limit, len(filtered))
+
+	page := models.TimelapsePage{
+		Items:           filtered[start:end],
+		Page:            q.page,
+		Limit:           q.limit,
+		Total:           len(filtered),
+		TotalUnfiltered: len(items),
+		TotalPages:      totalPages,
+	}
+	if q.page < totalPages {
+		page.Next = pageLink(base, q.page+1)
+	}
+	if q.page > 1 {
+		page.Prev = pageLink(base, min(q.page-1, totalPages))
+	}
+	return page
+}
+
+// sortTimelapses orders items in place. Equal dates fall back to the
+// filename so the order is stable across scans, which keeps a clip right
+// next to its source.
+func sortTimelapses(items []models.Timelapse, order string) {
+	newer := func(a, b models.Timelapse) bool {
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		return a.Filename > b.Filename
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		switch order {
+		case sortOldest:
+			return newer(items[j], items[i])
+		case sortSize:
+			if items[i].Size != items[j].Size {
+				return items[i].Size > items[j].Size
+			}
+			return newer(items[i], items[j])
+		default:
+			return newer(items[i], items[j])
+		}
+	})
+}
+
+func pageLink(base *url.URL, page int) string {
+	values := base.Query()
+	values.Set("page", strconv.Itoa(page))
+	link := url.URL{Path: base.Path, RawQuery: values.Encode()}
+	return link.String()
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func setupQueryDir(t *testing.T) string {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	files := []struct {
+		name string
+		size int
+	}{
+		{"video_2024-07-01_10-00-00.mp4", 300},
+		{"video_2024-07-02_10-00-00.mp4", 100},
+		{"video_2024-07-03_10-00-00.mkv", 500},
+		{"video_2024-07-04_10-00-00.mp4", 200},
+		{"video_2024-07-05_10-00-00.avi", 400},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, f.name), make([]byte, f.size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tmpDir
+}
+
+func listPage(t *testing.T, h *TimelapseHandler, target string) (int, models.TimelapsePage) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+
+	h.List(c)
+
+	var page models.TimelapsePage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+	}
+	return w.Code, page
+}
+
+func filenames(items []models.Timelapse) []string {
+	names := make([]string, len(items))
+	for i, t := range items {
+		names[i] = t.Filename
+	}
+	return names
+}
+
+func TestListTimelapses_Pagination(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+
+	code, page := listPage(t, h, "/api/timelapses?limit=2&page=2&sort=oldest")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	if page.Total != 5 || page.TotalUnfiltered != 5 {
+		t.Errorf("expected totals 5/5, got %d/%d", page.Total, page.TotalUnfiltered)
+	}
+	if page.TotalPages != 3 {
+		t.Errorf("expected 3 pages, got %d", page.TotalPages)
+	}
+
+	got := filenames(page.Items)
+	want := []string{"video_2024-07-03_10-00-00.mkv", "video_2024-07-04_10-00-00.mp4"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("unexpected page items: %v", got)
+	}
+
+	if page.Next != "/api/timelapses?limit=2&page=3&sort=oldest" {
+		t.Errorf("unexpected next link %q", page.Next)
+	}
+	if page.Prev != "/api/timelapses?limit=2&page=1&sort=oldest" {
+		t.Errorf("unexpected prev link %q", page.Prev)
+	}
+
+	// Last page has no next link
+	_, page = listPage(t, h, "/api/timelapses?limit=2&page=3")
+	if page.Next != "" || len(page.Items) != 1 {
+		t.Errorf("expected 1 item and no next link on last page, got %d items, next %q", len(page.Items), page.Next)
+	}
+}
+
+func TestListTimelapses_Filters(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/api/timelapses?sort=size", []string{
+			"video_2024-07-03_10-00-00.mkv",
+			"video_2024-07-05_10-00-00.avi",
+			"video_2024-07-01_10-00-00.mp4",
+			"video_2024-07-04_10-00-00.mp4",
+			"video_2024-07-02_10-00-00.mp4",
+		}},
+		{"/api/timelapses?min_size=300", []string{
+			"video_2024-07-05_10-00-00.avi",
+			"video_2024-07-03_10-00-00.mkv",
+			"video_2024-07-01_10-00-00.mp4",
+		}},
+		{"/api/timelapses?from=2024-07-02&to=2024-07-03", []string{
+			"video_2024-07-03_10-00-00.mkv",
+			"video_2024-07-02_10-00-00.mp4",
+		}},
+		{"/api/timelapses?to=2024-07-02T09:00:00Z", []string{
+			"video_2024-07-01_10-00-00.mp4",
+		}},
+		{"/api/timelapses?ext=mkv,.AVI", []string{
+			"video_2024-07-05_10-00-00.avi",
+			"video_2024-07-03_10-00-00.mkv",
+		}},
+	}
+
+	for _, tt := range tests {
+		code, page := listPage(t, h, tt.target)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", tt.target, code)
+			continue
+		}
+		got := filenames(page.Items)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+				break
+			}
+		}
+		if page.TotalUnfiltered != 5 {
+			t.Errorf("%s: expected 5 unfiltered, got %d", tt.target, page.TotalUnfiltered)
+		}
+	}
+}
+
+func TestListTimelapses_InvalidQuery(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+
+	for _, target := range []string{
+		"/api/timelapses?page=0",
+		"/api/timelapses?page=abc",
+		"/api/timelapses?limit=1000",
+		"/api/timelapses?sort=random",
+		"/api/timelapses?min_size=-1",
+		"/api/timelapses?from=yesterday",
+		"/api/timelapses?ext=txt",
+	} {
+		if code, _ := listPage(t, h, target); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, code)
+		}
+	}
+}
+
+func TestListTimelapses_FolderFilter(t *testing.T) {
+	tmpDir := setupQueryDir(t)
+	for _, f := range []string{"2024/07/video_2024-07-06_10-00-00.mp4", "2024/08/video_2024-08-01_10-00-00.mp4", "2025/video_2025-01-01_10-00-00.mp4"} {
+		p := filepath.Join(tmpDir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewTimelapseHandler(tmpDir)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"folder=2024/07", []string{"2024/07/video_2024-07-06_10-00-00.mp4"}},
+		{"folder=/2024/07/", []string{"2024/07/video_2024-07-06_10-00-00.mp4"}},
+		{"folder=2024", []string{"2024/08/video_2024-08-01_10-00-00.mp4", "2024/07/video_2024-07-06_10-00-00.mp4"}},
+		{"folder=202", []string{}},
+	}
+
+	for _, tt := range tests {
+		code, page := listPage(t, h, "/api/timelapses?"+tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.query, code)
+			continue
+		}
+		got := filenames(page.Items)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+		if page.TotalUnfiltered != 8 {
+			t.Errorf("%s: expected 8 unfiltered, got %d", tt.query, page.TotalUnfiltered)
+		}
+	}
+
+	for _, query := range []string{"folder=../etc", "folder=.trash", "folder=2024/thumbnail/x"} {
+		if code, _ := listPage(t, h, "/api/timelapses?"+query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
@@ -1,113 +1,423 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg"
 	"github.com/codyseavey/3d-printer/backend/internal/models"
+	"github.com/codyseavey/3d-printer/backend/internal/storage"
 )
 
-// filenameDateRegex matches filenames like video_2024-07-24_09-14-01.mp4
-var filenameDateRegex = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})_(\d{2}-\d{2}-\d{2})`)
+var videoExtensions = map[string]bool{".mp4": true, ".mkv": true, ".avi": true}
 
 type TimelapseHandler struct {
-	dir string
+	dir        string
+	catalog    *Catalog
+	trash      *Trash
+	retention  *Retention
+	metadata   *MetadataStore
+	search     *SearchIndex
+	transcoder *Transcoder
+	previews   *Previews
+	quarantine *Quarantine
+	remote     *Remote
+	syncer     *Syncer
 }
 
-func NewTimelapseHandler(dir string) *TimelapseHandler {
-	return &TimelapseHandler{dir: dir}
+// TimelapseOption customises a TimelapseHandler.
+type TimelapseOption func(*TimelapseHandler)
+
+// WithDateParser sets how dates are read from filenames. The default is
+// DefaultDateParser.
+func WithDateParser(p *DateParser) TimelapseOption {
+	return func(h *TimelapseHandler) { h.catalog.dates = p }
+}
+
+// WithScanDepth sets how many levels of subfolders are scanned for videos.
+// Zero scans only the top level. The default is DefaultScanDepth.
+func WithScanDepth(depth int) TimelapseOption {
+	return func(h *TimelapseHandler) { h.catalog.depth = depth }
+}
+
+// WithVideosURL sets the URL prefix the timelapse directory is served
+// from. The default is DefaultVideosURL.
+func WithVideosURL(prefix string) TimelapseOption {
+	return func(h *TimelapseHandler) { h.catalog.baseURL = strings.TrimSuffix(prefix, "/") }
+}
+
+// WithFFmpeg sets the ffmpeg binary used to transcode MKV and AVI
+// timelapses into MP4 and to generate scrubbing previews. Without one,
+// neither is available.
+func WithFFmpeg(path string) TimelapseOption {
+	return func(h *TimelapseHandler) {
+		h.transcoder.ffmpeg = ffmpeg.Runner{Path: path}
+		h.previews.ffmpeg = ffmpeg.Runner{Path: path}
+	}
+}
+
+// WithPreviewWorkers sets how many scrubbing previews are generated at
+// once. The default is DefaultPreviewWorkers.
+func WithPreviewWorkers(n int) TimelapseOption {
+	return func(h *TimelapseHandler) { h.previews.workers = n }
+}
+
+// WithAPIURL sets the prefix the handler's routes are served under, for
+// URLs the API hands out to itself. The default is DefaultAPIURL.
+func WithAPIURL(prefix string) TimelapseOption {
+	return func(h *TimelapseHandler) {
+		h.transcoder.apiURL = strings.TrimSuffix(prefix, "/")
+		h.previews.apiURL = strings.TrimSuffix(prefix, "/")
+		h.remote.apiURL = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithTrashRetention sets how long deleted timelapses stay in the trash.
+// Zero disables automatic purging.
+func WithTrashRetention(d time.Duration) TimelapseOption {
+	return func(h *TimelapseHandler) { h.trash.retention = d }
+}
+
+// WithRetentionPolicy enables the retention worker. The default policy
+// removes nothing.
+func WithRetentionPolicy(p models.RetentionPolicy) TimelapseOption {
+	return func(h *TimelapseHandler) { h.retention.policy = p }
+}
+
+// WithQuarantine enables moving incomplete and corrupt timelapses into
+// the quarantine directory in the background.
+func WithQuarantine(enabled bool) TimelapseOption {
+	return func(h *TimelapseHandler) { h.quarantine.enabled = enabled }
+}
+
+// WithRemoteStorage moves timelapses older than offloadAfter to store,
+// or none when it is zero. urls is RemoteURLsPresign to hand out presigned
+// URLs where the store can make them, or RemoteURLsProxy to serve remote
+// videos through the API.
+func WithRemoteStorage(store storage.Storage, offloadAfter time.Duration, urls string) TimelapseOption {
+	return func(h *TimelapseHandler) {
+		h.remote.store = store
+		h.remote.after = offloadAfter
+		h.remote.urls = urls
+	}
+}
+
+// withRemotePrefix keeps the handler's remote videos under prefix, so
+// printers can share one store.
+func withRemotePrefix(prefix string) TimelapseOption {
+	return func(h *TimelapseHandler) {
+		if h.remote.store != nil {
+			h.remote.store = storage.Sub(h.remote.store, prefix)
+		}
+	}
+}
+
+// WithSync mirrors timelapses from the printer's FTPS server into the
+// timelapse directory.
+func WithSync(cfg SyncConfig) TimelapseOption {
+	return func(h *TimelapseHandler) { h.syncer.config = cfg }
+}
+
+// WithSyncHistory sets how many sync runs are kept. The default is
+// DefaultSyncHistory.
+func WithSyncHistory(n int) TimelapseOption {
+	return func(h *TimelapseHandler) { h.syncer.historyLimit = n }
+}
+
+func NewTimelapseHandler(dir string, opts ...TimelapseOption) *TimelapseHandler {
+	catalog := NewCatalog(dir)
+	trash := NewTrash(dir)
+	h := &TimelapseHandler{
+		dir:        dir,
+		catalog:    catalog,
+		trash:      trash,
+		retention:  NewRetention(dir, catalog, trash),
+		metadata:   NewMetadataStore(dir),
+		transcoder: NewTranscoder(dir),
+		previews:   NewPreviews(dir, catalog),
+		quarantine: NewQuarantine(dir, catalog),
+		remote:     NewRemote(catalog),
+		syncer:     NewSyncer(dir, catalog),
+	}
+	h.search = NewSearchIndex(catalog, h.metadata)
+	h.retention.starred = h.metadata.Starred
+	trash.purged = h.forget
+	h.remote.offloaded = h.dropDerived
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// forget drops everything kept about a timelapse that is gone for good.
+func (h *TimelapseHandler) forget(name string) {
+	if err := h.metadata.Delete(name); err != nil {
+		log.Printf("metadata: failed to delete %s: %v", name, err)
+	}
+	h.dropDerived(name)
+}
+
+// dropDerived removes what was generated from a timelapse's local video.
+func (h *TimelapseHandler) dropDerived(name string) {
+	if err := h.transcoder.Remove(name); err != nil {
+		log.Printf("transcode: failed to remove rendition of %s: %v", name, err)
+	}
+	if err := h.previews.Remove(name); err != nil {
+		log.Printf("previews: failed to remove previews of %s: %v", name, err)
+	}
+}
+
+// Catalog returns the index backing the handler so the caller can run its
+// watcher for the lifetime of the server.
+func (h *TimelapseHandler) Catalog() *Catalog {
+	return h.catalog
+}
+
+// Trash returns the trash so the caller can run its auto-purge loop.
+func (h *TimelapseHandler) Trash() *Trash {
+	return h.trash
+}
+
+// Retention returns the retention worker so the caller can schedule it.
+func (h *TimelapseHandler) Retention() *Retention {
+	return h.retention
+}
+
+// Previews returns the preview generator so the caller can run its
+// workers.
+func (h *TimelapseHandler) Previews() *Previews {
+	return h.previews
+}
+
+// Quarantine returns the quarantine so the caller can schedule it.
+func (h *TimelapseHandler) Quarantine() *Quarantine {
+	return h.quarantine
+}
+
+// Remote returns the remote storage so the caller can run its sync and
+// offload loop.
+func (h *TimelapseHandler) Remote() *Remote {
+	return h.remote
+}
+
+// Syncer returns the printer sync worker so the caller can schedule it.
+func (h *TimelapseHandler) Syncer() *Syncer {
+	return h.syncer
+}
+
+// Close stops transcoding jobs and releases the metadata database.
+func (h *TimelapseHandler) Close() error {
+	h.transcoder.Close()
+	return h.metadata.Close()
+}
+
+// decorate fills in what the catalog does not track itself: stored
+// metadata, remote URLs, transcoding state, scrubbing previews and links from clips to
+// their sources.
+func (h *TimelapseHandler) decorate(items []models.Timelapse) error {
+	if err := h.metadata.Attach(items); err != nil {
+		return err
+	}
+	h.remote.Attach(items)
+	h.transcoder.Attach(items)
+	h.previews.Attach(items)
+	linkClips(items)
+	return nil
 }
 
 func (h *TimelapseHandler) List(c *gin.Context) {
-	entries, err := os.ReadDir(h.dir)
+	query, err := parseListQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := h.catalog.Snapshot()
 	if err != nil {
 		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
 		return
 	}
+	etag, lastModified := h.validators(snapshot.Generation, snapshot.ChangedAt)
+	if notModified(c, etag, lastModified) {
+		return
+	}
+	if err := h.decorate(snapshot.Items); err != nil {
+		log.Printf("metadata: failed to read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse metadata"})
+		return
+	}
 
-	// Build a set of thumbnail filenames for fast lookup
-	thumbnails := make(map[string]bool)
-	thumbDir := filepath.Join(h.dir, "thumbnail")
-	thumbEntries, err := os.ReadDir(thumbDir)
-	if err == nil {
-		for _, e := range thumbEntries {
-			if !e.IsDir() {
-				thumbnails[e.Name()] = true
-			}
-		}
+	page := query.apply(snapshot.Items, c.Request.URL)
+	page.Generation = snapshot.Generation
+	page.ScannedAt = snapshot.ScannedAt
+	c.JSON(http.StatusOK, page)
+}
+
+// Get returns one timelapse with its neighbours and thumbnail variants.
+func (h *TimelapseHandler) Get(c *gin.Context) {
+	name := c.Param("filename")
+	if !validFilename(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return
 	}
 
-	timelapses := make([]models.Timelapse, 0)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	snapshot, err := h.catalog.Snapshot()
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return
+	}
+	etag, lastModified := h.validators(snapshot.Generation, snapshot.ChangedAt)
+	if notModified(c, etag, lastModified) {
+		return
+	}
 
-		name := entry.Name()
-		ext := strings.ToLower(filepath.Ext(name))
-		if ext != ".mp4" && ext != ".mkv" && ext != ".avi" {
-			continue
-		}
+	items := snapshot.Items
+	if err := h.decorate(items); err != nil {
+		log.Printf("metadata: failed to read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse metadata"})
+		return
+	}
+	sortTimelapses(items, sortOldest)
+	idx := slices.IndexFunc(items, func(t models.Timelapse) bool { return t.Filename == name })
+	if idx < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return
+	}
 
-		info, err := entry.Info()
-		if err != nil {
-			log.Printf("timelapses: failed to stat %s: %v", name, err)
-			continue
-		}
+	detail := models.TimelapseDetail{
+		Timelapse:  items[idx],
+		Thumbnails: h.catalog.ThumbnailVariants(name),
+	}
+	if idx > 0 {
+		detail.Previous = &items[idx-1]
+	}
+	if idx < len(items)-1 {
+		detail.Next = &items[idx+1]
+	}
+	c.JSON(http.StatusOK, detail)
+}
 
-		date := parseDateFromFilename(name)
-		if date.IsZero() {
-			date = info.ModTime()
+// validFilename reports whether name is a slash-separated path that stays
+// inside the timelapse directory. Hidden components and paths through a
+// thumbnail folder are rejected.
+func validFilename(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") || !filepath.IsLocal(name) {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
 		}
-
-		// Match thumbnail: same base name with .jpg extension
-		baseName := strings.TrimSuffix(name, ext)
-		thumbName := baseName + ".jpg"
-		thumbURL := ""
-		if thumbnails[thumbName] {
-			thumbURL = "/videos/thumbnail/" + url.PathEscape(thumbName)
+		if i < len(parts)-1 && part == thumbnailDirName {
+			return false
 		}
+	}
+	return true
+}
+
+// Delete moves a timelapse and its thumbnails to the trash. Remote
+// timelapses are deleted for good, since remote storage has no trash.
+func (h *TimelapseHandler) Delete(c *gin.Context) {
+	name := c.Param("filename")
+	if !validFilename(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return
+	}
+	if item, found, err := h.catalog.Lookup(name); err == nil && found && item.Remote {
+		h.deleteRemote(c, name)
+		return
+	}
 
-		timelapses = append(timelapses, models.Timelapse{
-			Filename:     name,
-			URL:          "/videos/" + url.PathEscape(name),
-			ThumbnailURL: thumbURL,
-			Size:         info.Size(),
-			Date:         date,
-		})
+	thumbs, err := h.trash.Move(name)
+	if err != nil {
+		respondTrashError(c, "delete", name, err)
+		return
 	}
+	h.catalog.Refresh(name, thumbs...)
+	c.Status(http.StatusNoContent)
+}
 
-	// Sort by date, newest first
-	sort.Slice(timelapses, func(i, j int) bool {
-		return timelapses[i].Date.After(timelapses[j].Date)
-	})
+func (h *TimelapseHandler) deleteRemote(c *gin.Context, name string) {
+	if !h.remote.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "remote storage is not configured"})
+		return
+	}
+	if err := h.remote.Delete(c.Request.Context(), name); err != nil {
+		log.Printf("remote: failed to delete %s: %v", name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to delete timelapse"})
+		return
+	}
 
-	c.JSON(http.StatusOK, timelapses)
+	thumbs := h.catalog.Thumbnails(name)
+	for _, thumb := range thumbs {
+		if err := os.Remove(h.catalog.abs(thumb)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("remote: failed to remove thumbnail %s: %v", thumb, err)
+		}
+	}
+	h.catalog.Refresh(name, thumbs...)
+	h.forget(name)
+	c.Status(http.StatusNoContent)
 }
 
-func parseDateFromFilename(name string) time.Time {
-	matches := filenameDateRegex.FindStringSubmatch(name)
-	if len(matches) < 3 {
-		return time.Time{}
+func (h *TimelapseHandler) ListTrash(c *gin.Context) {
+	items, err := h.trash.List()
+	if err != nil {
+		log.Printf("trash: failed to list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read trash"})
+		return
 	}
+	c.JSON(http.StatusOK, items)
+}
 
-	// Convert "09-14-01" to "09:14:01"
-	timePart := strings.ReplaceAll(matches[2], "-", ":")
-	dateStr := matches[1] + "T" + timePart + "Z"
+func (h *TimelapseHandler) RestoreTrash(c *gin.Context) {
+	name := c.Param("filename")
+	if !validFilename(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return
+	}
 
-	t, err := time.Parse("2006-01-02T15:04:05Z", dateStr)
+	thumbs, err := h.trash.Restore(name)
 	if err != nil {
-		return time.Time{}
+		respondTrashError(c, "restore", name, err)
+		return
+	}
+	h.catalog.Refresh(name, thumbs...)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TimelapseHandler) PurgeTrash(c *gin.Context) {
+	name := c.Param("filename")
+	if !validFilename(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return
+	}
+
+	if err := h.trash.Purge(name); err != nil {
+		respondTrashError(c, "purge", name, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respondTrashError(c *gin.Context, action, name string, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+	case errors.Is(err, errConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "a timelapse with that name already exists"})
+	default:
+		log.Printf("trash: failed to %s %s: %v", action, name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to " + action + " timelapse"})
 	}
-	return t
 }
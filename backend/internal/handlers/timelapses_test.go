@@ -12,9 +12,25 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
 	"github.com/codyseavey/3d-printer/backend/internal/models"
 )
 
+// writeFiles writes each file, named by its slash-separated path under
+// dir, creating folders as needed.
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestListTimelapses(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -57,10 +73,11 @@ func TestListTimelapses(t *testing.T) {
 		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 
-	var result []models.Timelapse
-	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
+	result := page.Items
 
 	if len(result) != 3 {
 		t.Fatalf("expected 3 timelapses, got %d", len(result))
@@ -111,10 +128,13 @@ func TestListTimelapses_EmptyDir(t *testing.T) {
 		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 
-	// Should return [] (empty array, not null)
-	body := w.Body.String()
-	if body != "[]" {
-		t.Errorf("expected [], got %s", body)
+	// Items should be [] (empty array, not null)
+	var page map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if string(page["items"]) != "[]" {
+		t.Errorf("expected items [], got %s", page["items"])
 	}
 }
 
@@ -153,10 +173,11 @@ func TestListTimelapses_MkvAndAvi(t *testing.T) {
 
 	h.List(c)
 
-	var result []models.Timelapse
-	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
+	result := page.Items
 
 	if len(result) != 3 {
 		t.Fatalf("expected 3 timelapses (mp4, mkv, avi), got %d", len(result))
@@ -201,10 +222,11 @@ func TestListTimelapses_ThumbnailDirWithoutMatchingVideos(t *testing.T) {
 
 	h.List(c)
 
-	var result []models.Timelapse
-	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
+	result := page.Items
 
 	if len(result) != 1 {
 		t.Fatalf("expected 1 timelapse, got %d", len(result))
@@ -214,27 +236,156 @@ func TestListTimelapses_ThumbnailDirWithoutMatchingVideos(t *testing.T) {
 	}
 }
 
-func TestParseDateFromFilename(t *testing.T) {
-	tests := []struct {
-		name     string
-		expected time.Time
-	}{
-		{"video_2024-07-24_09-14-01.mp4", time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC)},
-		{"video_2024-12-31_23-59-59.mp4", time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)},
-		{"random_file.mp4", time.Time{}},
-		{"no_date_here.txt", time.Time{}},
-		// Invalid date components: regex matches but time.Parse rejects
-		{"video_2024-13-32_25-61-61.mp4", time.Time{}},
-		// Multiple date patterns: should use the first match
-		{"video_2024-01-01_00-00-00_2025-12-31_23-59-59.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
-		// Partial match
-		{"video_2024-07-24.mp4", time.Time{}},
-	}
-
-	for _, tt := range tests {
-		result := parseDateFromFilename(tt.name)
-		if !result.Equal(tt.expected) {
-			t.Errorf("parseDateFromFilename(%q) = %v, want %v", tt.name, result, tt.expected)
+func TestListTimelapses_MediaMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpDir := t.TempDir()
+	created := time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC)
+	video := mediatest.MP4(mediatest.Video{
+		Duration:     30 * time.Second,
+		Width:        1920,
+		Height:       1080,
+		Codec:        "h264",
+		FPS:          30,
+		CreationTime: created,
+	})
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-07-24_09-14-01.mp4"), video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Files that cannot be probed are still listed
+	if err := os.WriteFile(filepath.Join(tmpDir, "broken.mkv"), []byte("not a video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewTimelapseHandler(tmpDir)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses", nil)
+
+	h.List(c)
+
+	var page models.TimelapsePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(page.Items) != 2 {
+		t.Fatalf("expected 2 timelapses, got %d", len(page.Items))
+	}
+
+	got, _ := findTimelapse(page.Items, "video_2024-07-24_09-14-01.mp4")
+	if got.Duration != 30 || got.Width != 1920 || got.Height != 1080 || got.Codec != "h264" || got.FPS != 30 {
+		t.Errorf("unexpected media metadata: %+v", got)
+	}
+	if !got.CreationTime.Equal(created) {
+		t.Errorf("expected creation time %v, got %v", created, got.CreationTime)
+	}
+
+	broken, _ := findTimelapse(page.Items, "broken.mkv")
+	if broken.Duration != 0 || broken.Codec != "" {
+		t.Errorf("expected no metadata for unprobeable file, got %+v", broken)
+	}
+}
+
+func getTimelapse(t *testing.T, h *TimelapseHandler, filename string) (int, models.TimelapseDetail) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/timelapses/"+url.PathEscape(filename), nil)
+	c.Params = gin.Params{{Key: "filename", Value: filename}}
+
+	h.Get(c)
+
+	var detail models.TimelapseDetail
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+	}
+	return w.Code, detail
+}
+
+func TestGetTimelapse(t *testing.T) {
+	tmpDir := t.TempDir()
+	thumbDir := filepath.Join(tmpDir, "thumbnail")
+	if err := os.Mkdir(thumbDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"video_2024-07-01_10-00-00.mp4",
+		"video_2024-07-02_10-00-00.mp4",
+		"video_2024-07-03_10-00-00.mp4",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{
+		"video_2024-07-02_10-00-00.jpg",
+		"video_2024-07-02_10-00-00_small.webp",
+		"video_2024-07-02_10-00-00.txt",
+		"video_2024-07-02_10-00-001.jpg",
+	} {
+		if err := os.WriteFile(filepath.Join(thumbDir, name), []byte("thumb"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewTimelapseHandler(tmpDir)
+
+	code, detail := getTimelapse(t, h, "video_2024-07-02_10-00-00.mp4")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if detail.Filename != "video_2024-07-02_10-00-00.mp4" || detail.Size != 100 {
+		t.Errorf("unexpected timelapse: %+v", detail.Timelapse)
+	}
+	if detail.Previous == nil || detail.Previous.Filename != "video_2024-07-01_10-00-00.mp4" {
+		t.Errorf("unexpected previous: %+v", detail.Previous)
+	}
+	if detail.Next == nil || detail.Next.Filename != "video_2024-07-03_10-00-00.mp4" {
+		t.Errorf("unexpected next: %+v", detail.Next)
+	}
+
+	wantThumbs := []string{
+		"/videos/thumbnail/video_2024-07-02_10-00-00.jpg",
+		"/videos/thumbnail/video_2024-07-02_10-00-00_small.webp",
+	}
+	if len(detail.Thumbnails) != len(wantThumbs) {
+		t.Fatalf("expected thumbnails %v, got %v", wantThumbs, detail.Thumbnails)
+	}
+	for i := range wantThumbs {
+		if detail.Thumbnails[i] != wantThumbs[i] {
+			t.Errorf("expected thumbnails %v, got %v", wantThumbs, detail.Thumbnails)
+		}
+	}
+
+	// The oldest has no previous and the newest has no next
+	if _, detail := getTimelapse(t, h, "video_2024-07-01_10-00-00.mp4"); detail.Previous != nil {
+		t.Errorf("expected no previous for oldest, got %+v", detail.Previous)
+	}
+	if _, detail := getTimelapse(t, h, "video_2024-07-03_10-00-00.mp4"); detail.Next != nil {
+		t.Errorf("expected no next for newest, got %+v", detail.Next)
+	}
+}
+
+func TestGetTimelapse_NotFoundAndTraversal(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "video.mp4"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir)
+
+	if code, _ := getTimelapse(t, h, "missing.mp4"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing video, got %d", code)
+	}
+
+	for _, name := range []string{"", "..", "../etc/passwd", "thumbnail/x.jpg", `..\video.mp4`, ".hidden.mp4", "video.mp4\x00"} {
+		if code, _ := getTimelapse(t, h, name); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %q, got %d", name, code)
 		}
 	}
 }
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	transcodeDirName = ".transcoded"
+
+	// DefaultAPIURL is where the unscoped timelapse routes are served.
+	DefaultAPIURL = "/api"
+
+	transcodeTimeout  = time.Hour
+	maxTranscodeQueue = 16
+)
+
+var errTranscodeQueueFull = errors.New("transcode queue is full")
+
+// Transcoder produces MP4 renditions of timelapses browsers cannot play
+// natively. Renditions are cached under .transcoded in the timelapse
+// directory, mirroring the source path, and are rebuilt when the source is
+// newer. Jobs run one at a time in the background.
+type Transcoder struct {
+	dir    string
+	ffmpeg ffmpeg.Runner
+	apiURL string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*transcodeJob
+
+	ready derivedCache
+	// changes counts job state changes, which listings show
+	changes changeCounter
+}
+
+type transcodeJob struct {
+	state string
+	err   string
+}
+
+func NewTranscoder(dir string) *Transcoder {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Transcoder{
+		dir:    dir,
+		apiURL: DefaultAPIURL,
+		ctx:    ctx,
+		cancel: cancel,
+		sem:    make(chan struct{}, 1),
+		jobs:   make(map[string]*transcodeJob),
+	}
+}
+
+// needsTranscode reports whether name is in a format browsers cannot play.
+func needsTranscode(name string) bool {
+	return strings.ToLower(path.Ext(name)) != ".mp4"
+}
+
+// rendition returns the filesystem path of the MP4 rendition of name.
+func (t *Transcoder) rendition(name string) string {
+	return filepath.Join(t.dir, transcodeDirName, filepath.FromSlash(name)+".mp4")
+}
+
+func (t *Transcoder) playableURL(name string) string {
+	return t.apiURL + "/timelapses/" + url.PathEscape(name) + "/playable"
+}
+
+// fresh reports whether the rendition of name exists and is at least as
+// new as the source.
+func (t *Transcoder) fresh(name string) bool {
+	out, err := os.Stat(t.rendition(name))
+	if err != nil {
+		return false
+	}
+	src, err := os.Stat(filepath.Join(t.dir, filepath.FromSlash(name)))
+	return err == nil && !out.ModTime().Before(src.ModTime())
+}
+
+// Status returns the transcoding state of one timelapse.
+func (t *Transcoder) Status(item models.Timelapse) models.TranscodeStatus {
+	status := models.TranscodeStatus{Filename: item.Filename}
+	switch {
+	case !needsTranscode(item.Filename):
+		status.State = models.TranscodeNative
+		status.PlayableURL = item.URL
+		return status
+	case !t.ffmpeg.Enabled() || item.Remote:
+		status.State = models.TranscodeUnavailable
+		return status
+	}
+
+	t.mu.Lock()
+	job, ok := t.jobs[item.Filename]
+	if ok {
+		status.State = job.state
+		status.Error = job.err
+	}
+	t.mu.Unlock()
+	if ok {
+		return status
+	}
+
+	if t.ready.check(item, t.fresh) {
+		status.State = models.TranscodeReady
+		status.PlayableURL = t.playableURL(item.Filename)
+	} else {
+		status.State = models.TranscodeNone
+	}
+	return status
+}
+
+// Attach sets PlayableURL and TranscodeState on each item in place.
+func (t *Transcoder) Attach(items []models.Timelapse) {
+	for i := range items {
+		t.attach(&items[i])
+	}
+}
+
+func (t *Transcoder) attach(item *models.Timelapse) {
+	status := t.Status(*item)
+	item.PlayableURL = status.PlayableURL
+	item.TranscodeState = status.State
+}
+
+// Start queues a rendition of item unless one is ready or already on its
+// way, and returns the resulting status.
+func (t *Transcoder) Start(item models.Timelapse) (models.TranscodeStatus, error) {
+	status := t.Status(item)
+	switch status.State {
+	case models.TranscodeNone, models.TranscodeFailed:
+	default:
+		return status, nil
+	}
+
+	t.mu.Lock()
+	if existing := t.jobs[item.Filename]; existing != nil && existing.state != models.TranscodeFailed {
+		status.State = existing.state
+		t.mu.Unlock()
+		return status, nil
+	}
+	queued := 0
+	for _, job := range t.jobs {
+		if job.state == models.TranscodeQueued {
+			queued++
+		}
+	}
+	if queued >= maxTranscodeQueue {
+		t.mu.Unlock()
+		return status, errTranscodeQueueFull
+	}
+	job := &transcodeJob{state: models.TranscodeQueued}
+	t.jobs[item.Filename] = job
+	t.mu.Unlock()
+	t.changes.bump()
+
+	t.wg.Add(1)
+	go t.run(item.Filename, job)
+
+	status.State = models.TranscodeQueued
+	status.Error = ""
+	return status, nil
+}
+
+func (t *Transcoder) run(name string, job *transcodeJob) {
+	defer t.wg.Done()
+
+	release, err := t.acquire(t.ctx)
+	if err != nil {
+		t.finish(name, job, err)
+		return
+	}
+	defer release()
+	t.setState(job, models.TranscodeRunning)
+
+	ctx, cancel := context.WithTimeout(t.ctx, transcodeTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err = t.ffmpeg.TranscodeMP4(ctx, filepath.Join(t.dir, filepath.FromSlash(name)), t.rendition(name))
+	if err == nil {
+		log.Printf("transcode: %s ready in %s", name, time.Since(started).Round(time.Second))
+	}
+	t.finish(name, job, err)
+}
+
+// acquire waits for the ffmpeg slot shared by every job the handler runs,
+// so clips and renditions never encode at the same time. The caller must
+// call release.
+func (t *Transcoder) acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case t.sem <- struct{}{}:
+		return func() { <-t.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ctx.Done():
+		return nil, t.ctx.Err()
+	}
+}
+
+func (t *Transcoder) setState(job *transcodeJob, state string) {
+	t.mu.Lock()
+	job.state = state
+	t.mu.Unlock()
+	t.changes.bump()
+}
+
+// finish records the outcome. Successful jobs are dropped, since the
+// rendition on disk is then the source of truth.
+func (t *Transcoder) finish(name string, job *transcodeJob, err error) {
+	t.ready.forget(name)
+	defer t.changes.bump()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		if t.jobs[name] == job {
+			delete(t.jobs, name)
+		}
+		return
+	}
+	log.Printf("transcode: failed to transcode %s: %v", name, err)
+	job.state = models.TranscodeFailed
+	job.err = "transcoding failed"
+	if errors.Is(err, context.DeadlineExceeded) {
+		job.err = "transcoding timed out"
+	}
+}
+
+// Remove deletes the rendition of name, if any.
+func (t *Transcoder) Remove(name string) error {
+	t.mu.Lock()
+	delete(t.jobs, name)
+	t.mu.Unlock()
+	defer t.changes.bump()
+	defer t.ready.forget(name)
+
+	if err := os.Remove(t.rendition(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close cancels running jobs and waits for them to stop.
+func (t *Transcoder) Close() {
+	t.cancel()
+	t.wg.Wait()
+}
+
+// lookup finds the timelapse named in the path, responding with an error
+// if it is invalid or missing.
+func (h *TimelapseHandler) lookup(c *gin.Context) (models.Timelapse, bool) {
+	name := c.Param("filename")
+	if !validFilename(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		return models.Timelapse{}, false
+	}
+
+	item, found, err := h.catalog.Lookup(name)
+	if err != nil {
+		log.Printf("timelapses: failed to read directory %s: %v", h.dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read timelapse directory"})
+		return models.Timelapse{}, false
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return models.Timelapse{}, false
+	}
+	h.remote.attach(&item)
+	return item, true
+}
+
+// TranscodeStatus reports whether a browser-playable rendition exists.
+func (h *TimelapseHandler) TranscodeStatus(c *gin.Context) {
+	if item, ok := h.lookup(c); ok {
+		c.JSON(http.StatusOK, h.transcoder.Status(item))
+	}
+}
+
+// StartTranscode queues an MP4 rendition of an MKV or AVI timelapse.
+func (h *TimelapseHandler) StartTranscode(c *gin.Context) {
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.transcoder.Start(item)
+	switch {
+	case errors.Is(err, errTranscodeQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case status.State == models.TranscodeUnavailable:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "transcoding is not configured"})
+	case status.State == models.TranscodeQueued || status.State == models.TranscodeRunning:
+		c.JSON(http.StatusAccepted, status)
+	default:
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// Playable serves the MP4 rendition of a transcoded timelapse.
+func (h *TimelapseHandler) Playable(c *gin.Context) {
+	item, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if !needsTranscode(item.Filename) {
+		c.Redirect(http.StatusFound, item.URL)
+		return
+	}
+	if !h.transcoder.fresh(item.Filename) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no playable rendition yet"})
+		return
+	}
+
+	f, err := os.Open(h.transcoder.rendition(item.Filename))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no playable rendition yet"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read rendition"})
+		return
+	}
+
+	c.Header("Content-Type", "video/mp4")
+	c.Header("Cache-Control", "no-cache")
+	clearWriteDeadline(c, "transcode")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+}
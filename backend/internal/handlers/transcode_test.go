@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ffmpeg/ffmpegtest"
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func waitTranscode(t *testing.T, h *TimelapseHandler, name, want string) models.TranscodeStatus {
+	t.Helper()
+
+	item, found, err := h.catalog.Lookup(name)
+	if err != nil || !found {
+		t.Fatalf("lookup %s: %v, %v", name, found, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := h.transcoder.Status(item)
+		if status.State == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be %s, last %+v", name, want, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTranscode_Lifecycle(t *testing.T) {
+	tmpDir := setupQueryDir(t)
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	t.Cleanup(func() { h.Close() })
+	mkv := "video_2024-07-03_10-00-00.mkv"
+
+	_, page := listPage(t, h, "/api/timelapses?sort=oldest")
+	states := make(map[string]models.Timelapse)
+	for _, item := range page.Items {
+		states[item.Filename] = item
+	}
+	if mp4 := states["video_2024-07-01_10-00-00.mp4"]; mp4.TranscodeState != models.TranscodeNative || mp4.PlayableURL != mp4.URL {
+		t.Errorf("expected MP4 to be natively playable, got %+v", mp4)
+	}
+	if item := states[mkv]; item.TranscodeState != models.TranscodeNone || item.PlayableURL != "" {
+		t.Errorf("expected MKV to need transcoding, got %+v", item)
+	}
+
+	// Nothing to serve until a rendition exists
+	if w := callWithFilename(h.Playable, http.MethodGet, mkv); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 before transcoding, got %d", w.Code)
+	}
+
+	w := callWithFilename(h.StartTranscode, http.MethodPost, mkv)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var status models.TranscodeStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if status.State != models.TranscodeQueued && status.State != models.TranscodeRunning {
+		t.Errorf("expected a queued job, got %+v", status)
+	}
+
+	status = waitTranscode(t, h, mkv, models.TranscodeReady)
+	if status.PlayableURL != "/api/timelapses/"+mkv+"/playable" {
+		t.Errorf("unexpected playable URL %q", status.PlayableURL)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, transcodeDirName, mkv+".mp4")); err != nil {
+		t.Errorf("expected a cached rendition: %v", err)
+	}
+
+	w = callWithFilename(h.Playable, http.MethodGet, mkv)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "video/mp4" || w.Body.Len() != 500 {
+		t.Errorf("expected the rendition, got %d %q with %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+
+	// Asking again reuses the rendition
+	if w = callWithFilename(h.StartTranscode, http.MethodPost, mkv); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a ready rendition, got %d", w.Code)
+	}
+
+	// A newer source makes the rendition stale once the catalog sees it
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpDir, mkv), future, future); err != nil {
+		t.Fatal(err)
+	}
+	h.catalog.Refresh(mkv)
+	waitTranscode(t, h, mkv, models.TranscodeNone)
+}
+
+func TestTranscode_SlowDownload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "video.mkv"), largeFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	t.Cleanup(func() { h.Close() })
+	if w := callWithFilename(h.StartTranscode, http.MethodPost, "video.mkv"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	waitTranscode(t, h, "video.mkv", models.TranscodeReady)
+
+	router := gin.New()
+	router.GET("/timelapses/:filename/playable", h.Playable)
+	if n := slowDownload(t, router, "/timelapses/video.mkv/playable"); n != int64(len(largeFile)) {
+		t.Errorf("expected %d bytes past the write timeout, got %d", len(largeFile), n)
+	}
+}
+
+func TestTranscode_Failure(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t), WithFFmpeg(ffmpegtest.Fail(t, "Invalid data found when processing input")))
+	t.Cleanup(func() { h.Close() })
+	avi := "video_2024-07-05_10-00-00.avi"
+
+	if w := callWithFilename(h.StartTranscode, http.MethodPost, avi); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	status := waitTranscode(t, h, avi, models.TranscodeFailed)
+	if status.Error == "" || status.PlayableURL != "" {
+		t.Errorf("expected an error and no playable URL, got %+v", status)
+	}
+
+	// Failed jobs can be retried
+	if w := callWithFilename(h.StartTranscode, http.MethodPost, avi); w.Code != http.StatusAccepted {
+		t.Errorf("expected retry to be accepted, got %d", w.Code)
+	}
+}
+
+func TestTranscode_NotConfigured(t *testing.T) {
+	h := NewTimelapseHandler(setupQueryDir(t))
+	t.Cleanup(func() { h.Close() })
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		file    string
+		want    int
+	}{
+		{"mkv without ffmpeg", h.StartTranscode, http.MethodPost, "video_2024-07-03_10-00-00.mkv", http.StatusServiceUnavailable},
+		{"mp4 is already playable", h.StartTranscode, http.MethodPost, "video_2024-07-01_10-00-00.mp4", http.StatusOK},
+		{"missing", h.StartTranscode, http.MethodPost, "missing.mkv", http.StatusNotFound},
+		{"traversal", h.StartTranscode, http.MethodPost, "../video.mkv", http.StatusBadRequest},
+		{"mp4 playable redirects", h.Playable, http.MethodGet, "video_2024-07-01_10-00-00.mp4", http.StatusFound},
+	}
+	for _, tt := range tests {
+		if w := callWithFilename(tt.handler, tt.method, tt.file); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+
+	w := callWithFilename(h.TranscodeStatus, http.MethodGet, "video_2024-07-05_10-00-00.avi")
+	var status models.TranscodeStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if status.State != models.TranscodeUnavailable {
+		t.Errorf("expected unavailable, got %+v", status)
+	}
+}
+
+func TestTranscode_PurgeRemovesRendition(t *testing.T) {
+	tmpDir := setupQueryDir(t)
+	h := NewTimelapseHandler(tmpDir, WithFFmpeg(ffmpegtest.Copy(t, "")))
+	t.Cleanup(func() { h.Close() })
+	mkv := "video_2024-07-03_10-00-00.mkv"
+
+	callWithFilename(h.StartTranscode, http.MethodPost, mkv)
+	waitTranscode(t, h, mkv, models.TranscodeReady)
+
+	if _, err := h.trash.Move(mkv); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.trash.Purge(mkv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, transcodeDirName, mkv+".mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected purge to remove the rendition, got %v", err)
+	}
+}
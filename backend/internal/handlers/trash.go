@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+const (
+	trashDirName   = ".trash"
+	trashIndexName = "index.json"
+
+	// DefaultTrashRetention is how long trashed timelapses are kept before
+	// they are purged automatically.
+	DefaultTrashRetention = 30 * 24 * time.Hour
+)
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+)
+
+// trashEntry records when a video was deleted and the file names of the
+// thumbnails moved from the thumbnail folder next to it.
+type trashEntry struct {
+	DeletedAt  time.Time `json:"deletedAt"`
+	Thumbnails []string  `json:"thumbnails,omitempty"`
+}
+
+// Trash holds deleted timelapses in a .trash folder inside the timelapse
+// directory so they can be restored until they are purged. Videos and
+// their thumbnail folders keep their relative paths inside .trash,
+// mirroring the live layout. An index records when each was deleted.
+type Trash struct {
+	dir       string
+	retention time.Duration
+
+	// purged is called with the name of each permanently deleted video.
+	purged func(name string)
+
+	mu sync.Mutex
+}
+
+func NewTrash(dir string) *Trash {
+	return &Trash{dir: dir, retention: DefaultTrashRetention}
+}
+
+// path returns the filesystem path of a slash-separated path in the trash.
+func (t *Trash) path(elem ...string) string {
+	return filepath.Join(t.dir, trashDirName, filepath.FromSlash(path.Join(elem...)))
+}
+
+// live returns the filesystem path of a slash-separated path in the
+// timelapse directory.
+func (t *Trash) live(elem ...string) string {
+	return filepath.Join(t.dir, filepath.FromSlash(path.Join(elem...)))
+}
+
+func (t *Trash) readIndex() (map[string]trashEntry, error) {
+	index := make(map[string]trashEntry)
+	data, err := os.ReadFile(t.path(trashIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("corrupt trash index: %w", err)
+	}
+	return index, nil
+}
+
+func (t *Trash) writeIndex(index map[string]trashEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.path(trashIndexName + ".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path(trashIndexName))
+}
+
+// Move puts a video and its thumbnails in the trash and returns the paths
+// of the thumbnails that were moved, relative to the timelapse directory.
+func (t *Trash) Move(name string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	src := t.live(name)
+	if info, err := os.Stat(src); err != nil || !info.Mode().IsRegular() {
+		return nil, errNotFound
+	}
+	if _, err := os.Stat(t.path(name)); err == nil {
+		return nil, errConflict
+	}
+
+	thumbDir := thumbnailDir(name)
+	if err := os.MkdirAll(t.path(thumbDir), 0o755); err != nil {
+		return nil, err
+	}
+	index, err := t.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(src, t.path(name)); err != nil {
+		return nil, err
+	}
+
+	// The thumbnails of videos next to it stay
+	var others []string
+	siblings, _ := os.ReadDir(t.live(path.Dir(name)))
+	for _, e := range siblings {
+		if !e.IsDir() && videoExtensions[strings.ToLower(path.Ext(e.Name()))] {
+			others = append(others, e.Name())
+		}
+	}
+
+	var thumbs, moved []string
+	entries, _ := os.ReadDir(t.live(thumbDir))
+	for _, e := range entries {
+		if e.IsDir() || !isThumbnailVariant(path.Base(name), e.Name(), others) {
+			continue
+		}
+		if err := os.Rename(t.live(thumbDir, e.Name()), t.path(thumbDir, e.Name())); err != nil {
+			log.Printf("trash: failed to move thumbnail %s: %v", e.Name(), err)
+			continue
+		}
+		thumbs = append(thumbs, e.Name())
+		moved = append(moved, path.Join(thumbDir, e.Name()))
+	}
+
+	index[name] = trashEntry{DeletedAt: time.Now().UTC(), Thumbnails: thumbs}
+	return moved, t.writeIndex(index)
+}
+
+// List returns the trashed timelapses, most recently deleted first. Files
+// missing from the index are dated by their modification time.
+func (t *Trash) List() ([]models.TrashedTimelapse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	items := make([]models.TrashedTimelapse, 0)
+	index, err := t.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	root := t.path()
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && d.Name() == thumbnailDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if name == trashIndexName || name == trashIndexName+".tmp" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		entry, ok := index[name]
+		if !ok {
+			entry.DeletedAt = info.ModTime()
+		}
+		item := models.TrashedTimelapse{
+			Filename:   name,
+			Size:       info.Size(),
+			DeletedAt:  entry.DeletedAt,
+			Thumbnails: entry.Thumbnails,
+		}
+		if item.Thumbnails == nil {
+			item.Thumbnails = []string{}
+		}
+		if t.retention > 0 {
+			item.PurgeAt = entry.DeletedAt.Add(t.retention)
+		}
+		items = append(items, item)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// Restore moves a trashed video and its thumbnails back. It refuses to
+// overwrite a video that has since reappeared, e.g. from a re-sync. Like
+// Move, it returns the restored thumbnail paths.
+func (t *Trash) Restore(name string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if info, err := os.Stat(t.path(name)); err != nil || !info.Mode().IsRegular() {
+		return nil, errNotFound
+	}
+	dst := t.live(name)
+	if _, err := os.Stat(dst); err == nil {
+		return nil, errConflict
+	}
+
+	index, err := t.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	// The video's folder may have been removed since it was deleted
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(t.path(name), dst); err != nil {
+		return nil, err
+	}
+
+	var thumbs []string
+	thumbDir := thumbnailDir(name)
+	if err := os.MkdirAll(t.live(thumbDir), 0o755); err != nil {
+		log.Printf("trash: failed to create thumbnail directory: %v", err)
+	}
+	for _, thumb := range index[name].Thumbnails {
+		dst := t.live(thumbDir, thumb)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.Rename(t.path(thumbDir, thumb), dst); err != nil {
+			log.Printf("trash: failed to restore thumbnail %s: %v", thumb, err)
+			continue
+		}
+		thumbs = append(thumbs, path.Join(thumbDir, thumb))
+	}
+
+	delete(index, name)
+	return thumbs, t.writeIndex(index)
+}
+
+// Purge permanently deletes a trashed video and its thumbnails.
+func (t *Trash) Purge(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index, err := t.readIndex()
+	if err != nil {
+		return err
+	}
+	if err := t.purgeLocked(name, index); err != nil {
+		return err
+	}
+	return t.writeIndex(index)
+}
+
+func (t *Trash) purgeLocked(name string, index map[string]trashEntry) error {
+	if info, err := os.Stat(t.path(name)); err == nil && !info.Mode().IsRegular() {
+		return errNotFound
+	}
+	if err := os.Remove(t.path(name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errNotFound
+		}
+		return err
+	}
+	for _, thumb := range index[name].Thumbnails {
+		if err := os.Remove(t.path(thumbnailDir(name), thumb)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("trash: failed to purge thumbnail %s: %v", thumb, err)
+		}
+	}
+	delete(index, name)
+	if t.purged != nil {
+		t.purged(name)
+	}
+	return nil
+}
+
+// PurgeExpired deletes everything trashed longer than the retention period
+// and returns how many videos were purged.
+func (t *Trash) PurgeExpired(now time.Time) (int, error) {
+	if t.retention <= 0 {
+		return 0, nil
+	}
+
+	items, err := t.List()
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index, err := t.readIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, item := range items {
+		if now.Before(item.PurgeAt) {
+			continue
+		}
+		if err := t.purgeLocked(item.Filename, index); err != nil && !errors.Is(err, errNotFound) {
+			log.Printf("trash: failed to purge %s: %v", item.Filename, err)
+			continue
+		}
+		purged++
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, t.writeIndex(index)
+}
+
+// Run purges expired items every interval until ctx is cancelled.
+func (t *Trash) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := t.PurgeExpired(time.Now()); err != nil {
+			log.Printf("trash: auto-purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("trash: auto-purged %d expired timelapses", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
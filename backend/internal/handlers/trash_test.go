@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/codyseavey/3d-printer/backend/internal/models"
+)
+
+func setupTrashDir(t *testing.T) string {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	thumbDir := filepath.Join(tmpDir, "thumbnail")
+	if err := os.Mkdir(thumbDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-07-24_09-14-01.mp4"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "video_2024-07-25_09-14-01.mp4"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(thumbDir, "video_2024-07-24_09-14-01.jpg"), []byte("thumb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return tmpDir
+}
+
+func callWithFilename(h gin.HandlerFunc, method, filename string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", nil)
+	c.Params = gin.Params{{Key: "filename", Value: filename}}
+	h(c)
+	// The engine flushes header-only responses after the handler returns
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+func listTrash(t *testing.T, h *TimelapseHandler) []models.TrashedTimelapse {
+	t.Helper()
+
+	w := callWithFilename(h.ListTrash, http.MethodGet, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 listing trash, got %d", w.Code)
+	}
+	var items []models.TrashedTimelapse
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	return items
+}
+
+func TestTrash_DeleteRestore(t *testing.T) {
+	tmpDir := setupTrashDir(t)
+	h := NewTimelapseHandler(tmpDir)
+	name := "video_2024-07-24_09-14-01.mp4"
+
+	// Load the catalog so we can check it is updated without a rescan
+	if _, err := h.catalog.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := callWithFilename(h.Delete, http.MethodDelete, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+		t.Error("expected video to be moved out of the timelapse directory")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".trash", "thumbnail", "video_2024-07-24_09-14-01.jpg")); err != nil {
+		t.Errorf("expected thumbnail in trash: %v", err)
+	}
+
+	snap, _ := h.catalog.Snapshot()
+	if _, ok := findTimelapse(snap.Items, name); ok {
+		t.Error("expected deleted video to leave the catalog")
+	}
+
+	items := listTrash(t, h)
+	if len(items) != 1 || items[0].Filename != name {
+		t.Fatalf("unexpected trash contents: %+v", items)
+	}
+	if items[0].Size != 1000 || items[0].DeletedAt.IsZero() {
+		t.Errorf("unexpected trash entry: %+v", items[0])
+	}
+	if want := items[0].DeletedAt.Add(DefaultTrashRetention); !items[0].PurgeAt.Equal(want) {
+		t.Errorf("expected purge at %v, got %v", want, items[0].PurgeAt)
+	}
+	if len(items[0].Thumbnails) != 1 {
+		t.Errorf("expected 1 trashed thumbnail, got %v", items[0].Thumbnails)
+	}
+
+	// Deleting again is a 404
+	if w := callWithFilename(h.Delete, http.MethodDelete, name); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 deleting missing video, got %d", w.Code)
+	}
+
+	if w := callWithFilename(h.RestoreTrash, http.MethodPost, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on restore, got %d: %s", w.Code, w.Body.String())
+	}
+	snap, _ = h.catalog.Snapshot()
+	restored, ok := findTimelapse(snap.Items, name)
+	if !ok {
+		t.Fatal("expected restored video back in the catalog")
+	}
+	if restored.ThumbnailURL == "" {
+		t.Error("expected restored video to have its thumbnail back")
+	}
+	if items := listTrash(t, h); len(items) != 0 {
+		t.Errorf("expected empty trash after restore, got %+v", items)
+	}
+}
+
+func TestTrash_RestoreConflict(t *testing.T) {
+	tmpDir := setupTrashDir(t)
+	h := NewTimelapseHandler(tmpDir)
+	name := "video_2024-07-24_09-14-01.mp4"
+
+	if w := callWithFilename(h.Delete, http.MethodDelete, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d", w.Code)
+	}
+
+	// A re-sync brought the video back before the restore
+	if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if w := callWithFilename(h.RestoreTrash, http.MethodPost, name); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 restoring over existing video, got %d", w.Code)
+	}
+}
+
+func TestTrash_Purge(t *testing.T) {
+	tmpDir := setupTrashDir(t)
+	h := NewTimelapseHandler(tmpDir)
+	name := "video_2024-07-24_09-14-01.mp4"
+
+	if w := callWithFilename(h.Delete, http.MethodDelete, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d", w.Code)
+	}
+	if w := callWithFilename(h.PurgeTrash, http.MethodDelete, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on purge, got %d", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, ".trash", name)); !os.IsNotExist(err) {
+		t.Error("expected purged video to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".trash", "thumbnail", "video_2024-07-24_09-14-01.jpg")); !os.IsNotExist(err) {
+		t.Error("expected purged thumbnail to be gone")
+	}
+	if w := callWithFilename(h.PurgeTrash, http.MethodDelete, name); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 purging twice, got %d", w.Code)
+	}
+}
+
+func TestTrash_InvalidFilename(t *testing.T) {
+	h := NewTimelapseHandler(setupTrashDir(t))
+
+	for _, fn := range []gin.HandlerFunc{h.Delete, h.RestoreTrash, h.PurgeTrash} {
+		if w := callWithFilename(fn, http.MethodDelete, "../video.mp4"); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for traversal, got %d", w.Code)
+		}
+	}
+}
+
+func TestTrash_PurgeExpired(t *testing.T) {
+	tmpDir := setupTrashDir(t)
+	h := NewTimelapseHandler(tmpDir, WithTrashRetention(24*time.Hour))
+
+	for _, name := range []string{"video_2024-07-24_09-14-01.mp4", "video_2024-07-25_09-14-01.mp4"} {
+		if _, err := h.trash.Move(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := h.trash.PurgeExpired(time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing purged yet, got %d, %v", n, err)
+	}
+
+	n, err := h.trash.PurgeExpired(time.Now().Add(25 * time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 purged, got %d, %v", n, err)
+	}
+	if items := listTrash(t, h); len(items) != 0 {
+		t.Errorf("expected empty trash, got %+v", items)
+	}
+
+	// Zero retention never purges
+	h = NewTimelapseHandler(setupTrashDir(t), WithTrashRetention(0))
+	if _, err := h.trash.Move("video_2024-07-24_09-14-01.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := h.trash.PurgeExpired(time.Now().Add(10000 * time.Hour)); n != 0 {
+		t.Errorf("expected no purge with retention disabled, got %d", n)
+	}
+}
+
+func TestTrash_NestedFolder(t *testing.T) {
+	tmpDir := t.TempDir()
+	folder := filepath.Join(tmpDir, "2024", "07")
+	if err := os.MkdirAll(filepath.Join(folder, thumbnailDirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "video_2024-07-24_09-14-01.mp4"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, thumbnailDirName, "video_2024-07-24_09-14-01.jpg"), []byte("thumb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTimelapseHandler(tmpDir)
+	name := "2024/07/video_2024-07-24_09-14-01.mp4"
+
+	if w := callWithFilename(h.Delete, http.MethodDelete, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".trash", "2024", "07", thumbnailDirName, "video_2024-07-24_09-14-01.jpg")); err != nil {
+		t.Errorf("expected thumbnail in trash next to the video: %v", err)
+	}
+
+	items := listTrash(t, h)
+	if len(items) != 1 || items[0].Filename != name {
+		t.Fatalf("unexpected trash contents: %+v", items)
+	}
+
+	// Restoring recreates a folder that was cleaned up in the meantime
+	if err := os.RemoveAll(filepath.Join(tmpDir, "2024")); err != nil {
+		t.Fatal(err)
+	}
+	if w := callWithFilename(h.RestoreTrash, http.MethodPost, name); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on restore, got %d: %s", w.Code, w.Body.String())
+	}
+	snap, _ := h.catalog.Snapshot()
+	restored, ok := findTimelapse(snap.Items, name)
+	if !ok {
+		t.Fatal("expected restored video back in the catalog")
+	}
+	if restored.ThumbnailURL != "/videos/2024/07/thumbnail/video_2024-07-24_09-14-01.jpg" {
+		t.Errorf("unexpected thumbnail url %q", restored.ThumbnailURL)
+	}
+
+	// Folders in the trash are not timelapses
+	if w := callWithFilename(h.PurgeTrash, http.MethodDelete, "2024"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 purging a folder, got %d", w.Code)
+	}
+}
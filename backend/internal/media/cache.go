@@ -0,0 +1,75 @@
+package media
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache memoises Probe and Check results per path. An entry is reused only
+// while the file's size and modification time are unchanged, so files that
+// are still being written get probed again once they settle.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	size    int64
+	modTime time.Time
+	info    Info
+	err     error
+	health  Health
+}
+
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]cacheEntry)}
+}
+
+// Probe returns the metadata for path, probing the file only if fi differs
+// from the cached entry. Failures are cached too.
+func (c *Cache) Probe(path string, fi os.FileInfo) (Info, error) {
+	e := c.entry(path, fi)
+	return e.info, e.err
+}
+
+// Check returns the health of path, checking the file only if fi differs
+// from the cached entry.
+func (c *Cache) Check(path string, fi os.FileInfo) Health {
+	return c.entry(path, fi).health
+}
+
+func (c *Cache) entry(path string, fi os.FileInfo) cacheEntry {
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e
+	}
+
+	info, err := Probe(path)
+	e = cacheEntry{size: fi.Size(), modTime: fi.ModTime(), info: info, err: err, health: Check(path)}
+
+	c.mu.Lock()
+	c.entries[path] = e
+	c.mu.Unlock()
+	return e
+}
+
+// Forget drops the entry for path.
+func (c *Cache) Forget(path string) {
+	c.mu.Lock()
+	delete(c.entries, path)
+	c.mu.Unlock()
+}
+
+// Retain drops every entry whose path is not in keep.
+func (c *Cache) Retain(keep map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.entries {
+		if !keep[path] {
+			delete(c.entries, path)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Container health, from Check.
+const (
+	Healthy    = "healthy"
+	Incomplete = "incomplete"
+	Corrupt    = "corrupt"
+)
+
+// Health is the outcome of checking a container's structure. Reason says
+// what is wrong when Status is not Healthy.
+type Health struct {
+	Status string
+	Reason string
+}
+
+// Check validates the structure of the video at path.
+func Check(path string) Health {
+	f, err := os.Open(path)
+	if err != nil {
+		return Health{Status: Corrupt, Reason: err.Error()}
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return Health{Status: Corrupt, Reason: err.Error()}
+	}
+	return CheckReader(f, st.Size())
+}
+
+// CheckReader validates an MP4, Matroska or AVI stream of the given size.
+// Files cut short, as a download that never finished leaves them, are
+// Incomplete; files whose structure makes no sense are Corrupt.
+func CheckReader(r io.ReaderAt, size int64) Health {
+	if size == 0 {
+		return Health{Status: Incomplete, Reason: "empty file"}
+	}
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Health{Status: Corrupt, Reason: err.Error()}
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, ebmlMagic):
+		return checkMatroska(r, size)
+	case len(head) >= 8 && isMP4TopLevelBox(string(head[4:8])):
+		return checkMP4(r, size)
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return checkAVI(r, size)
+	case len(head) < 12:
+		return Health{Status: Incomplete, Reason: "file ends inside the header"}
+	default:
+		return Health{Status: Corrupt, Reason: "unknown container format"}
+	}
+}
+
+// checkMP4 requires a complete moov box with readable metadata and an
+// mdat box, none of them running past the end of the file.
+func checkMP4(r io.ReaderAt, size int64) Health {
+	var moov, mdat bool
+	err := walkBoxes(r, 0, size, func(b mp4Box) error {
+		switch b.typ {
+		case "moov":
+			moov = true
+		case "mdat":
+			mdat = true
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return Health{Status: Incomplete, Reason: "file ends inside a box"}
+	case err != nil:
+		return Health{Status: Corrupt, Reason: err.Error()}
+	case !moov:
+		return Health{Status: Incomplete, Reason: "no moov box"}
+	case !mdat:
+		return Health{Status: Incomplete, Reason: "no mdat box"}
+	}
+
+	if _, err := probeMP4(r, size); err != nil {
+		return Health{Status: Corrupt, Reason: "unreadable moov box: " + err.Error()}
+	}
+	return Health{Status: Healthy}
+}
+
+// checkMatroska requires an EBML header, segment info and, when the
+// segment declares its size, the whole segment.
+func checkMatroska(r io.ReaderAt, size int64) Health {
+	if _, err := probeMatroska(r, size); err != nil {
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return Health{Status: Incomplete, Reason: "file ends inside an element"}
+		case errors.Is(err, ErrNoMetadata):
+			return Health{Status: Incomplete, Reason: "no segment info"}
+		default:
+			return Health{Status: Corrupt, Reason: err.Error()}
+		}
+	}
+
+	// probeMatroska succeeded, so both headers parse
+	header, _ := readElementHeader(r, 0, size)
+	segment, _ := readElementHeader(r, header.start+int64(header.size), size)
+	if segment.size != unknownSize && segment.start+int64(segment.size) > size {
+		return Health{Status: Incomplete, Reason: "file ends inside the segment"}
+	}
+	return Health{Status: Healthy}
+}
+
+// checkAVI requires every top-level RIFF chunk to fit in the file. Large
+// OpenDML files continue in further RIFF AVIX chunks.
+func checkAVI(r io.ReaderAt, size int64) Health {
+	for off := int64(0); off < size; {
+		hdr, err := readAt(r, off, 8)
+		if err != nil {
+			return Health{Status: Incomplete, Reason: "file ends inside a chunk header"}
+		}
+		if string(hdr[0:4]) != "RIFF" {
+			return Health{Status: Corrupt, Reason: "unexpected chunk " + string(hdr[0:4])}
+		}
+		chunk := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		next := off + 8 + chunk + chunk%2
+		if off+8+chunk > size {
+			return Health{Status: Incomplete, Reason: "file ends inside a RIFF chunk"}
+		}
+		off = next
+	}
+	return Health{Status: Healthy}
+}
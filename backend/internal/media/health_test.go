@@ -0,0 +1,80 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+)
+
+func avi(chunk, data int) []byte {
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(chunk))
+	out = append(out, "AVI "...)
+	return append(out, make([]byte, data)...)
+}
+
+func TestCheckReader(t *testing.T) {
+	mp4 := mediatest.MP4(mediatest.Video{})
+	moovAtEnd := mediatest.MP4(mediatest.Video{MoovAtEnd: true, MdatSize: 4096})
+	mkv := mediatest.Matroska(mediatest.Video{MdatSize: 4096})
+
+	// Overwrite the first box's size with one too small to hold its header
+	badBox := bytes.Clone(mp4)
+	binary.BigEndian.PutUint32(badBox[0:4], 4)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"mp4", mp4, Healthy},
+		{"mp4 with moov at the end", moovAtEnd, Healthy},
+		{"mp4 cut before moov", moovAtEnd[:2048], Incomplete},
+		{"mp4 cut inside mdat", mp4[:len(mp4)-10], Incomplete},
+		{"mp4 without moov", mp4[:32], Incomplete},
+		{"mp4 with a bad box size", badBox, Corrupt},
+		{"mkv", mkv, Healthy},
+		{"mkv cut inside a cluster", mkv[:len(mkv)-100], Incomplete},
+		{"mkv cut inside the header", mkv[:20], Incomplete},
+		{"avi", avi(4+16, 16), Healthy},
+		{"avi cut short", avi(4+1000, 16), Incomplete},
+		{"empty", nil, Incomplete},
+		{"garbage", bytes.Repeat([]byte("garbage!"), 8), Corrupt},
+	}
+	for _, tt := range tests {
+		got := CheckReader(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if got.Status != tt.want {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.want, got)
+		}
+		if got.Status != Healthy && got.Reason == "" {
+			t.Errorf("%s: expected a reason", tt.name)
+		}
+	}
+}
+
+func TestCache_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	data := mediatest.MP4(mediatest.Video{})
+	if err := os.WriteFile(path, data[:len(data)-10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache()
+	fi, _ := os.Stat(path)
+	if h := cache.Check(path, fi); h.Status != Incomplete {
+		t.Errorf("expected incomplete, got %+v", h)
+	}
+
+	// The download finishing changes the size, so the file is checked again
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = os.Stat(path)
+	if h := cache.Check(path, fi); h.Status != Healthy {
+		t.Errorf("expected healthy, got %+v", h)
+	}
+}
@@ -0,0 +1,163 @@
+// Package mediatest builds minimal MP4 and Matroska files for tests.
+package mediatest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Video describes the file to build. Zero fields are filled with defaults.
+type Video struct {
+	Duration     time.Duration
+	Width        int
+	Height       int
+	Codec        string // h264, hevc, av1 or vp9
+	FPS          int
+	CreationTime time.Time
+	// MdatSize is the number of payload bytes in the media data section.
+	MdatSize int
+	// MoovAtEnd writes the MP4 moov box after mdat, as non-faststart
+	// encoders do.
+	MoovAtEnd bool
+}
+
+func (v Video) withDefaults() Video {
+	if v.Duration == 0 {
+		v.Duration = 10 * time.Second
+	}
+	if v.Width == 0 {
+		v.Width, v.Height = 1920, 1080
+	}
+	if v.Codec == "" {
+		v.Codec = "h264"
+	}
+	if v.FPS == 0 {
+		v.FPS = 30
+	}
+	if v.MdatSize == 0 {
+		v.MdatSize = 1024
+	}
+	return v
+}
+
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	out = append(out, typ...)
+	return append(out, body...)
+}
+
+func u16(v int) []byte       { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+func u32(v int) []byte       { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+func zeros(n int) []byte     { return make([]byte, n) }
+func fourcc(s string) []byte { return []byte(s) }
+
+// MP4 returns a minimal ISO BMFF file with a single video track.
+func MP4(v Video) []byte {
+	v = v.withDefaults()
+
+	var created int
+	if !v.CreationTime.IsZero() {
+		created = int(v.CreationTime.Sub(mp4Epoch) / time.Second)
+	}
+	movieScale := 1000
+	trackScale := v.FPS * 1000
+	frames := int(v.Duration.Seconds() * float64(v.FPS))
+
+	codec := map[string]string{"h264": "avc1", "hevc": "hvc1", "av1": "av01", "vp9": "vp09"}[v.Codec]
+	if codec == "" {
+		codec = v.Codec
+	}
+
+	mvhd := box("mvhd", zeros(4), u32(created), u32(created), u32(movieScale),
+		u32(int(v.Duration.Milliseconds())), zeros(80))
+	tkhd := box("tkhd", []byte{0, 0, 0, 3}, u32(created), u32(created), u32(1), zeros(4),
+		u32(int(v.Duration.Milliseconds())), zeros(8), zeros(8), zeros(36),
+		u32(v.Width<<16), u32(v.Height<<16))
+	mdhd := box("mdhd", zeros(4), u32(created), u32(created), u32(trackScale),
+		u32(frames*1000), zeros(4))
+	hdlr := box("hdlr", zeros(4), zeros(4), fourcc("vide"), zeros(12), []byte("VideoHandler\x00"))
+	sampleEntry := box(codec, zeros(6), u16(1), zeros(16), u16(v.Width), u16(v.Height), zeros(50))
+	stsd := box("stsd", zeros(4), u32(1), sampleEntry)
+	stts := box("stts", zeros(4), u32(1), u32(frames), u32(1000))
+	stbl := box("stbl", stsd, stts)
+	minf := box("minf", stbl)
+	mdia := box("mdia", mdhd, hdlr, minf)
+	trak := box("trak", tkhd, mdia)
+	moov := box("moov", mvhd, trak)
+
+	ftyp := box("ftyp", fourcc("isom"), u32(512), fourcc("isomiso2avc1mp41"))
+	mdat := box("mdat", zeros(v.MdatSize))
+
+	if v.MoovAtEnd {
+		return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+}
+
+func element(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var out []byte
+	switch {
+	case id > 0xFFFFFF:
+		out = binary.BigEndian.AppendUint32(nil, id)
+	case id > 0xFFFF:
+		out = []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		out = []byte{byte(id >> 8), byte(id)}
+	default:
+		out = []byte{byte(id)}
+	}
+	// Always use an 8-byte size field: 0x01 marker then 7 bytes of length
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	out = append(out, size...)
+	return append(out, body...)
+}
+
+func uintElement(id uint32, v uint64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+// Matroska returns a minimal Matroska file with a single video track.
+func Matroska(v Video) []byte {
+	v = v.withDefaults()
+
+	codec := map[string]string{
+		"h264": "V_MPEG4/ISO/AVC", "hevc": "V_MPEGH/ISO/HEVC", "av1": "V_AV1", "vp9": "V_VP9",
+	}[v.Codec]
+	if codec == "" {
+		codec = v.Codec
+	}
+
+	header := element(0x1A45DFA3,
+		uintElement(0x4286, 1),
+		element(0x4282, []byte("matroska")),
+	)
+
+	infoChildren := [][]byte{
+		uintElement(0x2AD7B1, 1000000),
+		element(0x4489, binary.BigEndian.AppendUint64(nil, math.Float64bits(float64(v.Duration.Milliseconds())))),
+	}
+	if !v.CreationTime.IsZero() {
+		infoChildren = append(infoChildren, uintElement(0x4461, uint64(v.CreationTime.Sub(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)))))
+	}
+	info := element(0x1549A966, infoChildren...)
+
+	tracks := element(0x1654AE6B, element(0xAE,
+		uintElement(0xD7, 1),
+		uintElement(0x83, 1),
+		element(0x86, []byte(codec)),
+		uintElement(0x23E383, uint64(time.Second)/uint64(v.FPS)),
+		element(0xE0, uintElement(0xB0, uint64(v.Width)), uintElement(0xBA, uint64(v.Height))),
+	))
+
+	cluster := element(0x1F43B675, uintElement(0xE7, 0), element(0xA3, zeros(v.MdatSize)))
+	segment := element(0x18538067, info, tracks, cluster)
+
+	return bytes.Join([][]byte{header, segment}, nil)
+}
@@ -0,0 +1,305 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Matroska element IDs, including their length marker bits.
+const (
+	mkvEBML            = 0x1A45DFA3
+	mkvDocType         = 0x4282
+	mkvSegment         = 0x18538067
+	mkvInfo            = 0x1549A966
+	mkvTimestampScale  = 0x2AD7B1
+	mkvDuration        = 0x4489
+	mkvDateUTC         = 0x4461
+	mkvTracks          = 0x1654AE6B
+	mkvTrackEntry      = 0xAE
+	mkvTrackType       = 0x83
+	mkvCodecID         = 0x86
+	mkvDefaultDuration = 0x23E383
+	mkvVideo           = 0xE0
+	mkvPixelWidth      = 0xB0
+	mkvPixelHeight     = 0xBA
+	mkvCluster         = 0x1F43B675
+)
+
+// mkvEpoch is the reference time of Matroska DateUTC values.
+var mkvEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// unknownSize marks an element whose size field is all ones, as written by
+// live muxers that cannot seek back.
+const unknownSize = math.MaxUint64
+
+var errBadVint = errors.New("media: malformed EBML variable-length integer")
+
+// decodeVint decodes an EBML variable-length integer. IDs keep their
+// marker bit; sizes have it stripped. Running out of bytes is reported as
+// io.ErrUnexpectedEOF.
+func decodeVint(b []byte, keepMarker bool) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if b[0] == 0 {
+		return 0, 0, errBadVint
+	}
+	n := bits.LeadingZeros8(b[0]) + 1
+	if len(b) < n {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	val := uint64(b[0])
+	if !keepMarker {
+		val &= uint64(0xFF >> n)
+	}
+	allOnes := val == uint64(0xFF>>n)
+	for _, c := range b[1:n] {
+		val = val<<8 | uint64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, n, nil
+	}
+	return val, n, nil
+}
+
+type ebmlElement struct {
+	id    uint32
+	start int64  // offset of the payload
+	size  uint64 // payload size, or unknownSize
+}
+
+func readElementHeader(r io.ReaderAt, off, end int64) (ebmlElement, error) {
+	buf := make([]byte, min(12, end-off))
+	n, err := r.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return ebmlElement{}, err
+	}
+	buf = buf[:n]
+
+	id, idLen, err := decodeVint(buf, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	if idLen > 4 {
+		return ebmlElement{}, errBadVint
+	}
+	size, sizeLen, err := decodeVint(buf[idLen:], false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	return ebmlElement{id: uint32(id), start: off + int64(idLen+sizeLen), size: size}, nil
+}
+
+// eachChild calls fn for each element in an in-memory master element.
+func eachChild(buf []byte, fn func(id uint32, data []byte)) error {
+	for len(buf) > 0 {
+		id, idLen, err := decodeVint(buf, true)
+		if err != nil {
+			return err
+		}
+		size, sizeLen, err := decodeVint(buf[idLen:], false)
+		if err != nil {
+			return err
+		}
+		start := idLen + sizeLen
+		if size == unknownSize || size > uint64(len(buf)-start) {
+			return io.ErrUnexpectedEOF
+		}
+		fn(uint32(id), buf[start:start+int(size)])
+		buf = buf[start+int(size):]
+	}
+	return nil
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func probeMatroska(r io.ReaderAt, size int64) (Info, error) {
+	header, err := readElementHeader(r, 0, size)
+	if err != nil {
+		return Info{}, err
+	}
+	if header.id != mkvEBML || header.size == unknownSize {
+		return Info{}, ErrUnknownFormat
+	}
+	headerData, err := readAt(r, header.start, int64(header.size))
+	if err != nil {
+		return Info{}, err
+	}
+	var docType string
+	if err := eachChild(headerData, func(id uint32, data []byte) {
+		if id == mkvDocType {
+			docType = string(data)
+		}
+	}); err != nil {
+		return Info{}, err
+	}
+	if docType != "matroska" && docType != "webm" {
+		return Info{}, fmt.Errorf("media: unsupported EBML doctype %q", docType)
+	}
+
+	segment, err := readElementHeader(r, header.start+int64(header.size), size)
+	if err != nil {
+		return Info{}, err
+	}
+	if segment.id != mkvSegment {
+		return Info{}, ErrNoMetadata
+	}
+	end := size
+	if segment.size != unknownSize && segment.start+int64(segment.size) < end {
+		end = segment.start + int64(segment.size)
+	}
+
+	var info Info
+	var foundInfo bool
+	for off := segment.start; off < end; {
+		el, err := readElementHeader(r, off, end)
+		if err != nil {
+			return Info{}, err
+		}
+		// Info and Tracks precede the first cluster in practice
+		if el.id == mkvCluster || el.size == unknownSize {
+			break
+		}
+		if el.start+int64(el.size) > end {
+			return Info{}, io.ErrUnexpectedEOF
+		}
+
+		switch el.id {
+		case mkvInfo:
+			data, err := readAt(r, el.start, int64(el.size))
+			if err != nil {
+				return Info{}, err
+			}
+			if err := parseMkvInfo(data, &info); err != nil {
+				return Info{}, err
+			}
+			foundInfo = true
+		case mkvTracks:
+			data, err := readAt(r, el.start, int64(el.size))
+			if err != nil {
+				return Info{}, err
+			}
+			if err := parseMkvTracks(data, &info); err != nil {
+				return Info{}, err
+			}
+		}
+		off = el.start + int64(el.size)
+	}
+
+	if !foundInfo {
+		return Info{}, ErrNoMetadata
+	}
+	return info, nil
+}
+
+func parseMkvInfo(data []byte, info *Info) error {
+	scale := uint64(1000000)
+	var duration float64
+	err := eachChild(data, func(id uint32, v []byte) {
+		switch id {
+		case mkvTimestampScale:
+			if s := ebmlUint(v); s != 0 {
+				scale = s
+			}
+		case mkvDuration:
+			duration = ebmlFloat(v)
+		case mkvDateUTC:
+			if len(v) == 8 {
+				info.CreationTime = mkvEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(v))))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	info.Duration = time.Duration(duration * float64(scale))
+	return nil
+}
+
+func parseMkvTracks(data []byte, info *Info) error {
+	var found bool
+	var parseErr error
+	err := eachChild(data, func(id uint32, entry []byte) {
+		if id != mkvTrackEntry || found || parseErr != nil {
+			return
+		}
+
+		var trackType, frameDuration uint64
+		var codec string
+		var width, height int
+		parseErr = eachChild(entry, func(id uint32, v []byte) {
+			switch id {
+			case mkvTrackType:
+				trackType = ebmlUint(v)
+			case mkvCodecID:
+				codec = string(v)
+			case mkvDefaultDuration:
+				frameDuration = ebmlUint(v)
+			case mkvVideo:
+				_ = eachChild(v, func(id uint32, v []byte) {
+					switch id {
+					case mkvPixelWidth:
+						width = int(ebmlUint(v))
+					case mkvPixelHeight:
+						height = int(ebmlUint(v))
+					}
+				})
+			}
+		})
+		if trackType != 1 {
+			return
+		}
+
+		found = true
+		info.Width, info.Height = width, height
+		info.Codec = mkvCodecName(codec)
+		if frameDuration > 0 {
+			info.FPS = float64(time.Second) / float64(frameDuration)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return parseErr
+}
+
+func mkvCodecName(id string) string {
+	switch id {
+	case "V_MPEG4/ISO/AVC":
+		return "h264"
+	case "V_MPEGH/ISO/HEVC":
+		return "hevc"
+	case "V_AV1":
+		return "av1"
+	case "V_VP9":
+		return "vp9"
+	case "V_VP8":
+		return "vp8"
+	case "V_MPEG4/ISO/SP", "V_MPEG4/ISO/ASP":
+		return "mpeg4"
+	}
+	return id
+}
@@ -0,0 +1,263 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// mp4Epoch is the reference time of ISO BMFF timestamps.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type mp4Box struct {
+	typ   string
+	start int64 // offset of the payload
+	size  int64 // payload size
+}
+
+// walkBoxes calls fn for each box between start and end. A box whose size
+// runs past end is reported as io.ErrUnexpectedEOF.
+func walkBoxes(r io.ReaderAt, start, end int64, fn func(b mp4Box) error) error {
+	off := start
+	for off+8 <= end {
+		hdr, err := readAt(r, off, 8)
+		if err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+
+		switch size {
+		case 0:
+			// Box extends to the end of its parent
+			size = end - off
+		case 1:
+			ext, err := readAt(r, off+8, 8)
+			if err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(ext))
+			headerLen = 16
+		}
+
+		if size < headerLen {
+			return fmt.Errorf("media: invalid %q box size %d at offset %d", typ, size, off)
+		}
+		if off+size > end {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err := fn(mp4Box{typ: typ, start: off + headerLen, size: size - headerLen}); err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+func probeMP4(r io.ReaderAt, size int64) (Info, error) {
+	var moov *mp4Box
+	err := walkBoxes(r, 0, size, func(b mp4Box) error {
+		if b.typ == "moov" {
+			moov = &b
+		}
+		return nil
+	})
+	// A truncated mdat after a complete moov still leaves usable metadata
+	if err != nil && (moov == nil || !errors.Is(err, io.ErrUnexpectedEOF)) {
+		return Info{}, err
+	}
+	if moov == nil {
+		return Info{}, ErrNoMetadata
+	}
+
+	var info Info
+	var foundVideo bool
+	err = walkBoxes(r, moov.start, moov.start+moov.size, func(b mp4Box) error {
+		switch b.typ {
+		case "mvhd":
+			return parseMvhd(r, b, &info)
+		case "trak":
+			if foundVideo {
+				return nil
+			}
+			track, ok, err := parseTrak(r, b)
+			if err != nil || !ok {
+				return err
+			}
+			foundVideo = true
+			info.Width, info.Height = track.width, track.height
+			info.Codec, info.FPS = track.codec, track.fps
+			if info.Duration == 0 {
+				info.Duration = track.duration
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+func parseMvhd(r io.ReaderAt, b mp4Box, info *Info) error {
+	buf, err := readAt(r, b.start, min(b.size, 32))
+	if err != nil {
+		return err
+	}
+
+	var created uint64
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(buf) >= 32 && buf[0] == 1:
+		created = binary.BigEndian.Uint64(buf[4:12])
+		timescale = binary.BigEndian.Uint32(buf[20:24])
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	case len(buf) >= 20 && buf[0] == 0:
+		created = uint64(binary.BigEndian.Uint32(buf[4:8]))
+		timescale = binary.BigEndian.Uint32(buf[12:16])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	default:
+		return fmt.Errorf("media: malformed mvhd box")
+	}
+
+	if created != 0 {
+		info.CreationTime = mp4Epoch.Add(time.Duration(created) * time.Second)
+	}
+	if timescale != 0 {
+		info.Duration = scaleDuration(duration, timescale)
+	}
+	return nil
+}
+
+type mp4Track struct {
+	width, height int
+	codec         string
+	fps           float64
+	duration      time.Duration
+}
+
+// parseTrak extracts video track details, reporting ok=false for
+// non-video tracks.
+func parseTrak(r io.ReaderAt, trak mp4Box) (mp4Track, bool, error) {
+	var track mp4Track
+	var handler string
+	var timescale uint32
+
+	var walk func(b mp4Box) error
+	walk = func(b mp4Box) error {
+		switch b.typ {
+		case "mdia", "minf", "stbl":
+			return walkBoxes(r, b.start, b.start+b.size, walk)
+		case "tkhd":
+			buf, err := readAt(r, b.start, min(b.size, 96))
+			if err != nil {
+				return err
+			}
+			// Width and height are 16.16 fixed point at the end of the box
+			off := 76
+			if len(buf) > 0 && buf[0] == 1 {
+				off = 88
+			}
+			if len(buf) >= off+8 {
+				track.width = int(binary.BigEndian.Uint32(buf[off:off+4]) >> 16)
+				track.height = int(binary.BigEndian.Uint32(buf[off+4:off+8]) >> 16)
+			}
+		case "mdhd":
+			buf, err := readAt(r, b.start, min(b.size, 32))
+			if err != nil {
+				return err
+			}
+			var duration uint64
+			if len(buf) >= 32 && buf[0] == 1 {
+				timescale = binary.BigEndian.Uint32(buf[20:24])
+				duration = binary.BigEndian.Uint64(buf[24:32])
+			} else if len(buf) >= 20 {
+				timescale = binary.BigEndian.Uint32(buf[12:16])
+				duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+			}
+			if timescale != 0 {
+				track.duration = scaleDuration(duration, timescale)
+			}
+		case "hdlr":
+			buf, err := readAt(r, b.start, min(b.size, 12))
+			if err != nil {
+				return err
+			}
+			if len(buf) >= 12 {
+				handler = string(buf[8:12])
+			}
+		case "stsd":
+			buf, err := readAt(r, b.start, min(b.size, 48))
+			if err != nil {
+				return err
+			}
+			// Full box header, entry count, then the first sample entry
+			if len(buf) >= 16 {
+				track.codec = mp4CodecName(string(buf[12:16]))
+			}
+			// Visual sample entries carry dimensions as a fallback for tkhd
+			if len(buf) >= 44 && track.width == 0 {
+				track.width = int(binary.BigEndian.Uint16(buf[40:42]))
+				track.height = int(binary.BigEndian.Uint16(buf[42:44]))
+			}
+		case "stts":
+			// Variable frame rate tables can be huge; skip rather than fail
+			if b.size > 1<<20 {
+				return nil
+			}
+			buf, err := readAt(r, b.start, b.size)
+			if err != nil {
+				return err
+			}
+			if len(buf) < 8 {
+				return nil
+			}
+			count := int(binary.BigEndian.Uint32(buf[4:8]))
+			var samples, ticks uint64
+			for i := 0; i < count && 8+i*8+8 <= len(buf); i++ {
+				entry := buf[8+i*8:]
+				n := uint64(binary.BigEndian.Uint32(entry[0:4]))
+				samples += n
+				ticks += n * uint64(binary.BigEndian.Uint32(entry[4:8]))
+			}
+			if ticks > 0 && timescale > 0 {
+				track.fps = float64(samples) * float64(timescale) / float64(ticks)
+			}
+		}
+		return nil
+	}
+
+	if err := walkBoxes(r, trak.start, trak.start+trak.size, walk); err != nil {
+		return track, false, err
+	}
+	return track, handler == "vide", nil
+}
+
+func scaleDuration(value uint64, timescale uint32) time.Duration {
+	secs := value / uint64(timescale)
+	rem := value % uint64(timescale)
+	return time.Duration(secs)*time.Second + time.Duration(rem)*time.Second/time.Duration(timescale)
+}
+
+func mp4CodecName(fourcc string) string {
+	switch fourcc {
+	case "avc1", "avc3":
+		return "h264"
+	case "hvc1", "hev1":
+		return "hevc"
+	case "av01":
+		return "av1"
+	case "vp09":
+		return "vp9"
+	case "vp08":
+		return "vp8"
+	case "mp4v":
+		return "mpeg4"
+	}
+	return fourcc
+}
@@ -0,0 +1,89 @@
+// Package media reads container metadata from timelapse videos without
+// shelling out to ffprobe.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrUnknownFormat = errors.New("media: unknown container format")
+	ErrNoMetadata    = errors.New("media: container has no metadata")
+)
+
+// Info is the subset of container metadata the gallery displays. Zero
+// values mean the field was not present in the file.
+type Info struct {
+	Duration     time.Duration
+	Width        int
+	Height       int
+	Codec        string
+	FPS          float64
+	CreationTime time.Time
+}
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Probe reads the metadata of the MP4 or Matroska file at path.
+func Probe(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	return ProbeReader(f, st.Size())
+}
+
+// ProbeReader reads metadata from an MP4 or Matroska stream of the given size.
+func ProbeReader(r io.ReaderAt, size int64) (Info, error) {
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Info{}, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, ebmlMagic):
+		return probeMatroska(r, size)
+	case len(head) >= 8 && isMP4TopLevelBox(string(head[4:8])):
+		return probeMP4(r, size)
+	default:
+		return Info{}, ErrUnknownFormat
+	}
+}
+
+func isMP4TopLevelBox(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pdin", "styp":
+		return true
+	}
+	return false
+}
+
+// readAt reads exactly n bytes at off, refusing implausibly large reads
+// caused by corrupt size fields.
+func readAt(r io.ReaderAt, off, n int64) ([]byte, error) {
+	const maxRead = 16 << 20
+	if n < 0 || n > maxRead {
+		return nil, fmt.Errorf("media: refusing to read %d bytes at offset %d", n, off)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,153 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codyseavey/3d-printer/backend/internal/media/mediatest"
+)
+
+func checkInfo(t *testing.T, got Info, want mediatest.Video) {
+	t.Helper()
+
+	if got.Duration != want.Duration {
+		t.Errorf("duration = %v, want %v", got.Duration, want.Duration)
+	}
+	if got.Width != want.Width || got.Height != want.Height {
+		t.Errorf("resolution = %dx%d, want %dx%d", got.Width, got.Height, want.Width, want.Height)
+	}
+	if got.Codec != want.Codec {
+		t.Errorf("codec = %q, want %q", got.Codec, want.Codec)
+	}
+	if math.Abs(got.FPS-float64(want.FPS)) > 0.01 {
+		t.Errorf("fps = %v, want %v", got.FPS, want.FPS)
+	}
+	if !got.CreationTime.Equal(want.CreationTime) {
+		t.Errorf("creation time = %v, want %v", got.CreationTime, want.CreationTime)
+	}
+}
+
+func TestProbeMP4(t *testing.T) {
+	want := mediatest.Video{
+		Duration:     42 * time.Second,
+		Width:        1920,
+		Height:       1080,
+		Codec:        "h264",
+		FPS:          30,
+		CreationTime: time.Date(2024, 7, 24, 9, 14, 1, 0, time.UTC),
+	}
+
+	for _, moovAtEnd := range []bool{false, true} {
+		want.MoovAtEnd = moovAtEnd
+		data := mediatest.MP4(want)
+
+		info, err := ProbeReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("moovAtEnd=%v: probe failed: %v", moovAtEnd, err)
+		}
+		checkInfo(t, info, want)
+	}
+}
+
+func TestProbeMP4_HEVC(t *testing.T) {
+	want := mediatest.Video{Duration: 5 * time.Second, Width: 1280, Height: 720, Codec: "hevc", FPS: 60}
+	data := mediatest.MP4(want)
+
+	info, err := ProbeReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	checkInfo(t, info, want)
+}
+
+func TestProbeMP4_TruncatedWithoutMoov(t *testing.T) {
+	// Half-written non-faststart file: mdat is cut off before moov
+	data := mediatest.MP4(mediatest.Video{MoovAtEnd: true, MdatSize: 4096})
+	data = data[:2048]
+
+	_, err := ProbeReader(bytes.NewReader(data), int64(len(data)))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestProbeMP4_NoMoov(t *testing.T) {
+	data := mediatest.MP4(mediatest.Video{})
+	// Keep only ftyp, which is the first 32 bytes
+	data = data[:32]
+
+	_, err := ProbeReader(bytes.NewReader(data), int64(len(data)))
+	if !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("expected ErrNoMetadata, got %v", err)
+	}
+}
+
+func TestProbeMatroska(t *testing.T) {
+	want := mediatest.Video{
+		Duration:     90 * time.Second,
+		Width:        3840,
+		Height:       2160,
+		Codec:        "vp9",
+		FPS:          25,
+		CreationTime: time.Date(2024, 8, 15, 14, 30, 0, 0, time.UTC),
+	}
+	data := mediatest.Matroska(want)
+
+	info, err := ProbeReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	checkInfo(t, info, want)
+}
+
+func TestProbeUnknownFormat(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("RIFF\x00\x00\x00\x00AVI LIST"), make([]byte, 64)} {
+		if _, err := ProbeReader(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("expected ErrUnknownFormat for %q, got %v", data, err)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, mediatest.MP4(mediatest.Video{Duration: 10 * time.Second}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := cache.Probe(path, fi)
+	if err != nil || info.Duration != 10*time.Second {
+		t.Fatalf("unexpected first probe: %v, %v", info, err)
+	}
+
+	// Same size and mtime: served from cache even though the file changed
+	if err := os.WriteFile(path, mediatest.MP4(mediatest.Video{Duration: 20 * time.Second}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := cache.Probe(path, fi); info.Duration != 10*time.Second {
+		t.Errorf("expected cached duration 10s, got %v", info.Duration)
+	}
+
+	// A new mtime invalidates the entry
+	later := fi.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = os.Stat(path)
+	if info, _ := cache.Probe(path, fi); info.Duration != 20*time.Second {
+		t.Errorf("expected reprobed duration 20s, got %v", info.Duration)
+	}
+}
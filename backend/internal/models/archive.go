@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ArchiveManifest is written as manifest.json at the end of a ZIP download
+// and lists every timelapse the archive contains, with its metadata.
+// Thumbnails are the archive paths of each item's thumbnail images.
+type ArchiveManifest struct {
+	CreatedAt time.Time     `json:"createdAt"`
+	Items     []ArchiveItem `json:"items"`
+}
+
+// ArchiveItem is one timelapse in an archive manifest.
+type ArchiveItem struct {
+	Timelapse
+	Thumbnails []string `json:"thumbnails"`
+}
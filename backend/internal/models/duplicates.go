@@ -0,0 +1,29 @@
+package models
+
+// DuplicateGroup is a set of timelapses with the same content. Keep is the
+// copy a dedupe keeps. SHA256 is set once every file in the group has been
+// hashed in full; until then the files only share a partial hash.
+type DuplicateGroup struct {
+	Size        int64       `json:"size"`
+	PartialHash string      `json:"partialHash"`
+	SHA256      string      `json:"sha256,omitempty"`
+	Keep        string      `json:"keep"`
+	Items       []Timelapse `json:"items"`
+}
+
+// DuplicateReport lists groups of duplicates, largest first.
+// ReclaimableBytes is what removing every copy but one would free.
+type DuplicateReport struct {
+	Groups           []DuplicateGroup `json:"groups"`
+	Verified         bool             `json:"verified"`
+	DuplicateFiles   int              `json:"duplicateFiles"`
+	ReclaimableBytes int64            `json:"reclaimableBytes"`
+}
+
+// DedupedFile is a duplicate a dedupe moved to the trash, and the copy it
+// kept.
+type DedupedFile struct {
+	Filename string `json:"filename"`
+	Kept     string `json:"kept"`
+	Size     int64  `json:"size"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// HealthIssue is a timelapse whose container is not healthy.
+type HealthIssue struct {
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Date     time.Time `json:"date"`
+	Health   string    `json:"health"`
+	Reason   string    `json:"reason"`
+}
+
+// QuarantinedFile is a video moved out of the library into the quarantine
+// directory. Filename is its original path relative to the library.
+type QuarantinedFile struct {
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Health   string    `json:"health"`
+	Reason   string    `json:"reason"`
+}
+
+// QuarantineStatus describes the quarantine directory. Enabled reports
+// whether unhealthy files are moved there automatically.
+type QuarantineStatus struct {
+	Enabled bool              `json:"enabled"`
+	Files   []QuarantinedFile `json:"files"`
+}
+
+// HealthReport counts timelapses by container health and lists every one
+// that is not healthy, newest first.
+type HealthReport struct {
+	Total      int              `json:"total"`
+	Healthy    int              `json:"healthy"`
+	Incomplete int              `json:"incomplete"`
+	Corrupt    int              `json:"corrupt"`
+	Issues     []HealthIssue    `json:"issues"`
+	Quarantine QuarantineStatus `json:"quarantine"`
+}
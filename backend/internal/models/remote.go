@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// How the URLs of remote timelapses are handed out: presigned URLs
+// straight to the object store, or proxied through the API.
+const (
+	RemoteURLsPresign = "presign"
+	RemoteURLsProxy   = "proxy"
+)
+
+// OffloadedFile is a timelapse moved to remote storage.
+type OffloadedFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// OffloadRun records one pass of the offload worker.
+type OffloadRun struct {
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	Files      []OffloadedFile `json:"files"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// RemoteStatus describes the remote storage configuration and what lives
+// there. OffloadAfterDays is zero when timelapses are only offloaded on
+// request.
+type RemoteStatus struct {
+	Enabled          bool        `json:"enabled"`
+	Storage          string      `json:"storage,omitempty"`
+	URLs             string      `json:"urls,omitempty"`
+	OffloadAfterDays int         `json:"offloadAfterDays"`
+	Count            int         `json:"count"`
+	Bytes            int64       `json:"bytes"`
+	LastRun          *OffloadRun `json:"lastRun"`
+}
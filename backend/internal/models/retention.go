@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Reasons a retention run removes a timelapse.
+const (
+	RetentionReasonMaxAge        = "max_age"
+	RetentionReasonMaxTotalBytes = "max_total_bytes"
+)
+
+// RetentionPolicy describes which timelapses the retention worker removes.
+// Zero values disable a rule. The newest KeepNewest timelapses, and starred
+// ones when ProtectStarred is set, are never removed.
+type RetentionPolicy struct {
+	MaxAgeDays     int   `json:"maxAgeDays"`
+	MaxTotalBytes  int64 `json:"maxTotalBytes"`
+	KeepNewest     int   `json:"keepNewest"`
+	ProtectStarred bool  `json:"protectStarred"`
+}
+
+// RetentionCandidate is a timelapse a retention run would remove.
+type RetentionCandidate struct {
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Date     time.Time `json:"date"`
+	Reason   string    `json:"reason"`
+}
+
+// RetentionPlan is the outcome of evaluating the policy against the
+// current catalog.
+type RetentionPlan struct {
+	Policy      RetentionPolicy      `json:"policy"`
+	EvaluatedAt time.Time            `json:"evaluatedAt"`
+	Candidates  []RetentionCandidate `json:"candidates"`
+	TotalBytes  int64                `json:"totalBytes"`
+	FreedBytes  int64                `json:"freedBytes"`
+}
+
+// RetentionLogEntry records a timelapse removed by the retention worker.
+type RetentionLogEntry struct {
+	RetentionCandidate
+	RemovedAt time.Time `json:"removedAt"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// SearchResult is a timelapse matching a search query with the fields that
+// matched split into highlighted fragments.
+type SearchResult struct {
+	Timelapse
+	Score      float64           `json:"score"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+// SearchHighlight is one matched field: "filename", "date", "tags" or
+// "notes". Long notes are cut down to the text around the first match.
+type SearchHighlight struct {
+	Field     string              `json:"field"`
+	Fragments []HighlightFragment `json:"fragments"`
+}
+
+// HighlightFragment is a run of text that either matched the query or sits
+// between matches.
+type HighlightFragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// SearchPage is the response to a search. From and To echo the date range
+// recognised in the query, if any.
+type SearchPage struct {
+	Query string         `json:"query"`
+	Items []SearchResult `json:"items"`
+	Total int            `json:"total"`
+	From  time.Time      `json:"from,omitzero"`
+	To    time.Time      `json:"to,omitzero"`
+}
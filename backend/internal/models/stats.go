@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// PeriodCount is the number and total size of timelapses dated within one
+// period: a day ("2024-07-01"), an ISO week ("2024-W27") or a month
+// ("2024-07").
+type PeriodCount struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// FormatCount is the number and total size of timelapses in one container
+// format, named by its extension ("mp4").
+type FormatCount struct {
+	Format string `json:"format"`
+	Count  int    `json:"count"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// StorageUsage describes the filesystem holding the timelapse directory.
+// FreeBytes is what is available to the server, which may be less than
+// TotalBytes minus UsedBytes on filesystems that reserve blocks.
+type StorageUsage struct {
+	TotalBytes int64 `json:"totalBytes"`
+	UsedBytes  int64 `json:"usedBytes"`
+	FreeBytes  int64 `json:"freeBytes"`
+}
+
+// LibraryStats summarises a printer's timelapse library. Period counts
+// only list periods with at least one timelapse, oldest first. Storage is
+// nil where the platform cannot report it.
+type LibraryStats struct {
+	Count        int           `json:"count"`
+	TotalBytes   int64         `json:"totalBytes"`
+	AverageBytes int64         `json:"averageBytes"`
+	Oldest       time.Time     `json:"oldest,omitzero"`
+	Newest       time.Time     `json:"newest,omitzero"`
+	PerDay       []PeriodCount `json:"perDay"`
+	PerWeek      []PeriodCount `json:"perWeek"`
+	PerMonth     []PeriodCount `json:"perMonth"`
+	Formats      []FormatCount `json:"formats"`
+	Storage      *StorageUsage `json:"storage"`
+	Generation   uint64        `json:"generation"`
+}
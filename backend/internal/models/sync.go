@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// SyncedFile is a file downloaded from the printer.
+type SyncedFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// SyncRun records one mirror of the printer's timelapse folder. Files
+// lists what was downloaded; Bytes also counts partial downloads that will
+// resume on the next run.
+type SyncRun struct {
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Files      []SyncedFile `json:"files"`
+	Bytes      int64        `json:"bytes"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// SyncProgress describes the run in flight. FileBytes counts from the
+// start of the file, including any part resumed from an earlier run.
+type SyncProgress struct {
+	StartedAt time.Time `json:"startedAt"`
+	File      string    `json:"file,omitempty"`
+	FileBytes int64     `json:"fileBytes"`
+	FileSize  int64     `json:"fileSize"`
+	Files     int       `json:"files"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// SyncStatus describes the printer sync worker. NextRun is zero when no
+// run is scheduled, and LastError is from the most recent failed run.
+type SyncStatus struct {
+	Enabled     bool          `json:"enabled"`
+	Running     bool          `json:"running"`
+	Progress    *SyncProgress `json:"progress"`
+	LastRun     *SyncRun      `json:"lastRun"`
+	NextRun     time.Time     `json:"nextRun,omitzero"`
+	LastError   string        `json:"lastError,omitempty"`
+	LastErrorAt time.Time     `json:"lastErrorAt,omitzero"`
+}
@@ -2,15 +2,169 @@ package models
 
 import "time"
 
+// Where a timelapse's date came from, in order of preference.
+const (
+	DateSourceFilename = "filename"
+	DateSourceMetadata = "metadata"
+	DateSourceModTime  = "mtime"
+)
+
+// Transcoding states. MP4s are native and never transcoded; other formats
+// start out as none until a rendition is requested.
+const (
+	TranscodeNative      = "native"
+	TranscodeNone        = "none"
+	TranscodeQueued      = "queued"
+	TranscodeRunning     = "running"
+	TranscodeReady       = "ready"
+	TranscodeFailed      = "failed"
+	TranscodeUnavailable = "unavailable"
+)
+
+// Container health of a timelapse. Incomplete files end early, as an
+// interrupted download leaves them; corrupt ones have a broken structure.
+const (
+	HealthHealthy    = "healthy"
+	HealthIncomplete = "incomplete"
+	HealthCorrupt    = "corrupt"
+)
+
+// Timelapse is one video in the timelapse directory. Filename is its
+// slash-separated path relative to the directory and Folder the part
+// before the last slash ("" for the top level).
 type Timelapse struct {
 	Filename     string    `json:"filename"`
+	Folder       string    `json:"folder"`
 	URL          string    `json:"url"`
 	ThumbnailURL string    `json:"thumbnailUrl"`
 	Size         int64     `json:"size"`
 	Date         time.Time `json:"date"`
+	DateSource   string    `json:"dateSource"`
+
+	// Media metadata probed from the container; omitted when unknown.
+	// Duration is in seconds.
+	Duration     float64   `json:"duration,omitempty"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	Codec        string    `json:"codec,omitempty"`
+	FPS          float64   `json:"fps,omitempty"`
+	CreationTime time.Time `json:"creationTime,omitzero"`
+
+	// Health is one of the Health constants; HealthReason explains why a
+	// file is not healthy.
+	Health       string `json:"health"`
+	HealthReason string `json:"healthReason,omitempty"`
+
+	// PlayableURL is what a browser should play: URL itself for MP4s, or
+	// the MP4 rendition once transcoding is ready. Empty until then.
+	PlayableURL    string `json:"playableUrl"`
+	TranscodeState string `json:"transcodeState"`
+
+	// SpriteURL and VTTURL are the scrubbing preview sprite sheet and its
+	// WebVTT thumbnails track, once generated.
+	SpriteURL string `json:"spriteUrl,omitempty"`
+	VTTURL    string `json:"vttUrl,omitempty"`
+
+	// ModTime is the video's modification time, kept for the server's own
+	// bookkeeping.
+	ModTime time.Time `json:"-"`
+
+	// Remote is set when the video has been offloaded to remote storage.
+	// URL then points at the object, through a presigned URL or the API.
+	Remote bool `json:"remote,omitempty"`
+
+	// Clip is set when the video was cut from another timelapse.
+	Clip *ClipInfo `json:"clip,omitempty"`
+
+	// User-entered metadata, flattened into the JSON; nil when nothing
+	// has been recorded.
+	*TimelapseMeta
+}
+
+// TimelapseMeta is what users record about a timelapse. Rating is 1-5, or
+// 0 when unrated.
+type TimelapseMeta struct {
+	Tags      []string  `json:"tags"`
+	Notes     string    `json:"notes"`
+	Starred   bool      `json:"starred"`
+	Rating    int       `json:"rating"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
+// ClipInfo links a clip to its source. Start and End are the cut points in
+// the source, in seconds. Source is empty if the source no longer exists.
+type ClipInfo struct {
+	Source string  `json:"source"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+}
+
+// TranscodeStatus reports the MP4 rendition of one timelapse. Error is set
+// when the last attempt failed.
+type TranscodeStatus struct {
+	Filename    string `json:"filename"`
+	State       string `json:"state"`
+	PlayableURL string `json:"playableUrl"`
+	Error       string `json:"error,omitempty"`
+}
+
+// TimelapseDetail is a single timelapse with its neighbours in date order
+// and every thumbnail image that belongs to it.
+type TimelapseDetail struct {
+	Timelapse
+	Thumbnails []string   `json:"thumbnails"`
+	Previous   *Timelapse `json:"previous"`
+	Next       *Timelapse `json:"next"`
+}
+
+// TimelapsePage is one page of a filtered, sorted timelapse listing.
+// Total counts the items matching the filters; TotalUnfiltered counts
+// every timelapse in the directory. Generation and ScannedAt describe the
+// catalog state the page was built from.
+type TimelapsePage struct {
+	Items           []Timelapse `json:"items"`
+	Page            int         `json:"page"`
+	Limit           int         `json:"limit"`
+	Total           int         `json:"total"`
+	TotalUnfiltered int         `json:"totalUnfiltered"`
+	TotalPages      int         `json:"totalPages"`
+	Next            string      `json:"next,omitempty"`
+	Prev            string      `json:"prev,omitempty"`
+	Generation      uint64      `json:"generation"`
+	ScannedAt       time.Time   `json:"scannedAt"`
+}
+
+// TrashedTimelapse is a deleted timelapse awaiting restore or purge.
+// PurgeAt is omitted when automatic purging is disabled.
+type TrashedTimelapse struct {
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	DeletedAt  time.Time `json:"deletedAt"`
+	PurgeAt    time.Time `json:"purgeAt,omitzero"`
+	Thumbnails []string  `json:"thumbnails"`
 }
 
 type StreamStatus struct {
 	Online      bool      `json:"online"`
 	LastUpdated time.Time `json:"lastUpdated"`
 }
+
+// Printer is one configured printer with a summary of its live stream and
+// timelapse directory.
+type Printer struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	StreamURL       string       `json:"streamUrl"`
+	Stream          StreamStatus `json:"stream"`
+	TimelapseCount  int          `json:"timelapseCount"`
+	TimelapseBytes  int64        `json:"timelapseBytes"`
+	LatestTimelapse time.Time    `json:"latestTimelapse,omitzero"`
+}
+
+// PrinterList is every configured printer plus totals across all of them.
+type PrinterList struct {
+	Printers       []Printer `json:"printers"`
+	Online         int       `json:"online"`
+	TimelapseCount int       `json:"timelapseCount"`
+	TimelapseBytes int64     `json:"timelapseBytes"`
+}
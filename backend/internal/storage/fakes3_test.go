@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-process stand-in for an S3-compatible service with
+// path-style addressing. It checks every request's SigV4 signature and
+// pages listings two keys at a time.
+type fakeS3 struct {
+	*httptest.Server
+	signer signer
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{
+		signer:  signer{accessKeyID: "test-key", secretAccessKey: "test-secret", region: "eu-test-1", service: "s3"},
+		bucket:  "timelapses",
+		objects: make(map[string]fakeObject),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeS3) config() S3Config {
+	return S3Config{
+		Endpoint:        f.URL,
+		Region:          f.signer.region,
+		Bucket:          f.bucket,
+		AccessKeyID:     f.signer.accessKeyID,
+		SecretAccessKey: f.signer.secretAccessKey,
+		PathStyle:       true,
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// authorized recomputes the request's signature, from its Authorization
+// header or presigned query.
+func (f *fakeS3) authorized(r *http.Request) bool {
+	u := *r.URL
+	u.Host = r.Host
+	if sig := r.URL.Query().Get("X-Amz-Signature"); sig != "" {
+		q := r.URL.Query()
+		q.Del("X-Amz-Signature")
+		t, err := time.Parse(amzDateFormat, q.Get("X-Amz-Date"))
+		expires, _ := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || time.Now().After(t.Add(time.Duration(expires)*time.Second)) {
+			return false
+		}
+		u.RawQuery = ""
+		f.signer.presign(&u, t, time.Duration(expires)*time.Second)
+		return u.Query().Get("X-Amz-Signature") == sig
+	}
+
+	t, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req := &http.Request{Method: r.Method, URL: &u, Header: http.Header{}}
+	for _, name := range []string{"Range"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	f.signer.sign(req, r.Header.Get("X-Amz-Content-Sha256"), t)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(r) {
+		f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, exists := f.objects[key]
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			f.fail(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, modTime: time.Now().UTC().Truncate(time.Second)}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case !exists:
+		f.fail(w, http.StatusNotFound, "NoSuchKey")
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		data, status := obj.data, http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); n == 0 {
+				f.fail(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			} else if n == 1 {
+				end = len(data) - 1
+			}
+			end = min(end, len(data)-1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data, status = data[start:end+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	if q.Get("list-type") != "2" {
+		f.fail(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, q.Get("prefix")) && key > q.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	if len(keys) > 2 {
+		keys = keys[:2]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[1]
+	}
+	for _, key := range keys {
+		obj := f.objects[key]
+		result.Contents = append(result.Contents, content{Key: key, Size: len(obj.data), LastModified: obj.modTime.Format(time.RFC3339)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config describes an S3-compatible bucket. Endpoint is the service's
+// base URL, such as https://s3.eu-west-1.amazonaws.com or
+// http://minio:9000. PathStyle addresses the bucket in the path rather
+// than the host name, as MinIO and most self-hosted services need.
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores objects in an S3-compatible bucket, signing each request with
+// SigV4. Objects are uploaded in a single PUT, which S3 limits to 5 GiB.
+type S3 struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	client    *http.Client
+	signer    signer
+
+	// now is replaceable for tests.
+	now func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: no bucket")
+	}
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3: no endpoint")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		pathStyle: cfg.PathStyle,
+		client:    client,
+		signer: signer{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			region:          cfg.Region,
+			service:         "s3",
+		},
+		now: time.Now,
+	}, nil
+}
+
+// url returns the URL of key, or of the bucket itself when key is empty.
+func (s *S3) url(key string) *url.URL {
+	u := *s.endpoint
+	p := u.Path
+	if s.pathStyle {
+		p += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	p += "/" + key
+	u.Path = p
+	u.RawPath = uriEncode(p, true)
+	return &u
+}
+
+// do signs and sends a request. Responses other than 2xx are turned into
+// errors, with 404s wrapping fs.ErrNotExist.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the exact encoding that was signed
+	req.URL = u
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		// A zero length with a body would otherwise be sent chunked
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+		payloadHash = unsignedPayload
+	}
+	s.signer.sign(req, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(method, u, resp)
+}
+
+type s3ErrorBody struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func s3Error(method string, u *url.URL, resp *http.Response) error {
+	var body s3ErrorBody
+	// HEAD responses and some proxies carry no error document
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) != nil || body.Code == "" {
+		body.Code = resp.Status
+	}
+	err := fmt.Errorf("s3: %s %s: %s %s", method, u.Path, body.Code, body.Message)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+	}
+	return err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2.
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+	token := ""
+	for {
+		u := s.url("")
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(q)
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid list response: %w", err)
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	if !ValidKey(key) {
+		return Object{}, invalidKey("stat", key)
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.url(key), nil, nil, 0)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, invalidKey("open", key)
+	}
+	header := http.Header{}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(key), header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	// A server that ignores Range would hand back the whole object
+	if header.Get("Range") != "" && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: GET %s: range not honoured", key)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return invalidKey("delete", key)
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.url(key), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !ValidKey(key) {
+		return invalidKey("put", key)
+	}
+	// http.Client closes a body it is given, which is not ours to close
+	resp, err := s.do(ctx, http.MethodPut, s.url(key), nil, io.NopCloser(r), size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignGet returns a URL anyone can download key from until expires
+// after signedAt.
+func (s *S3) PresignGet(key string, signedAt time.Time, expires time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", invalidKey("presign", key)
+	}
+	u := s.url(key)
+	s.signer.presign(u, signedAt, expires)
+	return u.String(), nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.bucket
+}
@@ -0,0 +1,162 @@
+// Package storage abstracts where timelapse files are kept, so old prints
+// can move from the local timelapse directory to object storage.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Object describes one stored file. Keys are slash-separated paths, like
+// the catalog's filenames.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is a flat namespace of files addressed by key. Missing objects
+// are reported with errors wrapping fs.ErrNotExist.
+type Storage interface {
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	// Open reads length bytes from offset, or to the end when length is
+	// negative.
+	Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Put stores size bytes read from r under key, replacing any object
+	// already there.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// Presigner is implemented by storage whose objects clients can download
+// directly. PresignGet returns a URL valid from signedAt for expires.
+type Presigner interface {
+	PresignGet(key string, signedAt time.Time, expires time.Duration) (string, error)
+}
+
+// ValidKey reports whether key is a clean relative path without hidden
+// components, so it maps onto a file on disk as safely as onto an object.
+func ValidKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, "\\\x00") || path.Clean(key) != key || path.IsAbs(key) {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidKey(op, key string) error {
+	return &fs.PathError{Op: op, Path: key, Err: fs.ErrInvalid}
+}
+
+// Sub returns the part of s under prefix, as a Storage whose keys are
+// relative to it. It stays a Presigner if s is one.
+func Sub(s Storage, prefix string) Storage {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return s
+	}
+	sub := subStorage{s: s, prefix: prefix + "/"}
+	if p, ok := s.(Presigner); ok {
+		return presignedSub{sub, p}
+	}
+	return sub
+}
+
+type subStorage struct {
+	s      Storage
+	prefix string
+}
+
+func (s subStorage) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects, err := s.s.List(ctx, s.prefix+prefix)
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, s.prefix)
+	}
+	return objects, err
+}
+
+func (s subStorage) Stat(ctx context.Context, key string) (Object, error) {
+	obj, err := s.s.Stat(ctx, s.prefix+key)
+	obj.Key = key
+	return obj, err
+}
+
+func (s subStorage) Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return s.s.Open(ctx, s.prefix+key, offset, length)
+}
+
+func (s subStorage) Delete(ctx context.Context, key string) error {
+	return s.s.Delete(ctx, s.prefix+key)
+}
+
+func (s subStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return s.s.Put(ctx, s.prefix+key, r, size)
+}
+
+func (s subStorage) String() string {
+	return fmt.Sprintf("%v/%s", s.s, strings.TrimSuffix(s.prefix, "/"))
+}
+
+type presignedSub struct {
+	subStorage
+	p Presigner
+}
+
+func (s presignedSub) PresignGet(key string, signedAt time.Time, expires time.Duration) (string, error) {
+	return s.p.PresignGet(s.prefix+key, signedAt, expires)
+}
+
+// FromURL opens the storage a URL names: file:///path for a local
+// directory, or s3://bucket/prefix for a bucket, with the endpoint and
+// credentials taken from cfg.
+func FromURL(raw string, cfg S3Config) (Storage, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %q must not name a host", raw)
+		}
+		return NewLocal(u.Path)
+	case "s3":
+		cfg.Bucket = u.Host
+		s3, err := NewS3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return Sub(s3, u.Path), nil
+	}
+	return nil, fmt.Errorf("unsupported storage URL %q (use file:// or s3://)", raw)
+}
+
+// ContextReader returns a reader that fails with ctx's error once ctx is
+// done, so a long copy stops with the request that started it.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
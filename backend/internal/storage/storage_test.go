@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStorage runs the same checks against every implementation.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	files := map[string]string{
+		"video_2024-07-01_10-00-00.mp4":         "first video",
+		"2024/07/video_2024-07-02_10-00-00.mp4": "second video",
+		"2024/07/video (1).mp4":                 "a copy",
+		"empty.mp4":                             "",
+	}
+	for key, data := range files {
+		if err := s.Put(ctx, key, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	objects, err := s.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+		if obj.Size != int64(len(files[obj.Key])) || obj.ModTime.IsZero() {
+			t.Errorf("unexpected object %+v", obj)
+		}
+	}
+	slices.Sort(keys)
+	if want := []string{"2024/07/video (1).mp4", "2024/07/video_2024-07-02_10-00-00.mp4", "empty.mp4", "video_2024-07-01_10-00-00.mp4"}; !slices.Equal(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+	if objects, err = s.List(ctx, "2024/"); err != nil || len(objects) != 2 {
+		t.Errorf("expected 2 objects under 2024/, got %v, %v", objects, err)
+	}
+
+	obj, err := s.Stat(ctx, "2024/07/video (1).mp4")
+	if err != nil || obj.Size != int64(len("a copy")) {
+		t.Errorf("unexpected stat %+v, %v", obj, err)
+	}
+	if _, err := s.Stat(ctx, "missing.mp4"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	ranges := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "second video"},
+		{7, -1, "video"},
+		{0, 6, "second"},
+		{7, 3, "vid"},
+		{7, 0, ""},
+	}
+	for _, r := range ranges {
+		rc, err := s.Open(ctx, "2024/07/video_2024-07-02_10-00-00.mp4", r.offset, r.length)
+		if err != nil {
+			t.Fatalf("open at %d+%d: %v", r.offset, r.length, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != r.want {
+			t.Errorf("open at %d+%d: expected %q, got %q, %v", r.offset, r.length, r.want, data, err)
+		}
+	}
+	if _, err := s.Open(ctx, "missing.mp4", 0, -1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	if err := s.Put(ctx, "video_2024-07-01_10-00-00.mp4", bytes.NewReader([]byte("replaced")), 8); err != nil {
+		t.Fatal(err)
+	}
+	if obj, _ := s.Stat(ctx, "video_2024-07-01_10-00-00.mp4"); obj.Size != 8 {
+		t.Errorf("expected the object to be replaced, got %+v", obj)
+	}
+
+	if err := s.Delete(ctx, "video_2024-07-01_10-00-00.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, "video_2024-07-01_10-00-00.mp4"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the object to be deleted, got %v", err)
+	}
+
+	for _, key := range []string{"", "../escape.mp4", "/abs.mp4", "a//b.mp4", ".hidden.mp4", "a/./b.mp4"} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), 1); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected %q to be rejected, got %v", key, err)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	// A short reader is not stored
+	if err := s.Put(context.Background(), "short.mp4", strings.NewReader("abc"), 10); err == nil {
+		t.Error("expected an error for a short body")
+	}
+	if _, err := s.Stat(context.Background(), "short.mp4"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no partial object, got %v", err)
+	}
+	if objects, _ := s.List(context.Background(), ""); len(objects) != 3 {
+		t.Errorf("expected temporary files to be cleaned up, got %+v", objects)
+	}
+}
+
+func TestS3(t *testing.T) {
+	fake := newFakeS3(t)
+	s, err := NewS3(fake.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+}
+
+func TestS3_BadCredentials(t *testing.T) {
+	fake := newFakeS3(t)
+	cfg := fake.config()
+	cfg.SecretAccessKey = "wrong"
+	s, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.List(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("expected a signature error, got %v", err)
+	}
+}
+
+func TestS3_PresignGet(t *testing.T) {
+	fake := newFakeS3(t)
+	s, err := NewS3(fake.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := "2024/video (1).mp4"
+	if err := s.Put(ctx, key, strings.NewReader("video"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := s.PresignGet(key, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "video" {
+		t.Errorf("expected the object from a presigned URL, got %d %q", resp.StatusCode, data)
+	}
+
+	expired, _ := s.PresignGet(key, time.Now().Add(-2*time.Hour), time.Hour)
+	if resp, err = http.Get(expired); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected an expired URL to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestSub(t *testing.T) {
+	fake := newFakeS3(t)
+	s3, err := NewS3(fake.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := Sub(s3, "/printers/x1c/")
+	testStorage(t, sub)
+
+	objects, err := s3.List(context.Background(), "")
+	if err != nil || len(objects) == 0 || !strings.HasPrefix(objects[0].Key, "printers/x1c/") {
+		t.Errorf("expected keys under the prefix, got %+v, %v", objects, err)
+	}
+	p, ok := sub.(Presigner)
+	if !ok {
+		t.Fatal("expected the sub storage to presign")
+	}
+	if u, _ := p.PresignGet("empty.mp4", time.Now(), time.Hour); !strings.Contains(u, "/timelapses/printers/x1c/empty.mp4?") {
+		t.Errorf("unexpected presigned URL %s", u)
+	}
+
+	local, _ := NewLocal(t.TempDir())
+	if _, ok := Sub(local, "x1c").(Presigner); ok {
+		t.Error("expected local storage not to presign")
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	dir := t.TempDir()
+	s, err := FromURL("file://"+dir, S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*Local); !ok {
+		t.Errorf("expected local storage, got %T", s)
+	}
+
+	s, err = FromURL("s3://timelapses/archive", S3Config{Endpoint: "https://s3.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.(presignedSub).prefix; got != "archive/" {
+		t.Errorf("expected the path as prefix, got %q", got)
+	}
+
+	for _, raw := range []string{"ftp://host/dir", "s3://bucket", "file://host/dir"} {
+		if _, err := FromURL(raw, S3Config{}); err == nil {
+			t.Errorf("expected %s to be rejected", raw)
+		}
+	}
+}
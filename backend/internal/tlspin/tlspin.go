@@ -0,0 +1,201 @@
+// Package tlspin pins the certificates of servers that cannot be verified
+// against a CA, such as printers presenting self-signed certificates. The
+// first certificate seen for an address is trusted and its public key
+// recorded; later connections presenting a different key are refused until
+// the change is approved or the pin reset.
+package tlspin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMismatch is returned for a certificate whose key differs from the
+	// pinned one.
+	ErrMismatch = errors.New("certificate does not match the pinned key")
+	// ErrNotFound is returned for an address without a pin.
+	ErrNotFound = errors.New("no pin for address")
+	// ErrNoPending is returned when approving an address that has not
+	// presented a different key.
+	ErrNoPending = errors.New("no pending certificate change")
+)
+
+// Pin is the trusted key for an address. Pending is the key last refused,
+// awaiting approval.
+type Pin struct {
+	Addr        string    `json:"addr"`
+	Fingerprint string    `json:"fingerprint"`
+	PinnedAt    time.Time `json:"pinnedAt"`
+	Pending     string    `json:"pending,omitempty"`
+	PendingAt   time.Time `json:"pendingAt,omitzero"`
+}
+
+// Fingerprint returns the hex SHA-256 of a certificate's
+// SubjectPublicKeyInfo, as printed by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | sha256sum
+func Fingerprint(state tls.ConnectionState) (string, error) {
+	if len(state.PeerCertificates) == 0 {
+		return "", errors.New("no peer certificate")
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Store keeps pins in a JSON file, read on first use.
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	pins   map[string]Pin
+	loaded bool
+}
+
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// load reads the pin file. A corrupt file fails every verification rather
+// than trusting whatever is presented next. The caller holds s.mu.
+func (s *Store) load() error {
+	if s.loaded {
+		return nil
+	}
+	pins := make(map[string]Pin)
+	data, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &pins); err != nil {
+			return fmt.Errorf("corrupt pin file %s: %w", s.path, err)
+		}
+	}
+	s.pins, s.loaded = pins, true
+	return nil
+}
+
+// save writes the pins. The caller holds s.mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Verify checks the certificate presented by addr against its pin,
+// pinning it if addr has none yet. A different key is recorded as pending
+// and refused with ErrMismatch.
+func (s *Store) Verify(addr string, state tls.ConnectionState) error {
+	fp, err := Fingerprint(state)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	pin, ok := s.pins[addr]
+	switch {
+	case !ok:
+		s.pins[addr] = Pin{Addr: addr, Fingerprint: fp, PinnedAt: time.Now()}
+		return s.save()
+	case pin.Fingerprint == fp:
+		return nil
+	}
+
+	if pin.Pending != fp {
+		pin.Pending, pin.PendingAt = fp, time.Now()
+		s.pins[addr] = pin
+		if err := s.save(); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%s presented key %s, pinned %s: %w", addr, fp, pin.Fingerprint, ErrMismatch)
+}
+
+// Config returns TLS settings that skip CA verification and check the
+// server's key against the pin for addr instead. addr is the control
+// address, so data connections to other ports share its pin.
+func (s *Store) Config(addr string) *tls.Config {
+	return &tls.Config{
+		// Verified by VerifyConnection, including on resumed sessions
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return s.Verify(addr, state)
+		},
+	}
+}
+
+// List returns the pins sorted by address.
+func (s *Store) List() ([]Pin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	pins := make([]Pin, 0, len(s.pins))
+	for _, pin := range s.pins {
+		pins = append(pins, pin)
+	}
+	slices.SortFunc(pins, func(a, b Pin) int { return strings.Compare(a.Addr, b.Addr) })
+	return pins, nil
+}
+
+// Approve trusts the pending key for addr. fingerprint must match it, so
+// the key approved is the one that was checked.
+func (s *Store) Approve(addr, fingerprint string) (Pin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Pin{}, err
+	}
+
+	pin, ok := s.pins[addr]
+	if !ok {
+		return Pin{}, ErrNotFound
+	}
+	if pin.Pending == "" || !strings.EqualFold(pin.Pending, fingerprint) {
+		return Pin{}, ErrNoPending
+	}
+	pin = Pin{Addr: addr, Fingerprint: pin.Pending, PinnedAt: time.Now()}
+	s.pins[addr] = pin
+	return pin, s.save()
+}
+
+// Reset forgets the pin for addr, so the next certificate it presents is
+// trusted.
+func (s *Store) Reset(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if _, ok := s.pins[addr]; !ok {
+		return ErrNotFound
+	}
+	delete(s.pins, addr)
+	return s.save()
+}
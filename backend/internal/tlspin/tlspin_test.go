@@ -0,0 +1,108 @@
+package tlspin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codyseavey/3d-printer/backend/internal/ftps/ftpstest"
+)
+
+func state(t *testing.T) tls.ConnectionState {
+	t.Helper()
+	return tls.ConnectionState{PeerCertificates: []*x509.Certificate{ftpstest.Certificate(t).Leaf}}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.json")
+	s := New(path)
+	first, second := state(t), state(t)
+	fp1, _ := Fingerprint(first)
+	fp2, _ := Fingerprint(second)
+
+	if err := s.Verify("printer:990", first); err != nil {
+		t.Fatalf("expected the first certificate to be trusted: %v", err)
+	}
+	if err := s.Verify("printer:990", first); err != nil {
+		t.Fatalf("expected the pinned certificate to pass: %v", err)
+	}
+	if err := s.Verify("printer:990", second); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch for a changed key, got %v", err)
+	}
+	// Pins are per address
+	if err := s.Verify("other:990", second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refusal is remembered across restarts
+	s = New(path)
+	pins, err := s.List()
+	if err != nil || len(pins) != 2 {
+		t.Fatalf("expected 2 pins, got %+v, %v", pins, err)
+	}
+	if p := pins[1]; p.Addr != "printer:990" || p.Fingerprint != fp1 || p.Pending != fp2 || p.PendingAt.IsZero() {
+		t.Errorf("unexpected pin %+v", p)
+	}
+	if err := s.Verify("printer:990", second); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected the changed key to stay refused, got %v", err)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "pins.json"))
+	first, second := state(t), state(t)
+	fp1, _ := Fingerprint(first)
+	fp2, _ := Fingerprint(second)
+
+	if _, err := s.Approve("printer:990", fp2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	s.Verify("printer:990", first)
+	if _, err := s.Approve("printer:990", fp1); !errors.Is(err, ErrNoPending) {
+		t.Errorf("expected ErrNoPending without a change, got %v", err)
+	}
+	s.Verify("printer:990", second)
+	if _, err := s.Approve("printer:990", fp1); !errors.Is(err, ErrNoPending) {
+		t.Errorf("expected ErrNoPending for the wrong fingerprint, got %v", err)
+	}
+
+	pin, err := s.Approve("printer:990", fp2)
+	if err != nil || pin.Fingerprint != fp2 || pin.Pending != "" {
+		t.Fatalf("unexpected approval %+v, %v", pin, err)
+	}
+	if err := s.Verify("printer:990", second); err != nil {
+		t.Errorf("expected the approved key to pass: %v", err)
+	}
+	if err := s.Verify("printer:990", first); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected the old key to be refused, got %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "pins.json"))
+	first, second := state(t), state(t)
+
+	if err := s.Reset("printer:990"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	s.Verify("printer:990", first)
+	if err := s.Reset("printer:990"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify("printer:990", second); err != nil {
+		t.Errorf("expected a new key to be trusted after a reset: %v", err)
+	}
+}
+
+func TestVerify_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(path).Verify("printer:990", state(t)); err == nil {
+		t.Fatal("expected a corrupt pin file to refuse connections")
+	}
+}
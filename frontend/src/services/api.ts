@@ -1,4 +1,4 @@
-import type { Timelapse, StreamStatus } from '../types/timelapse'
+import type { LibraryStats, PrinterList, TimelapseDetail, TimelapsePage, TimelapseQuery, StreamStatus, SyncStatus, TranscodeStatus } from '../types/timelapse'
 
 const BASE_URL = '/api'
 
@@ -10,10 +10,56 @@ async function fetchJSON<T>(path: string): Promise<T> {
   return response.json() as Promise<T>
 }
 
-export async function getTimelapses(): Promise<Timelapse[]> {
-  return fetchJSON<Timelapse[]>('/timelapses')
+export async function getTimelapses(query: TimelapseQuery = {}): Promise<TimelapsePage> {
+  const params = new URLSearchParams()
+  if (query.page) params.set('page', String(query.page))
+  if (query.limit) params.set('limit', String(query.limit))
+  if (query.sort) params.set('sort', query.sort)
+  if (query.minSize) params.set('min_size', String(query.minSize))
+  if (query.from) params.set('from', query.from)
+  if (query.to) params.set('to', query.to)
+  if (query.ext && query.ext.length > 0) params.set('ext', query.ext.join(','))
+  if (query.folder) params.set('folder', query.folder)
+  if (query.health && query.health.length > 0) params.set('health', query.health.join(','))
+  for (const tag of query.tags ?? []) params.append('tag', tag)
+  if (query.starred !== undefined) params.set('starred', String(query.starred))
+  if (query.minRating) params.set('min_rating', String(query.minRating))
+
+  const qs = params.toString()
+  return fetchJSON<TimelapsePage>(qs ? `/timelapses?${qs}` : '/timelapses')
+}
+
+export async function getTimelapse(filename: string): Promise<TimelapseDetail> {
+  return fetchJSON<TimelapseDetail>(`/timelapses/${encodeURIComponent(filename)}`)
+}
+
+export async function getTranscodeStatus(filename: string): Promise<TranscodeStatus> {
+  return fetchJSON<TranscodeStatus>(`/timelapses/${encodeURIComponent(filename)}/transcode`)
+}
+
+export async function startTranscode(filename: string): Promise<TranscodeStatus> {
+  const response = await fetch(`${BASE_URL}/timelapses/${encodeURIComponent(filename)}/transcode`, { method: 'POST' })
+  if (response.status === 401 || response.status === 403) {
+    throw new Error('Only an admin can convert videos.')
+  }
+  if (!response.ok) {
+    throw new Error('Failed to start conversion. Please try again later.')
+  }
+  return response.json() as Promise<TranscodeStatus>
 }
 
 export async function getStreamStatus(): Promise<StreamStatus> {
   return fetchJSON<StreamStatus>('/stream/status')
 }
+
+export async function getPrinters(): Promise<PrinterList> {
+  return fetchJSON<PrinterList>('/printers')
+}
+
+export async function getStats(): Promise<LibraryStats> {
+  return fetchJSON<LibraryStats>('/stats')
+}
+
+export async function getSyncStatus(): Promise<SyncStatus> {
+  return fetchJSON<SyncStatus>('/sync/status')
+}
@@ -1,56 +1,40 @@
 import { defineStore } from 'pinia'
-import { getTimelapses } from '../services/api'
-import type { Timelapse } from '../types/timelapse'
+import { getTimelapse, getTimelapses } from '../services/api'
+import type { SortOrder, Timelapse, TimelapseDetail } from '../types/timelapse'
 
 const PAGE_SIZE = 24
-const MIN_FILE_SIZE = 100 * 1024 // 100KB
-
-type SortOrder = 'newest' | 'oldest'
 
 export const useTimelapsesStore = defineStore('timelapses', {
   state: () => ({
-    allItems: [] as Timelapse[],
+    items: [] as Timelapse[],
+    detail: null as TimelapseDetail | null,
     sortOrder: 'newest' as SortOrder,
     currentPage: 1,
+    totalPages: 1,
+    totalCount: 0,
+    loaded: false,
     loading: false,
     error: null as string | null
   }),
 
-  getters: {
-    filteredItems: (state): Timelapse[] => {
-      const items = state.allItems.filter(t => t.size >= MIN_FILE_SIZE)
-
-      // Explicit sort by date instead of relying on backend order
-      items.sort((a, b) => {
-        const dateA = new Date(a.date).getTime()
-        const dateB = new Date(b.date).getTime()
-        return state.sortOrder === 'newest' ? dateB - dateA : dateA - dateB
-      })
-
-      return items
-    },
-
-    totalPages(): number {
-      return Math.max(1, Math.ceil(this.filteredItems.length / PAGE_SIZE))
-    },
-
-    paginatedItems(): Timelapse[] {
-      const start = (this.currentPage - 1) * PAGE_SIZE
-      return this.filteredItems.slice(start, start + PAGE_SIZE)
-    },
-
-    totalCount(): number {
-      return this.filteredItems.length
-    }
-  },
-
   actions: {
+    // Filtering, sorting and pagination happen server-side so only the
+    // visible page is downloaded.
     async fetchTimelapses() {
       this.loading = true
       this.error = null
       try {
-        const data = await getTimelapses()
-        this.allItems = Array.isArray(data) ? data : []
+        const page = await getTimelapses({
+          page: this.currentPage,
+          limit: PAGE_SIZE,
+          sort: this.sortOrder,
+          // Half-downloaded and broken files cannot be played
+          health: ['healthy']
+        })
+        this.items = Array.isArray(page.items) ? page.items : []
+        this.totalPages = Math.max(1, page.totalPages)
+        this.totalCount = page.total
+        this.loaded = true
       } catch (err) {
         this.error = err instanceof Error ? err.message : 'Failed to load timelapses'
       } finally {
@@ -58,14 +42,26 @@ export const useTimelapsesStore = defineStore('timelapses', {
       }
     },
 
-    toggleSort() {
+    // Loads a single timelapse for deep links to items outside the current page
+    async fetchTimelapse(filename: string) {
+      if (this.detail?.filename === filename) return
+      try {
+        this.detail = await getTimelapse(filename)
+      } catch {
+        this.detail = null
+      }
+    },
+
+    async toggleSort() {
       this.sortOrder = this.sortOrder === 'newest' ? 'oldest' : 'newest'
       this.currentPage = 1
+      await this.fetchTimelapses()
     },
 
-    setPage(page: number) {
-      if (page >= 1 && page <= this.totalPages) {
+    async setPage(page: number) {
+      if (page >= 1 && page <= this.totalPages && page !== this.currentPage) {
         this.currentPage = page
+        await this.fetchTimelapses()
       }
     }
   }
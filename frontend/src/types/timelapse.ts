@@ -1,12 +1,167 @@
 export interface Timelapse {
   filename: string
+  folder: string
   url: string
   thumbnailUrl: string
   size: number
   date: string
+  dateSource: 'filename' | 'metadata' | 'mtime'
+  duration?: number
+  width?: number
+  height?: number
+  codec?: string
+  fps?: number
+  creationTime?: string
+  health: HealthState
+  healthReason?: string
+  playableUrl: string
+  transcodeState: TranscodeState
+  spriteUrl?: string
+  vttUrl?: string
+  remote?: boolean
+  clip?: ClipInfo
+  tags?: string[]
+  notes?: string
+  starred?: boolean
+  rating?: number
+  updatedAt?: string
+}
+
+export interface ClipInfo {
+  source: string
+  start: number
+  end: number
+}
+
+export type HealthState = 'healthy' | 'incomplete' | 'corrupt'
+
+export type TranscodeState = 'native' | 'none' | 'queued' | 'running' | 'ready' | 'failed' | 'unavailable'
+
+export interface TranscodeStatus {
+  filename: string
+  state: TranscodeState
+  playableUrl: string
+  error?: string
+}
+
+export interface TimelapseDetail extends Timelapse {
+  thumbnails: string[]
+  previous: Timelapse | null
+  next: Timelapse | null
+}
+
+export interface TimelapsePage {
+  items: Timelapse[]
+  page: number
+  limit: number
+  total: number
+  totalUnfiltered: number
+  totalPages: number
+  next?: string
+  prev?: string
+  generation: number
+  scannedAt: string
+}
+
+export type SortOrder = 'newest' | 'oldest' | 'size'
+
+export interface TimelapseQuery {
+  page?: number
+  limit?: number
+  sort?: SortOrder
+  minSize?: number
+  from?: string
+  to?: string
+  ext?: string[]
+  folder?: string
+  health?: HealthState[]
+  tags?: string[]
+  starred?: boolean
+  minRating?: number
 }
 
 export interface StreamStatus {
   online: boolean
   lastUpdated: string
 }
+
+export interface Printer {
+  id: string
+  name: string
+  streamUrl: string
+  stream: StreamStatus
+  timelapseCount: number
+  timelapseBytes: number
+  latestTimelapse?: string
+}
+
+export interface PrinterList {
+  printers: Printer[]
+  online: number
+  timelapseCount: number
+  timelapseBytes: number
+}
+
+export interface PeriodCount {
+  period: string
+  count: number
+  bytes: number
+}
+
+export interface FormatCount {
+  format: string
+  count: number
+  bytes: number
+}
+
+export interface StorageUsage {
+  totalBytes: number
+  usedBytes: number
+  freeBytes: number
+}
+
+export interface LibraryStats {
+  count: number
+  totalBytes: number
+  averageBytes: number
+  oldest?: string
+  newest?: string
+  perDay: PeriodCount[]
+  perWeek: PeriodCount[]
+  perMonth: PeriodCount[]
+  formats: FormatCount[]
+  storage: StorageUsage | null
+  generation: number
+}
+
+export interface SyncedFile {
+  filename: string
+  size: number
+}
+
+export interface SyncRun {
+  startedAt: string
+  finishedAt: string
+  files: SyncedFile[]
+  bytes: number
+  error?: string
+}
+
+export interface SyncProgress {
+  startedAt: string
+  file?: string
+  fileBytes: number
+  fileSize: number
+  files: number
+  bytes: number
+}
+
+export interface SyncStatus {
+  enabled: boolean
+  running: boolean
+  progress: SyncProgress | null
+  lastRun: SyncRun | null
+  nextRun?: string
+  lastError?: string
+  lastErrorAt?: string
+}
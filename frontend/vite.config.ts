@@ -9,6 +9,10 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
       },
+      '/feeds': {
+        target: 'http://localhost:8080',
+        changeOrigin: true,
+      },
       '/live': {
         target: 'http://localhost:8080',
         changeOrigin: true,